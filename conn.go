@@ -1,9 +1,10 @@
 package stomp
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
-	"log"
 	"net"
 	"strconv"
 	"sync"
@@ -16,24 +17,43 @@ import (
 // to avoid premature disconnections due to network latency.
 const DefaultHeartBeatError = 5 * time.Second
 
+// Default limits on the size of frames received from the STOMP server,
+// which are the limits that the server package applies by default to
+// frames received from clients. Override with ConnOpt.FrameLimits.
+const (
+	DefaultMaxHeaders      = 1000
+	DefaultMaxHeaderLength = 64 * 1024
+	DefaultMaxBodyLength   = 16 * 1024 * 1024
+)
+
 // A Conn is a connection to a STOMP server. Create a Conn using either
 // the Dial or Connect function.
 type Conn struct {
-	conn         io.ReadWriteCloser
+	writeCh chan writeRequest
+	options *connOptions
+	stop    chan struct{} // closed by Disconnect and MustDisconnect
+	stopped sync.Once
+	done    chan struct{} // closed when the processLoop go-routine exits
+
+	// Owned by the processLoop go-routine once it has started.
 	readCh       chan *frame.Frame
-	writeCh      chan writeRequest
-	version      Version
-	session      string
-	server       string
 	readTimeout  time.Duration
 	writeTimeout time.Duration
-	closed       bool
-	options      *connOptions
+
+	// Fields that change when the connection is re-established.
+	mutex     sync.Mutex
+	conn      io.ReadWriteCloser
+	version   Version
+	session   string
+	server    string
+	closed    bool
+	validator Validator // validates frames sent, if requested
 }
 
 type writeRequest struct {
 	Frame *frame.Frame      // frame to send
-	C     chan *frame.Frame // response channel
+	C     chan *frame.Frame // response channel for RECEIPT (or ERROR)
+	Sub   chan *frame.Frame // subscription channel, SUBSCRIBE frames only
 }
 
 // Dial creates a network connection to a STOMP server and performs
@@ -41,7 +61,42 @@ type writeRequest struct {
 // STOMP server is specified by network and addr. STOMP protocol
 // options can be specified in opts.
 func Dial(network, addr string, opts ...func(*Conn) error) (*Conn, error) {
-	c, err := net.Dial(network, addr)
+	return DialContext(context.Background(), network, addr, opts...)
+}
+
+// DialContext is like Dial, but uses ctx to bound the time taken to
+// create the network connection and perform the STOMP connect protocol
+// sequence. If ctx is cancelled or its deadline expires before the
+// connection is established, the network connection is closed and
+// ctx.Err() is returned. Once DialContext has returned successfully,
+// cancelling ctx has no effect on the connection.
+func DialContext(ctx context.Context, network, addr string, opts ...func(*Conn) error) (*Conn, error) {
+	var dialer net.Dialer
+	return dial(ctx, dialer.DialContext, network, addr, opts)
+}
+
+// DialTLS is like Dial, but creates a TLS connection to the STOMP server
+// using the specified TLS configuration. A nil config is equivalent to the
+// zero configuration. If config.ServerName is empty, the host name in addr
+// is used to verify the server's certificate. To authenticate using a client
+// certificate, include it in config.Certificates.
+func DialTLS(network, addr string, config *tls.Config, opts ...func(*Conn) error) (*Conn, error) {
+	return DialTLSContext(context.Background(), network, addr, config, opts...)
+}
+
+// DialTLSContext is like DialTLS, but uses ctx to bound the time taken to
+// create the network connection, complete the TLS handshake and perform
+// the STOMP connect protocol sequence. See DialContext.
+func DialTLSContext(ctx context.Context, network, addr string, config *tls.Config, opts ...func(*Conn) error) (*Conn, error) {
+	dialer := &tls.Dialer{Config: config}
+	return dial(ctx, dialer.DialContext, network, addr, opts)
+}
+
+// dial uses dialFunc to create the network connection to addr, and then
+// performs the STOMP connect protocol sequence. If a reconnect policy has
+// been specified without a dial function, dialFunc is used to reconnect.
+func dial(ctx context.Context, dialFunc func(ctx context.Context, network, addr string) (net.Conn, error), network, addr string, opts []func(*Conn) error) (*Conn, error) {
+	c, err := dialFunc(ctx, network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +111,18 @@ func Dial(network, addr string, opts ...func(*Conn) error) (*Conn, error) {
 	// so that if host has been explicitly specified it will override.
 	opts = append([](func(*Conn) error){ConnOpt.Host(host)}, opts...)
 
-	return Connect(c, opts...)
+	// If a reconnect policy has been specified without a dial function,
+	// reconnect to the same network address.
+	opts = append(opts, func(c *Conn) error {
+		if c.options.Reconnect != nil && c.options.Reconnect.Dial == nil {
+			c.options.Reconnect.Dial = func() (io.ReadWriteCloser, error) {
+				return dialFunc(context.Background(), network, addr)
+			}
+		}
+		return nil
+	})
+
+	return ConnectContext(ctx, c, opts...)
 }
 
 // Connect creates a STOMP connection and performs the STOMP connect
@@ -64,13 +130,12 @@ func Dial(network, addr string, opts ...func(*Conn) error) (*Conn, error) {
 // been created by the program. The opts parameter provides the
 // opportunity to specify STOMP protocol options.
 func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error) {
-	reader := frame.NewReader(conn)
-	writer := frame.NewWriter(conn)
-
 	c := &Conn{
 		conn:    conn,
 		readCh:  make(chan *frame.Frame, 8),
 		writeCh: make(chan writeRequest, 8),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 
 	options, err := newConnOptions(c, opts)
@@ -92,46 +157,88 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 		}
 	}
 
-	connectFrame, err := options.NewFrame()
+	if options.Reconnect != nil && options.Reconnect.Dial == nil {
+		return nil, ErrReconnectDialMissing
+	}
+
+	reader, writer, err := c.connect(conn, options)
 	if err != nil {
 		return nil, err
 	}
 
+	// Retain the options, as the connect sequence is repeated using
+	// the same options if the connection needs to be re-established.
+	c.options = options
+
+	go readLoop(c.readCh, reader)
+	go processLoop(c, writer)
+
+	return c, nil
+}
+
+// connect performs the STOMP connect protocol sequence on conn using
+// the specified options. Returns a reader and writer for the connection
+// if the STOMP server accepts the connection.
+func (c *Conn) connect(conn io.ReadWriteCloser, options *connOptions) (*frame.Reader, *frame.Writer, error) {
+	reader := frame.NewReader(conn)
+	reader.SetLimits(options.FrameLimits)
+	writer := frame.NewWriter(conn)
+
+	connectFrame, err := options.NewFrame()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	err = writer.Write(connectFrame)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	response, err := reader.Read()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if response.Command != frame.CONNECTED {
-		return nil, newError(response)
+	if response == nil {
+		// heart-beat received instead of the CONNECTED frame
+		return nil, nil, ErrInvalidFrameFormat
 	}
 
-	c.server = response.Header.Get(frame.Server)
-	c.session = response.Header.Get(frame.Session)
+	if response.Command != frame.CONNECTED {
+		return nil, nil, newError(response)
+	}
 
+	// no version in the response, so assume version 1.0
+	version := V10
 	if versionString := response.Header.Get(frame.Version); versionString != "" {
-		version := Version(versionString)
+		version = Version(versionString)
 		if err = version.CheckSupported(); err != nil {
-			return nil, Error{
+			return nil, nil, Error{
 				Message: err.Error(),
 				Frame:   response,
 			}
 		}
-		c.version = version
-	} else {
-		// no version in the response, so assume version 1.0
-		c.version = V10
+	}
+	reader.SetVersion(string(version))
+	writer.SetVersion(string(version))
+	var validator Validator
+	if options.Validate {
+		validator = NewValidator(version)
 	}
 
+	c.mutex.Lock()
+	c.server = response.Header.Get(frame.Server)
+	c.session = response.Header.Get(frame.Session)
+	c.version = version
+	c.validator = validator
+	c.mutex.Unlock()
+
+	c.readTimeout = 0
+	c.writeTimeout = 0
 	if heartBeat, ok := response.Header.Contains(frame.HeartBeat); ok {
 		readTimeout, writeTimeout, err := frame.ParseHeartBeat(heartBeat)
 		if err != nil {
-			return nil, Error{
+			return nil, nil, Error{
 				Message: err.Error(),
 				Frame:   response,
 			}
@@ -159,16 +266,50 @@ func Connect(conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error)
 	// Neither options are particularly elegant, so wait until
 	// there is a real need for this.
 
-	go readLoop(c, reader)
-	go processLoop(c, writer)
+	return reader, writer, nil
+}
 
-	return c, nil
+// ConnectContext is like Connect, but uses ctx to bound the time taken to
+// perform the STOMP connect protocol sequence. If ctx is cancelled or its
+// deadline expires before the STOMP server responds, conn is closed and
+// ctx.Err() is returned. Once ConnectContext has returned successfully,
+// cancelling ctx has no effect on the connection.
+func ConnectContext(ctx context.Context, conn io.ReadWriteCloser, opts ...func(*Conn) error) (*Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Closing the connection is the only way to interrupt the
+	// connect sequence if it is blocked reading or writing.
+	done := make(chan struct{})
+	cancelled := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			cancelled <- true
+		case <-done:
+			cancelled <- false
+		}
+	}()
+
+	c, err := Connect(conn, opts...)
+	close(done)
+	if <-cancelled {
+		if c != nil {
+			c.MustDisconnect()
+		}
+		return nil, ctx.Err()
+	}
+	return c, err
 }
 
 // Version returns the version of the STOMP protocol that
 // is being used to communicate with the STOMP server. This
 // version is negotiated with the server during the connect sequence.
 func (c *Conn) Version() Version {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.version
 }
 
@@ -177,6 +318,8 @@ func (c *Conn) Version() Version {
 // If the STOMP server does not return a session header entry,
 // this value will be a blank string.
 func (c *Conn) Session() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.session
 }
 
@@ -185,33 +328,114 @@ func (c *Conn) Session() string {
 // If the STOMP server does not return a server header entry,
 // this value will be a blank string.
 func (c *Conn) Server() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.server
 }
 
+// isClosed reports whether the connection has been closed by the
+// client program, or after the STOMP server sent an ERROR frame.
+func (c *Conn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// close marks the connection as closed and closes the network
+// connection. Returns false if it was already closed.
+func (c *Conn) close() (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return false, nil
+	}
+	c.closed = true
+	return true, c.conn.Close()
+}
+
+// isStopped reports whether Disconnect or MustDisconnect has been called.
+func (c *Conn) isStopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopReconnecting prevents any further attempt to re-establish the
+// connection, and interrupts an attempt in progress.
+func (c *Conn) stopReconnecting() {
+	c.stopped.Do(func() { close(c.stop) })
+}
+
 // readLoop is a goroutine that reads frames from the
 // reader and places them onto a channel for processing
 // by the processLoop goroutine
-func readLoop(c *Conn, reader *frame.Reader) {
+func readLoop(ch chan *frame.Frame, reader *frame.Reader) {
 	for {
 		f, err := reader.Read()
 		if err != nil {
-			close(c.readCh)
+			switch err {
+			case frame.ErrTooManyHeaders, frame.ErrHeaderTooLong, frame.ErrBodyTooLarge:
+				// report the frame limit exceeded, which closes the connection
+				ch <- frame.New(frame.ERROR, frame.Message, err.Error())
+			}
+			close(ch)
 			return
 		}
-		c.readCh <- f
+		ch <- f
 	}
 }
 
 // processLoop is a goroutine that handles io with
 // the server.
 func processLoop(c *Conn, writer *frame.Writer) {
-	channels := make(map[string]chan *frame.Frame)
+	defer close(c.done)
+
+	// channels waiting for a RECEIPT, keyed by receipt-id
+	receipts := make(map[string]chan *frame.Frame)
+
+	// channels for active subscriptions, keyed by subscription id
+	subs := make(map[string]chan *frame.Frame)
+
+	// subscription ids for UNSUBSCRIBE frames awaiting a RECEIPT, keyed by
+	// receipt-id; the subscription channel is closed when the RECEIPT arrives
+	unsubscribing := make(map[string]string)
+
+	// SUBSCRIBE frames for active subscriptions, keyed by subscription id.
+	// Used to re-issue subscriptions if the connection is re-established.
+	subscribed := make(map[string]*frame.Frame)
+
+	// set once a DISCONNECT frame has been sent to the server
+	disconnecting := false
 
 	var readTimeoutChannel <-chan time.Time
 	var readTimer *time.Timer
 	var writeTimeoutChannel <-chan time.Time
 	var writeTimer *time.Timer
 
+	// Called when the connection with the server has failed. Returns true
+	// if the connection has been re-established and processing can continue.
+	recovered := func(err error) bool {
+		if c.options.Reconnect == nil || disconnecting || c.isStopped() {
+			sendError(receipts, err)
+			sendError(subs, err)
+			return false
+		}
+		w, err := c.reconnect(err, receipts, subs, subscribed)
+		if err != nil {
+			sendError(receipts, err)
+			sendError(subs, err)
+			return false
+		}
+		unsubscribing = make(map[string]string)
+		writer = w
+		readTimeoutChannel = nil
+		writeTimeoutChannel = nil
+		return true
+	}
+
 	for {
 		if c.readTimeout > 0 && readTimer == nil {
 			readTimer := time.NewTimer(c.readTimeout)
@@ -226,15 +450,18 @@ func processLoop(c *Conn, writer *frame.Writer) {
 		case <-readTimeoutChannel:
 			// read timeout, close the connection
 			err := newErrorMessage("read timeout")
-			sendError(channels, err)
-			return
+			if !recovered(err) {
+				return
+			}
 
 		case <-writeTimeoutChannel:
 			// write timeout, send a heart-beat frame
 			err := writer.Write(nil)
 			if err != nil {
-				sendError(channels, err)
-				return
+				if !recovered(err) {
+					return
+				}
+				continue
 			}
 			writeTimer = nil
 			writeTimeoutChannel = nil
@@ -249,8 +476,10 @@ func processLoop(c *Conn, writer *frame.Writer) {
 
 			if !ok {
 				err := newErrorMessage("connection closed")
-				sendError(channels, err)
-				return
+				if !recovered(err) {
+					return
+				}
+				continue
 			}
 
 			if f == nil {
@@ -261,35 +490,52 @@ func processLoop(c *Conn, writer *frame.Writer) {
 			switch f.Command {
 			case frame.RECEIPT:
 				if id, ok := f.Header.Contains(frame.ReceiptId); ok {
-					if ch, ok := channels[id]; ok {
+					if subId, ok := unsubscribing[id]; ok {
+						delete(unsubscribing, id)
+						if ch, ok := subs[subId]; ok {
+							delete(subs, subId)
+							close(ch)
+						}
+					}
+					if ch, ok := receipts[id]; ok {
 						ch <- f
-						delete(channels, id)
+						delete(receipts, id)
 						close(ch)
 					}
 				} else {
 					err := &Error{Message: "missing receipt-id", Frame: f}
-					sendError(channels, err)
+					sendError(receipts, err)
+					sendError(subs, err)
 					return
 				}
 
 			case frame.ERROR:
-				log.Println("received ERROR; Closing underlying connection")
-				for _, ch := range channels {
+				c.options.Logger.Error("stomp: received ERROR; closing connection",
+					"session", c.Session(),
+					"message", f.Header.Get(frame.Message))
+				// Every channel receives the ERROR frame. If the frame has a
+				// receipt-id header, the caller waiting on the matching receipt
+				// can tell that its frame caused the error.
+				for _, ch := range receipts {
+					ch <- f
+					close(ch)
+				}
+				for _, ch := range subs {
 					ch <- f
 					close(ch)
 				}
 
-				c.closed = true
-				c.conn.Close()
-
+				c.close()
 				return
 
 			case frame.MESSAGE:
 				if id, ok := f.Header.Contains(frame.Subscription); ok {
-					if ch, ok := channels[id]; ok {
+					if ch, ok := subs[id]; ok {
 						ch <- f
 					} else {
-						log.Println("ignored MESSAGE for subscription", id)
+						c.options.Logger.Warn("stomp: ignored MESSAGE for unknown subscription",
+							"session", c.Session(),
+							"subscription", id)
 					}
 				}
 			}
@@ -302,39 +548,52 @@ func processLoop(c *Conn, writer *frame.Writer) {
 				writeTimeoutChannel = nil
 			}
 			if !ok {
-				sendError(channels, errors.New("write channel closed"))
+				err := errors.New("write channel closed")
+				sendError(receipts, err)
+				sendError(subs, err)
 				return
 			}
 			if req.C != nil {
 				if receipt, ok := req.Frame.Header.Contains(frame.Receipt); ok {
 					// remember the channel for this receipt
-					channels[receipt] = req.C
+					receipts[receipt] = req.C
 				}
 			}
 
 			switch req.Frame.Command {
 			case frame.SUBSCRIBE:
 				id, _ := req.Frame.Header.Contains(frame.Id)
-				channels[id] = req.C
+				subs[id] = req.Sub
+				subscribed[id] = req.Frame.Clone()
+				subscribed[id].Header.Del(frame.Receipt)
 			case frame.UNSUBSCRIBE:
 				id, _ := req.Frame.Header.Contains(frame.Id)
-				// is this trying to be too clever -- add a receipt
-				// header so that when the server responds with a
-				// RECEIPT frame, the corresponding channel will be closed
-				req.Frame.Header.Set(frame.Receipt, id)
+				delete(subscribed, id)
+				// Make sure there is a receipt header, so that when the
+				// server responds with a RECEIPT frame, the corresponding
+				// subscription channel will be closed.
+				receipt, ok := req.Frame.Header.Contains(frame.Receipt)
+				if !ok {
+					receipt = allocateId()
+					req.Frame.Header.Set(frame.Receipt, receipt)
+				}
+				unsubscribing[receipt] = id
+			case frame.DISCONNECT:
+				disconnecting = true
 			}
 
 			// frame to send
 			err := writer.Write(req.Frame)
 			if err != nil {
-				sendError(channels, err)
-				return
+				if !recovered(err) {
+					return
+				}
 			}
 		}
 	}
 }
 
-// Send an error to all receipt channels.
+// Send an error to all channels in the map.
 func sendError(m map[string]chan *frame.Frame, err error) {
 	frame := frame.New(frame.ERROR, frame.Message, err.Error())
 	for _, ch := range m {
@@ -349,39 +608,64 @@ func sendError(m map[string]chan *frame.Frame, err error) {
 // with the STOMP server is closed and any further attempt to write
 // to the server will fail.
 func (c *Conn) Disconnect() error {
-	if c.closed {
+	return c.DisconnectContext(context.Background())
+}
+
+// DisconnectContext is like Disconnect, but returns ctx.Err() if ctx is
+// cancelled or its deadline expires before the STOMP server acknowledges
+// the DISCONNECT frame. In this case, or if the receipt timeout expires,
+// the connection with the STOMP server is closed without waiting any further.
+func (c *Conn) DisconnectContext(ctx context.Context) error {
+	if c.isClosed() {
 		return nil
 	}
 
-	ch := make(chan *frame.Frame)
-	c.writeCh <- writeRequest{
+	// If the connection has failed and is being re-established,
+	// give up and close it instead.
+	c.stopReconnecting()
+
+	request := writeRequest{
 		Frame: frame.New(frame.DISCONNECT, frame.Receipt, allocateId()),
-		C:     ch,
+		C:     make(chan *frame.Frame, 1),
 	}
 
-	response := <-ch
-	if response.Command != frame.RECEIPT {
-		return newError(response)
+	select {
+	case c.writeCh <- request:
+	case <-c.done:
+		_, err := c.close()
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	c.closed = true
-	return c.conn.Close()
+	err := c.waitReceipt(ctx, request)
+	if err == ErrReceiptTimeout || err == ctx.Err() {
+		c.close()
+		return err
+	}
+	if err == ErrClosedUnexpectedly && c.isStopped() {
+		// the connection failed while disconnecting
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.close()
+	return err
 }
 
 // MustDisconnect will disconnect 'ungracefully' from the STOMP server.
 // This method should be used only as last resort when there are fatal
 // network errors that prevent to do a proper disconnect from the server.
 func (c *Conn) MustDisconnect() error {
-	if c.closed {
-		return nil
+	c.stopReconnecting()
+	ok, err := c.close()
+	if ok {
+		// the processLoop go-routine exits when writeCh is closed
+		close(c.writeCh)
 	}
-
-	// just close readCh and writeCh
-	// close(c.readCh)
-	close(c.writeCh)
-
-	c.closed = true
-	return c.conn.Close()
+	return err
 }
 
 // Send sends a message to the STOMP server, which in turn sends the message to the specified destination.
@@ -394,7 +678,14 @@ func (c *Conn) MustDisconnect() error {
 // Any number of options can be specified in opts. See the examples for usage. Options include whether
 // to receive a RECEIPT, should the content-length be suppressed, and sending custom header entries.
 func (c *Conn) Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
-	if c.closed {
+	return c.SendContext(context.Background(), destination, contentType, body, opts...)
+}
+
+// SendContext is like Send, but returns ctx.Err() if ctx is cancelled or its
+// deadline expires before the message has been passed to the connection or,
+// if a receipt was requested, before the RECEIPT frame has been received.
+func (c *Conn) SendContext(ctx context.Context, destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	if c.isClosed() {
 		return ErrAlreadyClosed
 	}
 
@@ -403,25 +694,7 @@ func (c *Conn) Send(destination, contentType string, body []byte, opts ...func(*
 		return err
 	}
 
-	if _, ok := f.Header.Contains(frame.Receipt); ok {
-		// receipt required
-		request := writeRequest{
-			Frame: f,
-			C:     make(chan *frame.Frame),
-		}
-
-		c.writeCh <- request
-		response := <-request.C
-		if response.Command != frame.RECEIPT {
-			return newError(response)
-		}
-	} else {
-		// no receipt required
-		request := writeRequest{Frame: f}
-		c.writeCh <- request
-	}
-
-	return nil
+	return c.sendFrameContext(ctx, f)
 }
 
 func createSendFrame(destination, contentType string, body []byte, opts []func(*frame.Frame) error) (*frame.Frame, error) {
@@ -449,50 +722,132 @@ func createSendFrame(destination, contentType string, body []byte, opts []func(*
 }
 
 func (c *Conn) sendFrame(f *frame.Frame) error {
+	return c.sendFrameContext(context.Background(), f)
+}
+
+// sendFrameContext passes the frame to the processLoop go-routine for
+// sending. If the frame has a receipt header, waits for the corresponding
+// RECEIPT frame. Returns ctx.Err() if ctx is done first, and
+// ErrReceiptTimeout if the receipt timeout expires first.
+func (c *Conn) sendFrameContext(ctx context.Context, f *frame.Frame) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.validate(f); err != nil {
+		return err
+	}
+
+	request := writeRequest{Frame: f}
 	if _, ok := f.Header.Contains(frame.Receipt); ok {
-		// receipt required
-		request := writeRequest{
-			Frame: f,
-			C:     make(chan *frame.Frame),
-		}
+		// receipt required, the channel is buffered so that the
+		// processLoop does not block if the caller stops waiting
+		request.C = make(chan *frame.Frame, 1)
+	}
 
-		c.writeCh <- request
-		response, ok := <-request.C
-		if ok {
-			if response.Command != frame.RECEIPT {
-				return newError(response)
-			}
-		} else {
+	select {
+	case c.writeCh <- request:
+	case <-c.done:
+		return ErrClosedUnexpectedly
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if request.C == nil {
+		// no receipt required
+		return nil
+	}
+
+	return c.waitReceipt(ctx, request)
+}
+
+// validate checks a frame before it is sent, if the Validate
+// connect option was specified.
+func (c *Conn) validate(f *frame.Frame) error {
+	c.mutex.Lock()
+	validator := c.validator
+	c.mutex.Unlock()
+	if validator == nil {
+		return nil
+	}
+	return validator.Validate(f)
+}
+
+// waitReceipt waits for the RECEIPT frame in response to a request
+// that has already been passed to the processLoop go-routine. If the
+// STOMP server responds with an ERROR frame that refers to the request's
+// receipt, a ReceiptError is returned.
+func (c *Conn) waitReceipt(ctx context.Context, request writeRequest) error {
+	var timeoutChannel <-chan time.Time
+	if c.options.ReceiptTimeout > 0 {
+		timer := time.NewTimer(c.options.ReceiptTimeout)
+		defer timer.Stop()
+		timeoutChannel = timer.C
+	}
+
+	select {
+	case response, ok := <-request.C:
+		return receiptResponse(request, response, ok)
+	case <-c.done:
+		// the processLoop go-routine might have responded before exiting
+		select {
+		case response, ok := <-request.C:
+			return receiptResponse(request, response, ok)
+		default:
 			return ErrClosedUnexpectedly
 		}
-	} else {
-		// no receipt required
-		request := writeRequest{Frame: f}
-		c.writeCh <- request
+	case <-timeoutChannel:
+		return ErrReceiptTimeout
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	return nil
+// receiptResponse returns the result of a request that requested a
+// receipt, given the frame received on its response channel.
+func receiptResponse(request writeRequest, response *frame.Frame, ok bool) error {
+	if !ok {
+		return ErrClosedUnexpectedly
+	}
+	if response.Command == frame.RECEIPT {
+		return nil
+	}
+	receipt := request.Frame.Header.Get(frame.Receipt)
+	if id, ok := response.Header.Contains(frame.ReceiptId); ok && id == receipt {
+		return newReceiptError(response)
+	}
+	return newError(response)
 }
 
 // Subscribe creates a subscription on the STOMP server.
 // The subscription has a destination, and messages sent to that destination
 // will be received by this subscription. A subscription has a channel
 // on which the calling program can receive messages.
+//
+// If a receipt is requested using SubscribeOpt.Receipt, Subscribe does not
+// return until the STOMP server has acknowledged the subscription.
 func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Frame) error) (*Subscription, error) {
+	return c.SubscribeContext(context.Background(), destination, ack, opts...)
+}
+
+// SubscribeContext is like Subscribe, but returns ctx.Err() if ctx is
+// cancelled or its deadline expires before the SUBSCRIBE frame has been
+// passed to the connection or, if a receipt was requested, before the
+// RECEIPT frame has been received. Once the subscription has been
+// created, cancelling ctx has no effect on it.
+func (c *Conn) SubscribeContext(ctx context.Context, destination string, ack AckMode, opts ...func(*frame.Frame) error) (*Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	ch := make(chan *frame.Frame)
 
 	subscribeFrame := frame.New(frame.SUBSCRIBE,
 		frame.Destination, destination,
 		frame.Ack, ack.String())
 
-	for _, opt := range opts {
-		if opt == nil {
-			return nil, ErrNilOption
-		}
-		err := opt(subscribeFrame)
-		if err != nil {
-			return nil, err
-		}
+	options, err := newSubscribeOptions(subscribeFrame, c.options, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	// If the option functions have not specified the "id" header entry,
@@ -502,10 +857,16 @@ func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Fr
 		id = allocateId()
 		subscribeFrame.Header.Add(frame.Id, id)
 	}
+	if err := c.validate(subscribeFrame); err != nil {
+		return nil, err
+	}
 
 	request := writeRequest{
 		Frame: subscribeFrame,
-		C:     ch,
+		Sub:   ch,
+	}
+	if _, ok := subscribeFrame.Header.Contains(frame.Receipt); ok {
+		request.C = make(chan *frame.Frame, 1)
 	}
 
 	sub := &Subscription{
@@ -513,41 +874,72 @@ func (c *Conn) Subscribe(destination string, ack AckMode, opts ...func(*frame.Fr
 		destination:    destination,
 		conn:           c,
 		ackMode:        ack,
-		C:              make(chan *Message, 16),
+		C:              make(chan *Message),
 		completedMutex: &sync.Mutex{},
+		done:           make(chan struct{}),
+		capacity:       options.Capacity,
+		overflow:       options.Overflow,
+		blockTimeout:   c.options.BlockTimeout,
 	}
+
+	select {
+	case c.writeCh <- request:
+	case <-c.done:
+		return nil, ErrClosedUnexpectedly
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
 	go sub.readLoop(ch)
 
-	c.writeCh <- request
+	if request.C != nil {
+		if err := c.waitReceipt(ctx, request); err != nil {
+			if _, ok := err.(ReceiptError); !ok {
+				// The server has not rejected the subscription,
+				// so it might still be active.
+				sub.Unsubscribe()
+			}
+			return nil, err
+		}
+	}
+
 	return sub, nil
 }
 
 // Ack acknowledges a message received from the STOMP server.
 // If the message was received on a subscription with AckMode == AckAuto,
-// then no operation is performed.
-func (c *Conn) Ack(m *Message) error {
+// then no operation is performed. Options in opts can be used to request
+// a receipt for the ACK frame (see FrameOpt).
+func (c *Conn) Ack(m *Message, opts ...func(*frame.Frame) error) error {
 	f, err := c.createAckNackFrame(m, true)
 	if err != nil {
 		return err
 	}
 
 	if f != nil {
-		c.sendFrame(f)
+		if err = applyFrameOptions(f, opts); err != nil {
+			return err
+		}
+		return c.sendFrame(f)
 	}
 	return nil
 }
 
 // Nack indicates to the server that a message was not received
 // by the client. Returns an error if the STOMP version does not
-// support the NACK message.
-func (c *Conn) Nack(m *Message) error {
+// support the NACK message. Options in opts can be used to request
+// a receipt for the NACK frame (see FrameOpt).
+func (c *Conn) Nack(m *Message, opts ...func(*frame.Frame) error) error {
 	f, err := c.createAckNackFrame(m, false)
 	if err != nil {
 		return err
 	}
 
 	if f != nil {
-		c.sendFrame(f)
+		if err = applyFrameOptions(f, opts); err != nil {
+			return err
+		}
+		return c.sendFrame(f)
 	}
 	return nil
 }
@@ -562,9 +954,39 @@ func (c *Conn) Begin() *Transaction {
 	return &Transaction{id: id, conn: c}
 }
 
+// BeginWithError is like Begin, but accepts options for the BEGIN frame
+// and returns any error that occurs sending it. If a receipt is requested
+// (see FrameOpt), BeginWithError does not return until the STOMP server
+// has acknowledged the BEGIN frame.
+func (c *Conn) BeginWithError(opts ...func(*frame.Frame) error) (*Transaction, error) {
+	id := allocateId()
+	f := frame.New(frame.BEGIN, frame.Transaction, id)
+	if err := applyFrameOptions(f, opts); err != nil {
+		return nil, err
+	}
+	if err := c.sendFrame(f); err != nil {
+		return nil, err
+	}
+	return &Transaction{id: id, conn: c}, nil
+}
+
+// Apply options to a frame.
+func applyFrameOptions(f *frame.Frame, opts []func(*frame.Frame) error) error {
+	for _, opt := range opts {
+		if opt == nil {
+			return ErrNilOption
+		}
+		if err := opt(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Create an ACK or NACK frame. Complicated by version incompatibilities.
 func (c *Conn) createAckNackFrame(msg *Message, ack bool) (*frame.Frame, error) {
-	if !ack && !c.version.SupportsNack() {
+	version := c.Version()
+	if !ack && !version.SupportsNack() {
 		return nil, ErrNackNotSupported
 	}
 
@@ -590,7 +1012,7 @@ func (c *Conn) createAckNackFrame(msg *Message, ack bool) (*frame.Frame, error)
 		f = frame.New(frame.NACK)
 	}
 
-	switch c.version {
+	switch version {
 	case V10, V11:
 		f.Header.Add(frame.Subscription, msg.Subscription.Id())
 		if messageId, ok := msg.Header.Contains(frame.MessageId); ok {
@@ -2,6 +2,7 @@ package stomp
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -19,6 +20,16 @@ type connOptions struct {
 	Login, Passcode string
 	AcceptVersions  []string
 	Header          *frame.Header
+	Reconnect       *ReconnectPolicy
+	ReceiptTimeout  time.Duration
+
+	SubscriptionBuffer int
+	Overflow           OverflowPolicy
+	BlockTimeout       time.Duration
+	Validate           bool
+	FrameLimits        frame.Limits
+
+	Logger *slog.Logger
 }
 
 func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error) {
@@ -27,6 +38,15 @@ func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error)
 		ReadTimeout:    time.Minute,
 		WriteTimeout:   time.Minute,
 		HeartBeatError: DefaultHeartBeatError,
+
+		SubscriptionBuffer: DefaultSubscriptionBuffer,
+		Overflow:           OverflowBlock,
+		BlockTimeout:       DefaultOverflowBlockTimeout,
+		FrameLimits: frame.Limits{
+			MaxHeaders:      DefaultMaxHeaders,
+			MaxHeaderLength: DefaultMaxHeaderLength,
+			MaxBodyLength:   DefaultMaxBodyLength,
+		},
 	}
 
 	// This is a slight of hand, attach the options to the Conn long
@@ -46,6 +66,10 @@ func newConnOptions(conn *Conn, opts []func(*Conn) error) (*connOptions, error)
 		}
 	}
 
+	if co.Logger == nil {
+		co.Logger = slog.Default()
+	}
+
 	if len(co.AcceptVersions) == 0 {
 		co.AcceptVersions = append(co.AcceptVersions, string(V10), string(V11), string(V12))
 	}
@@ -128,6 +152,62 @@ var ConnOpt struct {
 	// header entry in the STOMP frame. This connect option can be specified
 	// multiple times for multiple custom headers.
 	Header func(key, value string) func(*Conn) error
+
+	// Reconnect is a connect option that causes the connection to be
+	// re-established automatically if the underlying network connection
+	// fails. Active subscriptions are re-issued on the new connection, and
+	// their channels remain open. See ReconnectPolicy for details.
+	Reconnect func(policy ReconnectPolicy) func(*Conn) error
+
+	// ReceiptTimeout is a connect option that specifies the maximum amount
+	// of time to wait for a RECEIPT frame from the server when an operation
+	// has requested a receipt. If the timeout expires, the operation returns
+	// ErrReceiptTimeout. If not specified, operations wait indefinitely.
+	ReceiptTimeout func(timeout time.Duration) func(*Conn) error
+
+	// SubscriptionBuffer is a connect option that specifies the number of
+	// received messages buffered for each subscription until they are read
+	// from the subscription's C channel, and the action taken when a message
+	// arrives and the buffer is full. If not specified, each subscription
+	// buffers DefaultSubscriptionBuffer messages and the overflow policy is
+	// OverflowBlock. The SubscribeOpt.Buffer subscribe option overrides these
+	// values for an individual subscription.
+	SubscriptionBuffer func(capacity int, overflow OverflowPolicy) func(*Conn) error
+
+	// OverflowBlockTimeout is a connect option that specifies the maximum
+	// amount of time that a subscription with the OverflowBlock policy
+	// stops the client reading from the server. If the subscription's
+	// buffer is still full when the timeout expires, the subscription
+	// fails as for OverflowError. If not specified, the timeout is
+	// DefaultOverflowBlockTimeout. A timeout of zero blocks indefinitely.
+	OverflowBlockTimeout func(timeout time.Duration) func(*Conn) error
+
+	// Logger is a connect option that specifies the logger used for
+	// events that are not reported to the calling program by any other
+	// means, such as ERROR frames received from the server and connection
+	// failures. Log records include the session, subscription id and
+	// destination where relevant. If not specified, slog.Default() is used.
+	// To discard log output, specify a logger whose handler is disabled at
+	// all levels.
+	Logger func(logger *slog.Logger) func(*Conn) error
+
+	// Validate is a connect option that causes each frame to be checked
+	// against the rules of the negotiated STOMP version before it is
+	// sent to the STOMP server. A frame that is not valid, for example
+	// one with a custom header entry that cannot be encoded, is not sent
+	// and the operation returns the error. See NewValidator.
+	Validate func(*Conn) error
+
+	// FrameLimits is a connect option that limits the size of frames
+	// received from the STOMP server. If the server sends a frame that
+	// exceeds a limit, the connection is closed and pending operations
+	// and subscriptions receive an error describing the limit exceeded.
+	// A zero value for a limit means that the default limit is enforced:
+	// see DefaultMaxHeaders, DefaultMaxHeaderLength and
+	// DefaultMaxBodyLength. A negative value means that the limit is not
+	// enforced. If this option is not specified, the default limits are
+	// enforced.
+	FrameLimits func(limits frame.Limits) func(*Conn) error
 }
 
 func init() {
@@ -188,4 +268,71 @@ func init() {
 			return nil
 		}
 	}
+
+	ConnOpt.Reconnect = func(policy ReconnectPolicy) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.Reconnect = policy.withDefaults()
+			return nil
+		}
+	}
+
+	ConnOpt.ReceiptTimeout = func(timeout time.Duration) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.ReceiptTimeout = timeout
+			return nil
+		}
+	}
+
+	ConnOpt.OverflowBlockTimeout = func(timeout time.Duration) func(*Conn) error {
+		return func(c *Conn) error {
+			c.options.BlockTimeout = timeout
+			return nil
+		}
+	}
+
+	ConnOpt.SubscriptionBuffer = func(capacity int, overflow OverflowPolicy) func(*Conn) error {
+		return func(c *Conn) error {
+			if err := checkBuffer(capacity, overflow); err != nil {
+				return err
+			}
+			c.options.SubscriptionBuffer = capacity
+			c.options.Overflow = overflow
+			return nil
+		}
+	}
+
+	ConnOpt.Logger = func(logger *slog.Logger) func(*Conn) error {
+		return func(c *Conn) error {
+			if logger == nil {
+				return ErrNilOption
+			}
+			c.options.Logger = logger
+			return nil
+		}
+	}
+
+	ConnOpt.Validate = func(c *Conn) error {
+		c.options.Validate = true
+		return nil
+	}
+
+	ConnOpt.FrameLimits = func(limits frame.Limits) func(*Conn) error {
+		limit := func(value, defaultValue int) int {
+			switch {
+			case value == 0:
+				return defaultValue
+			case value < 0:
+				return 0
+			}
+			return value
+		}
+		return func(c *Conn) error {
+			c.options.FrameLimits = frame.Limits{
+				MaxHeaders:      limit(limits.MaxHeaders, DefaultMaxHeaders),
+				MaxHeaderLength: limit(limits.MaxHeaderLength, DefaultMaxHeaderLength),
+				MaxBodyLength:   limit(limits.MaxBodyLength, DefaultMaxBodyLength),
+			}
+			return nil
+		}
+	}
 }
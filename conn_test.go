@@ -1,8 +1,12 @@
 package stomp
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"strconv"
 	"time"
 
 	"github.com/go-stomp/stomp/frame"
@@ -542,3 +546,309 @@ func createHeartBeatConnection(
 		conn:   fc2,
 	}
 }
+
+func (s *StompSuite) Test_connect_context_deadline(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	defer fc2.Close()
+
+	go func() {
+		// read the CONNECT frame, but never respond
+		reader := frame.NewReader(fc2)
+		reader.Read()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	conn, err := ConnectContext(ctx, fc1)
+	c.Assert(conn, IsNil)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
+
+func (s *StompSuite) Test_send_context_receipt_deadline(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(stop)
+
+		// do not send a receipt for the SEND frame
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(f.Header.Get(frame.Receipt), Not(Equals), "")
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.DISCONNECT)
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f.Header.Get(frame.Receipt)))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := conn.SendContext(ctx, "/queue/test-1", "text/plain", []byte("hello"), SendOpt.Receipt)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	// the connection is still usable
+	err = conn.Disconnect()
+	c.Assert(err, IsNil)
+	<-stop
+}
+
+func (s *StompSuite) Test_read_context_cancel(c *C) {
+	conn, rw := connectHelper(c, V12)
+	subscribed := make(chan struct{})
+
+	go func() {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		close(subscribed)
+	}()
+
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto)
+	c.Assert(err, IsNil)
+	<-subscribed
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	msg, err := sub.ReadContext(ctx)
+	c.Assert(msg, IsNil)
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(sub.Active(), Equals, true)
+
+	// the server never acknowledges the DISCONNECT
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go rw.Read()
+	err = conn.DisconnectContext(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	err = conn.Send("/queue/test-1", "text/plain", nil)
+	c.Assert(err, Equals, ErrAlreadyClosed)
+}
+
+func (s *StompSuite) Test_subscribe_with_receipt(c *C) {
+	conn, rw := connectHelper(c, V12)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(stop)
+
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		receipt, ok := f.Header.Contains(frame.Receipt)
+		c.Check(ok, Equals, true)
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.UNSUBSCRIBE)
+		receipt, ok = f.Header.Contains(frame.Receipt)
+		c.Check(ok, Equals, true)
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.DISCONNECT)
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f.Header.Get(frame.Receipt)))
+	}()
+
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto, SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+	c.Assert(sub, NotNil)
+
+	err = sub.Unsubscribe(SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	c.Assert(conn.Disconnect(), IsNil)
+	<-stop
+}
+
+func (s *StompSuite) Test_ack_receipt_error(c *C) {
+	conn, rw := connectHelper(c, V12)
+
+	go func() {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		msg := frame.New(frame.MESSAGE,
+			frame.Subscription, f.Header.Get(frame.Id),
+			frame.MessageId, "1",
+			frame.Ack, "1",
+			frame.Destination, "/queue/test-1")
+		rw.Write(msg)
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.ACK)
+		rw.Write(frame.New(frame.ERROR,
+			frame.Message, "unknown message",
+			frame.ReceiptId, f.Header.Get(frame.Receipt)))
+		rw.Close()
+	}()
+
+	sub, err := conn.Subscribe("/queue/test-1", AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	msg, err := sub.Read()
+	c.Assert(err, IsNil)
+
+	err = conn.Ack(msg, FrameOpt.Receipt)
+	c.Assert(err, FitsTypeOf, ReceiptError{})
+	c.Assert(err, ErrorMatches, "unknown message")
+	c.Assert(err.(ReceiptError).ReceiptId, Not(Equals), "")
+}
+
+func (s *StompSuite) Test_receipt_timeout(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	reader := frame.NewReader(fc2)
+	writer := frame.NewWriter(fc2)
+	defer fc2.Close()
+
+	go func() {
+		f, err := reader.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.CONNECT)
+		writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+
+		// never acknowledge the BEGIN frame
+		f, err = reader.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.BEGIN)
+	}()
+
+	conn, err := Connect(fc1, ConnOpt.ReceiptTimeout(10*time.Millisecond))
+	c.Assert(err, IsNil)
+
+	tx, err := conn.BeginWithError(FrameOpt.Receipt)
+	c.Assert(tx, IsNil)
+	c.Assert(err, Equals, ErrReceiptTimeout)
+}
+
+func (s *StompSuite) Test_conn_logger(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	rw := &fakeReaderWriter{
+		reader: frame.NewReader(fc2),
+		writer: frame.NewWriter(fc2),
+		conn:   fc2,
+	}
+	defer rw.Close()
+
+	go func() {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.CONNECT)
+		rw.Write(frame.New(frame.CONNECTED,
+			frame.Version, "1.2",
+			frame.Session, "session-1"))
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		rw.Write(frame.New(frame.MESSAGE,
+			frame.Subscription, "unknown",
+			frame.MessageId, "1",
+			frame.Destination, "/queue/test-1"))
+		rw.Write(frame.New(frame.ERROR, frame.Message, "bad things"))
+	}()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	_, err := Connect(fc1, ConnOpt.Logger(nil))
+	c.Assert(err, Equals, ErrNilOption)
+
+	conn, err := Connect(fc1, ConnOpt.Logger(logger))
+	c.Assert(err, IsNil)
+
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Assert(msg.Err, ErrorMatches, "bad things")
+
+	output := buf.String()
+	c.Check(output, Matches, `(?s).*level=WARN msg="stomp: ignored MESSAGE for unknown subscription" session=session-1 subscription=unknown\n.*`)
+	c.Check(output, Matches, `(?s).*level=ERROR msg="stomp: received ERROR; closing connection" session=session-1 message="bad things"\n.*`)
+	c.Check(output, Matches, `(?s).*level=ERROR msg="stomp: subscription received ERROR" subscription=\S+ destination=/queue/test-1 message="bad things"\n.*`)
+}
+
+func (s *StompSuite) Test_conn_default_frame_limits(c *C) {
+	receive := func(opts ...func(*Conn) error) *Message {
+		fc1, fc2 := testutil.NewFakeConn(c)
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		defer fc2.Close()
+
+		go func() {
+			f, err := reader.Read()
+			c.Check(err, IsNil)
+			c.Check(f.Command, Equals, frame.CONNECT)
+			writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+
+			f, err = reader.Read()
+			c.Check(err, IsNil)
+			c.Check(f.Command, Equals, frame.SUBSCRIBE)
+			message := frame.New(frame.MESSAGE,
+				frame.Subscription, f.Header.Get(frame.Id),
+				frame.MessageId, "1",
+				frame.Destination, "/queue/test-1")
+			for i := 0; i < DefaultMaxHeaders; i++ {
+				message.Header.Add("h"+strconv.Itoa(i), "v")
+			}
+			writer.Write(message)
+		}()
+
+		conn, err := Connect(fc1, opts...)
+		c.Assert(err, IsNil)
+		sub, err := conn.Subscribe("/queue/test-1", AckAuto)
+		c.Assert(err, IsNil)
+		return <-sub.C
+	}
+
+	// limited by default, like the server
+	msg := receive()
+	c.Check(msg.Err, ErrorMatches, "too many header entries")
+
+	// negative values remove a limit
+	msg = receive(ConnOpt.FrameLimits(frame.Limits{MaxHeaders: -1}))
+	c.Assert(msg.Err, IsNil)
+	c.Check(msg.Header.Get("h0"), Equals, "v")
+}
+
+func (s *StompSuite) Test_conn_frame_limits(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	reader := frame.NewReader(fc2)
+	writer := frame.NewWriter(fc2)
+	defer fc2.Close()
+
+	go func() {
+		f, err := reader.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.CONNECT)
+		writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+
+		f, err = reader.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		message := frame.New(frame.MESSAGE,
+			frame.Subscription, f.Header.Get(frame.Id),
+			frame.MessageId, "1",
+			frame.Destination, "/queue/test-1")
+		message.Body = []byte("0123456789A")
+		writer.Write(message)
+	}()
+
+	conn, err := Connect(fc1, ConnOpt.FrameLimits(frame.Limits{MaxBodyLength: 10}))
+	c.Assert(err, IsNil)
+
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Check(msg.Err, ErrorMatches, "frame body too large")
+}
@@ -17,6 +17,16 @@ var (
 	ErrClosedUnexpectedly    = newErrorMessage("connection closed unexpectedly")
 	ErrAlreadyClosed         = newErrorMessage("connection already closed")
 	ErrNilOption             = newErrorMessage("nil option")
+	ErrReconnectDialMissing  = newErrorMessage("reconnect policy requires a dial function")
+	ErrReceiptTimeout        = newErrorMessage("timed out waiting for receipt")
+	ErrSubscriptionOverflow  = newErrorMessage("subscription buffer overflow")
+	ErrInvalidOverflowPolicy = newErrorMessage("invalid overflow policy")
+	ErrInvalidBufferCapacity = newErrorMessage("invalid subscription buffer capacity")
+	ErrNilHandler            = newErrorMessage("nil handler")
+	ErrInvalidWorkerCount    = newErrorMessage("invalid worker count")
+	ErrInvalidContentLength  = newErrorMessage("invalid content-length")
+	ErrContentLengthMismatch = newErrorMessage("content-length does not match body length")
+	ErrBodyNotAllowed        = newErrorMessage("frame cannot have a body")
 )
 
 // StompError implements the Error interface, and provides
@@ -30,10 +40,42 @@ func (e Error) Error() string {
 	return e.Message
 }
 
+// ReceiptError is returned by an operation that requested a receipt when
+// the STOMP server responds with an ERROR frame whose receipt-id header
+// matches the receipt requested. This indicates that the frame sent by the
+// operation caused the error. The STOMP server closes the connection after
+// sending an ERROR frame.
+type ReceiptError struct {
+	ReceiptId string
+	Message   string
+	Frame     *frame.Frame
+}
+
+func (e ReceiptError) Error() string {
+	return e.Message
+}
+
+func newReceiptError(f *frame.Frame) ReceiptError {
+	e := newError(f)
+	return ReceiptError{
+		ReceiptId: f.Header.Get(frame.ReceiptId),
+		Message:   e.Message,
+		Frame:     f,
+	}
+}
+
 func missingHeader(name string) Error {
 	return newErrorMessage("missing header: " + name)
 }
 
+func prohibitedHeader(name string) Error {
+	return newErrorMessage("prohibited header: " + name)
+}
+
+func invalidHeader(name string) Error {
+	return newErrorMessage("invalid header: " + name)
+}
+
 func newErrorMessage(msg string) Error {
 	return Error{Message: msg}
 }
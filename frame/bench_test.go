@@ -0,0 +1,77 @@
+package frame
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// benchmarkFrame is a MESSAGE frame typical of a topic subscription.
+var benchmarkFrame = "MESSAGE\n" +
+	"destination:/topic/prices\n" +
+	"subscription:1\n" +
+	"message-id:123456\n" +
+	"content-type:application/json\n" +
+	"content-length:24\n" +
+	"symbol:ACME\\cNYSE\n" +
+	"\n" +
+	`{"price":123.45,"qty":7}` + "\x00"
+
+// repeatReader returns the same frame text repeatedly.
+type repeatReader struct {
+	text []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.text[r.off:])
+	r.off = (r.off + n) % len(r.text)
+	return n, nil
+}
+
+func BenchmarkRead(b *testing.B) {
+	reader := NewReader(&repeatReader{text: []byte(benchmarkFrame)})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := reader.Read(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadRelease(b *testing.B) {
+	reader := NewReader(&repeatReader{text: []byte(benchmarkFrame)})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := reader.Read()
+		if err != nil {
+			b.Fatal(err)
+		}
+		f.Release()
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	f, err := NewReader(bytes.NewReader([]byte(benchmarkFrame))).Read()
+	if err != nil {
+		b.Fatal(err)
+	}
+	writer := NewWriter(io.Discard)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := writer.Write(f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkClone(b *testing.B) {
+	f, err := NewReader(bytes.NewReader([]byte(benchmarkFrame))).Read()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.Clone()
+	}
+}
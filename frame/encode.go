@@ -1,27 +1,123 @@
 package frame
 
 import (
+	"bufio"
+	"bytes"
 	"strings"
 )
 
-// Encodes a header value using STOMP value encoding
-// TODO: replace with more efficient version.
-func encodeValue(s string) []byte {
-	s = strings.Replace(s, "\\", "\\\\", -1)
-	s = strings.Replace(s, "\r", "\\r", -1)
-	s = strings.Replace(s, "\n", "\\n", -1)
-	s = strings.Replace(s, ":", "\\c", -1)
-	return []byte(s)
+// STOMP versions whose header escaping rules differ from STOMP 1.2.
+// Any other version uses the STOMP 1.2 rules.
+const (
+	version10 = "1.0"
+	version11 = "1.1"
+)
+
+// escaping describes how the header entries of a frame are encoded.
+type escaping struct {
+	escape bool // backslash, line feed and colon are escaped
+	cr     bool // carriage return is escaped, or prohibited if not escaping
+}
+
+// escapingFor returns the escaping rules for a frame with the specified
+// command. STOMP 1.0 does not escape header entries, and later versions
+// do not escape the header entries of the CONNECT and CONNECTED frames.
+// STOMP 1.1 does not define an escape sequence for carriage return.
+func escapingFor(version, command string) escaping {
+	e := escaping{escape: true, cr: version != version11}
+	switch {
+	case version == version10:
+		e = escaping{}
+	case command == CONNECT, command == STOMP, command == CONNECTED:
+		e.escape = false
+	}
+	return e
+}
+
+// check returns ErrInvalidHeader if a header entry cannot be encoded.
+// Only header entries that are not escaped can be invalid: they cannot
+// contain an end of line, and the name cannot contain a colon.
+func (e escaping) check(key, value string) error {
+	if e.escape {
+		return nil
+	}
+	special := "\n"
+	if e.cr {
+		special = "\r\n"
+	}
+	if strings.ContainsAny(key, ":"+special) || strings.ContainsAny(value, special) {
+		return ErrInvalidHeader
+	}
+	return nil
 }
 
-// Unencodes a header value using STOMP value encoding
-// TODO: replace with more efficient version.
-// TODO: return error if invalid sequences found (eg "\t")
-func unencodeValue(b []byte) (string, error) {
-	s := string(b)
-	s = strings.Replace(s, "\\r", "\r", -1)
-	s = strings.Replace(s, "\\n", "\n", -1)
-	s = strings.Replace(s, "\\c", ":", -1)
-	s = strings.Replace(s, "\\\\", "\\", -1)
-	return s, nil
+// encode writes a header name or value. Header entries must already have
+// been checked, so a header entry that is not escaped is written as is.
+func (e escaping) encode(w *bufio.Writer, s string) error {
+	if !e.escape {
+		_, err := w.WriteString(s)
+		return err
+	}
+
+	start := 0
+	for i := 0; i < len(s); i++ {
+		var seq string
+		switch s[i] {
+		case '\\':
+			seq = `\\`
+		case '\n':
+			seq = `\n`
+		case ':':
+			seq = `\c`
+		case '\r':
+			if !e.cr {
+				continue
+			}
+			seq = `\r`
+		default:
+			continue
+		}
+		w.WriteString(s[start:i])
+		w.WriteString(seq)
+		start = i + 1
+	}
+	_, err := w.WriteString(s[start:])
+	return err
+}
+
+// decode appends a header name or value read from a frame to dst and
+// returns the extended slice. Returns ErrInvalidEscape if an escape
+// sequence is not defined.
+func (e escaping) decode(dst, b []byte) ([]byte, error) {
+	i := bytes.IndexByte(b, '\\')
+	if !e.escape || i < 0 {
+		return append(dst, b...), nil
+	}
+
+	dst = append(dst, b[:i]...)
+	for ; i < len(b); i++ {
+		if b[i] != '\\' {
+			dst = append(dst, b[i])
+			continue
+		}
+		if i++; i == len(b) {
+			return dst, ErrInvalidEscape
+		}
+		switch b[i] {
+		case '\\':
+			dst = append(dst, '\\')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'c':
+			dst = append(dst, ':')
+		case 'r':
+			if !e.cr {
+				return dst, ErrInvalidEscape
+			}
+			dst = append(dst, '\r')
+		default:
+			return dst, ErrInvalidEscape
+		}
+	}
+	return dst, nil
 }
@@ -1,6 +1,10 @@
 package frame
 
 import (
+	"bufio"
+	"bytes"
+	"strings"
+
 	. "gopkg.in/check.v1"
 )
 
@@ -8,13 +12,74 @@ type EncodeSuite struct{}
 
 var _ = Suite(&EncodeSuite{})
 
+func encodeString(e escaping, s string) string {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+	e.encode(w, s)
+	w.Flush()
+	return b.String()
+}
+
+func decodeString(e escaping, s string) (string, error) {
+	b, err := e.decode(nil, []byte(s))
+	return string(b), err
+}
+
 func (s *EncodeSuite) TestEncodeValue(c *C) {
-	val := encodeValue("Contains\r\nNewLine and : colon and \\ backslash")
-	c.Check(string(val), Equals, `Contains\r\nNewLine and \c colon and \\ backslash`)
+	val := "Contains\r\nNewLine and : colon and \\ backslash"
+	c.Check(encodeString(escapingFor("1.2", SEND), val), Equals, `Contains\r\nNewLine and \c colon and \\ backslash`)
+	c.Check(encodeString(escapingFor("", SEND), val), Equals, `Contains\r\nNewLine and \c colon and \\ backslash`)
+	c.Check(encodeString(escapingFor("1.1", SEND), val), Equals, "Contains\r\\nNewLine and \\c colon and \\\\ backslash")
+	c.Check(encodeString(escapingFor("1.0", SEND), val), Equals, val)
+	c.Check(encodeString(escapingFor("1.2", CONNECT), val), Equals, val)
 }
 
 func (s *EncodeSuite) TestUnencodeValue(c *C) {
-	val, err := unencodeValue([]byte(`Contains\r\nNewLine and \c colon and \\ backslash`))
+	val, err := decodeString(escapingFor("1.2", SEND), `Contains\r\nNewLine and \c colon and \\ backslash`)
 	c.Check(err, IsNil)
 	c.Check(val, Equals, "Contains\r\nNewLine and : colon and \\ backslash")
+
+	val, err = decodeString(escapingFor("1.0", SEND), `a\c\tb`)
+	c.Check(err, IsNil)
+	c.Check(val, Equals, `a\c\tb`)
+	val, err = decodeString(escapingFor("1.2", CONNECTED), `a\b`)
+	c.Check(err, IsNil)
+	c.Check(val, Equals, `a\b`)
+
+	for _, text := range []string{`a\tb`, `a\`, `\\\`} {
+		_, err = decodeString(escapingFor("1.2", SEND), text)
+		c.Check(err, Equals, ErrInvalidEscape, Commentf("%q", text))
+	}
+	_, err = decodeString(escapingFor("1.1", MESSAGE), `a\rb`)
+	c.Check(err, Equals, ErrInvalidEscape)
+}
+
+func (s *EncodeSuite) TestCheck(c *C) {
+	c.Check(escapingFor("1.2", SEND).check("a:b", "c\nd"), IsNil)
+	c.Check(escapingFor("1.0", SEND).check("a", "b:c\rd"), IsNil)
+	c.Check(escapingFor("1.0", SEND).check("a:b", "c"), Equals, ErrInvalidHeader)
+	c.Check(escapingFor("1.0", SEND).check("a", "b\nc"), Equals, ErrInvalidHeader)
+	c.Check(escapingFor("1.1", CONNECT).check("a", "b\rc"), IsNil)
+	c.Check(escapingFor("1.2", CONNECT).check("a", "b\rc"), Equals, ErrInvalidHeader)
+}
+
+func (s *EncodeSuite) TestWriterVersion(c *C) {
+	var b bytes.Buffer
+	writer := NewWriter(&b)
+	c.Assert(writer.Write(New(CONNECT, "passcode", `a:b\c`)), IsNil)
+	c.Check(b.String(), Equals, "CONNECT\npasscode:a:b\\c\n\n\x00")
+
+	b.Reset()
+	c.Check(writer.Write(New(CONNECT, "passcode", "a\nlogin:b")), Equals, ErrInvalidHeader)
+	c.Check(b.Len(), Equals, 0)
+
+	writer.SetVersion("1.0")
+	c.Assert(writer.Write(New(SEND, "destination", `/queue/a\b`)), IsNil)
+	c.Check(b.String(), Equals, "SEND\ndestination:/queue/a\\b\n\n\x00")
+
+	reader := NewReader(strings.NewReader(b.String()))
+	reader.SetVersion("1.0")
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get("destination"), Equals, `/queue/a\b`)
 }
@@ -6,4 +6,9 @@ import (
 
 var (
 	ErrInvalidHeartBeat = errors.New("invalid heart-beat")
+	ErrInvalidEscape    = errors.New("invalid escape sequence")
+	ErrInvalidHeader    = errors.New("invalid header")
+	ErrTooManyHeaders   = errors.New("too many header entries")
+	ErrHeaderTooLong    = errors.New("header line too long")
+	ErrBodyTooLarge     = errors.New("frame body too large")
 )
@@ -3,6 +3,17 @@ Package frame provides functionality for manipulating STOMP frames.
 */
 package frame
 
+import (
+	"sync"
+)
+
+// framePool contains released frames for reuse by the Reader.
+var framePool = sync.Pool{
+	New: func() interface{} {
+		return &Frame{Header: &Header{}}
+	},
+}
+
 // A Frame represents a STOMP frame. A frame consists of a command
 // followed by a collection of header entries, and then an optional
 // body.
@@ -10,6 +21,10 @@ type Frame struct {
 	Command string
 	Header  *Header
 	Body    []byte
+
+	// Buffer allocated by a Reader for the body, which is reused
+	// when the frame is released.
+	body []byte
 }
 
 // New creates a new STOMP frame with the specified command and headers.
@@ -36,3 +51,24 @@ func (f *Frame) Clone() *Frame {
 	}
 	return fc
 }
+
+// Release returns the frame to a pool, from which a Reader obtains the
+// frames that it reads. Reusing frames, and the buffers holding their
+// header entries and body, reduces the allocations needed for each frame.
+//
+// Releasing a frame is optional: frames that are not released are
+// garbage collected as usual. Once the frame has been released, the
+// calling program must not use the frame, its header, or the body read
+// by the Reader, and must not release it again. Strings obtained from
+// the header remain valid, and frames cloned from the frame are not
+// affected.
+func (f *Frame) Release() {
+	if f.Header == nil {
+		f.Header = &Header{}
+	} else {
+		f.Header.reset()
+	}
+	f.Command = ""
+	f.Body = nil
+	framePool.Put(f)
+}
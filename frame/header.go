@@ -2,6 +2,7 @@ package frame
 
 import (
 	"strconv"
+	"unsafe"
 )
 
 // STOMP header names. Some of the header
@@ -29,6 +30,22 @@ const (
 	Subscription  = "subscription"
 	MessageId     = "message-id"
 	Message       = "message"
+	PrefetchCount = "prefetch-count"
+	Selector      = "selector"
+)
+
+// Non-standard header names used by the server for message
+// expiry, and added to MESSAGE frames when messages are redelivered.
+const (
+	Expires             = "expires"
+	DeliveryCount       = "delivery-count"
+	Redelivered         = "redelivered"
+	OriginalDestination = "original-destination"
+
+	// Used for durable topic subscriptions: client-id in the CONNECT
+	// frame, durable-subscription-name in SUBSCRIBE and UNSUBSCRIBE frames.
+	ClientId                = "client-id"
+	DurableSubscriptionName = "durable-subscription-name"
 )
 
 // A Header represents the header part of a STOMP frame.
@@ -52,6 +69,16 @@ const (
 //
 type Header struct {
 	slice []string
+
+	// Header entries read by a Reader are stored in buf, and the strings
+	// in slice refer to buf rather than being allocated individually.
+	// The ends of the names and values in buf are recorded in ends while
+	// the header is being read. The bytes in buf are never modified once
+	// the strings have been created, so the strings can be kept for as
+	// long as needed, like any other string. For the same reason, buf is
+	// not reused when the frame is released, but ends is.
+	buf  []byte
+	ends []int
 }
 
 // NewHeader creates a new Header and populates it with header entries.
@@ -154,6 +181,70 @@ func (h *Header) Clone() *Header {
 	return hc
 }
 
+// Initial capacity of the buffers used to read header entries,
+// sufficient for most frames.
+const (
+	headerBufferSize = 256
+	headerEntries    = 8
+)
+
+// reset removes all header entries, retaining the slices that
+// do not refer to the strings for reuse.
+func (h *Header) reset() {
+	clear(h.slice)
+	h.slice = h.slice[:0]
+	h.buf = nil
+	h.ends = h.ends[:0]
+}
+
+// addEncoded decodes a header entry and appends it to buf. The header
+// entry is not visible until the strings are created by endEncoded.
+func (h *Header) addEncoded(esc escaping, key, value []byte) (err error) {
+	if h.buf == nil {
+		h.buf = make([]byte, 0, headerBufferSize)
+	}
+	if h.ends == nil {
+		h.ends = make([]int, 0, 2*headerEntries)
+	}
+	if h.buf, err = esc.decode(h.buf, key); err != nil {
+		return err
+	}
+	h.ends = append(h.ends, len(h.buf))
+	if h.buf, err = esc.decode(h.buf, value); err != nil {
+		return err
+	}
+	h.ends = append(h.ends, len(h.buf))
+	return nil
+}
+
+// endEncoded adds the header entries decoded by addEncoded. The strings
+// are created once all header entries have been decoded, because buf
+// can be reallocated while it is being appended to. Afterwards buf is
+// not appended to, so the bytes that the strings refer to do not change.
+func (h *Header) endEncoded() {
+	if n := len(h.slice) + len(h.ends); cap(h.slice) < n {
+		slice := make([]string, len(h.slice), n)
+		copy(slice, h.slice)
+		h.slice = slice
+	}
+	start := 0
+	for _, end := range h.ends {
+		h.slice = append(h.slice, bufString(h.buf[start:end]))
+		start = end
+	}
+	h.buf, h.ends = nil, h.ends[:0]
+}
+
+// bufString returns a string that refers to the bytes in b
+// without copying them. The bytes must not be modified while
+// the string is in use.
+func bufString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
 // ContentLength returns the value of the "content-length" header entry.
 // If the "content-length" header is missing, then ok is false. If the
 // "content-length" entry is present but is not a valid non-negative integer
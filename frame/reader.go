@@ -21,12 +21,28 @@ var (
 )
 
 // The Reader type reads STOMP frames from an underlying io.Reader.
-// The reader is buffered, and the size of the buffer is the maximum
-// size permitted for the STOMP frame command and header section.
-// A STOMP frame is rejected if its command and header section exceed
-// the buffer size.
+// The reader is buffered. The size of a frame is not restricted unless
+// limits are set with SetLimits.
 type Reader struct {
-	reader *bufio.Reader
+	reader  *bufio.Reader
+	version string
+	limits  Limits
+	line    []byte // lines longer than the buffer
+}
+
+// Limits restricts the size of the frames read by a Reader, protecting
+// the calling program from a peer that sends an oversized frame. A zero
+// value for a limit means that it is not enforced.
+type Limits struct {
+	// MaxHeaders is the maximum number of header entries in a frame.
+	MaxHeaders int
+
+	// MaxHeaderLength is the maximum length in bytes of the command line
+	// and of each header line, not including the end of line.
+	MaxHeaderLength int
+
+	// MaxBodyLength is the maximum length in bytes of a frame body.
+	MaxBodyLength int
 }
 
 // NewReader creates a Reader with the default underlying buffer size.
@@ -40,10 +56,38 @@ func NewReaderSize(reader io.Reader, bufferSize int) *Reader {
 	return &Reader{reader: bufio.NewReaderSize(reader, bufferSize)}
 }
 
+// SetVersion sets the version of the STOMP protocol negotiated for the
+// connection, which determines how header entries are unescaped. Until
+// the version is set, header entries are unescaped as per STOMP 1.2.
+// Header entries of the CONNECT and CONNECTED frames are never unescaped.
+func (r *Reader) SetVersion(version string) {
+	r.version = version
+}
+
+// commands maps the text of each STOMP command to the command, so that
+// reading a frame does not allocate a string for the command.
+var commands = map[string]string{
+	CONNECT: CONNECT, STOMP: STOMP, SEND: SEND, SUBSCRIBE: SUBSCRIBE,
+	UNSUBSCRIBE: UNSUBSCRIBE, ACK: ACK, NACK: NACK, BEGIN: BEGIN,
+	COMMIT: COMMIT, ABORT: ABORT, DISCONNECT: DISCONNECT, CONNECTED: CONNECTED,
+	MESSAGE: MESSAGE, RECEIPT: RECEIPT, ERROR: ERROR,
+}
+
+// SetLimits sets the limits on the size of the frames read. Read returns
+// ErrTooManyHeaders, ErrHeaderTooLong or ErrBodyTooLarge if a frame exceeds
+// a limit, after which the reader cannot be used to read any more frames.
+func (r *Reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
 // Read a STOMP frame from the input. If the input contains one
 // or more heart-beat characters and no frame, then nil will
 // be returned for the frame. Calling programs should always check
 // for a nil frame.
+//
+// The frame is obtained from a pool, and calling programs that have
+// finished with the frame can return it to the pool by calling its
+// Release method. See Frame.Release.
 func (r *Reader) Read() (*Frame, error) {
 	commandSlice, err := r.readLine()
 	if err != nil {
@@ -55,65 +99,74 @@ func (r *Reader) Read() (*Frame, error) {
 		return nil, nil
 	}
 
-	f := New(string(commandSlice))
-	//println("RX:", f.Command)
-	switch f.Command {
 	// TODO(jpj): Is it appropriate to perform validation on the
 	// command at this point. Probably better to validate higher up,
 	// this way this type can be useful for any other non-STOMP protocols
 	// which happen to use the same frame format.
-	case CONNECT, STOMP, SEND, SUBSCRIBE,
-		UNSUBSCRIBE, ACK, NACK, BEGIN,
-		COMMIT, ABORT, DISCONNECT, CONNECTED,
-		MESSAGE, RECEIPT, ERROR:
-		// valid command
-	default:
+	command, ok := commands[string(commandSlice)]
+	if !ok {
 		return nil, ErrInvalidCommand
 	}
 
-	// read headers
-	for {
+	f := framePool.Get().(*Frame)
+	f.Command = command
+	if err = r.readHeaderAndBody(f); err != nil {
+		f.Release()
+		return nil, err
+	}
+
+	// pass back frame
+	return f, nil
+}
+
+// readHeaderAndBody reads the header entries and body of a frame
+// whose command has already been read.
+func (r *Reader) readHeaderAndBody(f *Frame) error {
+	esc := escapingFor(r.version, f.Command)
+	for headers := 0; ; headers++ {
 		headerSlice, err := r.readLine()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if len(headerSlice) == 0 {
 			// empty line means end of headers
 			break
 		}
+		if r.limits.MaxHeaders > 0 && headers == r.limits.MaxHeaders {
+			return ErrTooManyHeaders
+		}
 
 		index := bytes.IndexByte(headerSlice, colon)
 		if index <= 0 {
 			// colon is missing or header name is zero length
-			return nil, ErrInvalidFrameFormat
+			return ErrInvalidFrameFormat
 		}
 
-		name, err := unencodeValue(headerSlice[0:index])
+		err = f.Header.addEncoded(esc, headerSlice[0:index], headerSlice[index+1:])
 		if err != nil {
-			return nil, err
+			return err
 		}
-		value, err := unencodeValue(headerSlice[index+1:])
-		if err != nil {
-			return nil, err
-		}
-
-		//println("   ", name, ":", value)
-
-		f.Header.Add(name, value)
 	}
+	f.Header.endEncoded()
 
 	// get content length from the headers
 	if contentLength, ok, err := f.Header.ContentLength(); err != nil {
 		// happens if the content is malformed
-		return nil, err
+		return err
 	} else if ok {
 		// content length specified in the header, so use that
-		f.Body = make([]byte, contentLength)
+		if r.limits.MaxBodyLength > 0 && contentLength > r.limits.MaxBodyLength {
+			return ErrBodyTooLarge
+		}
+		if cap(f.body) < contentLength {
+			f.body = make([]byte, contentLength)
+		}
+		f.Body = f.body[:contentLength]
 		for bytesRead := 0; bytesRead < contentLength; {
 			n, err := r.reader.Read(f.Body[bytesRead:contentLength])
 			if err != nil {
-				return nil, err
+				return err
 			}
 			bytesRead += n
 		}
@@ -121,27 +174,53 @@ func (r *Reader) Read() (*Frame, error) {
 		// read the next byte and verify that it is a null byte
 		terminator, err := r.reader.ReadByte()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if terminator != 0 {
-			return nil, ErrInvalidFrameFormat
+			return ErrInvalidFrameFormat
 		}
 	} else {
-		f.Body, err = r.reader.ReadBytes(nullByte)
-		if err != nil {
-			return nil, err
+		f.Body = f.body[:0]
+		for {
+			slice, err := r.reader.ReadSlice(nullByte)
+			f.Body = append(f.Body, slice...)
+			if err == nil {
+				break
+			}
+			if err != bufio.ErrBufferFull {
+				return err
+			}
+			if r.limits.MaxBodyLength > 0 && len(f.Body) > r.limits.MaxBodyLength {
+				return ErrBodyTooLarge
+			}
+		}
+		if r.limits.MaxBodyLength > 0 && len(f.Body) > r.limits.MaxBodyLength+1 {
+			return ErrBodyTooLarge
 		}
-		// remove trailing null
+		// remove trailing null, and keep the buffer for reuse
+		f.body = f.Body
 		f.Body = f.Body[0 : len(f.Body)-1]
 	}
 
-	// pass back frame
-	return f, nil
+	return nil
 }
 
 // read one line from input and strip off terminating LF or terminating CR-LF
+// The line refers to the reader's buffers, and is only valid until the next read.
 func (r *Reader) readLine() (line []byte, err error) {
-	line, err = r.reader.ReadBytes(newline)
+	line, err = r.reader.ReadSlice(newline)
+	if err == bufio.ErrBufferFull {
+		// the line is longer than the buffer
+		r.line = append(r.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			if r.limits.MaxHeaderLength > 0 && len(r.line) > r.limits.MaxHeaderLength+len(crlfSlice) {
+				return nil, ErrHeaderTooLong
+			}
+			line, err = r.reader.ReadSlice(newline)
+			r.line = append(r.line, line...)
+		}
+		line = r.line
+	}
 	if err != nil {
 		return
 	}
@@ -152,6 +231,9 @@ func (r *Reader) readLine() (line []byte, err error) {
 	case bytes.HasSuffix(line, newlineSlice):
 		line = line[0 : len(line)-len(newlineSlice)]
 	}
+	if r.limits.MaxHeaderLength > 0 && len(line) > r.limits.MaxHeaderLength {
+		return nil, ErrHeaderTooLong
+	}
 
 	return
 }
@@ -29,7 +29,7 @@ func (s *ReaderSuite) TestConnect(c *C) {
 func (s *ReaderSuite) TestMultipleReads(c *C) {
 	text := "SEND\ndestination:xxx\n\nPayload\x00\n" +
 		"SEND\ndestination:yyy\ncontent-length:12\n" +
-		"dodgy\\c\\n\\cheader:dodgy\\c\\n\\r\\nvalue\\  \\\n\n" +
+		"dodgy\\c\\n\\cheader:dodgy\\c\\n\\r\\nvalue\\\\  \\\\\n\n" +
 		"123456789AB\x00\x00"
 
 	ioreaders := []io.Reader{
@@ -108,6 +108,109 @@ func (s *ReaderSuite) TestInvalidCommand(c *C) {
 	c.Check(err.Error(), Equals, "invalid command")
 }
 
+func (s *ReaderSuite) TestLongLines(c *C) {
+	value := strings.Repeat("v", 100)
+	body := strings.Repeat("b", 100)
+	reader := NewReaderSize(strings.NewReader("SEND\nlong:"+value+"\n\n"+body+"\x00"), 16)
+
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get("long"), Equals, value)
+	c.Check(string(f.Body), Equals, body)
+}
+
+func (s *ReaderSuite) TestLimits(c *C) {
+	testcases := []struct {
+		Text string
+		Err  error
+	}{
+		{"SEND\na:1\nb:2\n\n\x00", nil},
+		{"SEND\na:1\nb:2\nc:3\n\n\x00", ErrTooManyHeaders},
+		{"SEND\na:" + strings.Repeat("v", 18) + "\n\n\x00", nil},
+		{"SEND\na:" + strings.Repeat("v", 19) + "\n\n\x00", ErrHeaderTooLong},
+		{"SEND\na:" + strings.Repeat("v", 100) + "\n\n\x00", ErrHeaderTooLong},
+		{"SEND\n\n" + strings.Repeat("b", 20) + "\x00", nil},
+		{"SEND\n\n" + strings.Repeat("b", 21) + "\x00", ErrBodyTooLarge},
+		{"SEND\n\n" + strings.Repeat("b", 100) + "\x00", ErrBodyTooLarge},
+		{"SEND\ncontent-length:21\n\n" + strings.Repeat("b", 21) + "\x00", ErrBodyTooLarge},
+	}
+
+	for _, tc := range testcases {
+		reader := NewReaderSize(strings.NewReader(tc.Text), 16)
+		reader.SetLimits(Limits{MaxHeaders: 2, MaxHeaderLength: 20, MaxBodyLength: 20})
+		f, err := reader.Read()
+		if tc.Err == nil {
+			c.Check(err, IsNil, Commentf("%q", tc.Text))
+			c.Check(f, NotNil)
+		} else {
+			c.Check(err, Equals, tc.Err, Commentf("%q", tc.Text))
+			c.Check(f, IsNil)
+		}
+	}
+}
+
+func (s *ReaderSuite) TestRelease(c *C) {
+	text := "SEND\ndestination:/queue/a\ncontent-length:5\n\nfirst\x00" +
+		strings.Repeat("SEND\ndestination:/queue/b\n\nsecond\x00", 100)
+	reader := NewReader(strings.NewReader(text))
+
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	destination := f.Header.Get("destination")
+	fc := f.Clone()
+
+	// The pool can drop released frames, so it may take more
+	// than one attempt before a frame is reused.
+	reused := false
+	for i := 0; i < 100 && !reused; i++ {
+		released := f
+		f.Release()
+		f, err = reader.Read()
+		c.Assert(err, IsNil)
+		reused = f == released
+	}
+	c.Assert(reused, Equals, true)
+	c.Check(f.Header.Len(), Equals, 1)
+	c.Check(f.Header.Get("destination"), Equals, "/queue/b")
+	c.Check(string(f.Body), Equals, "second")
+
+	// strings from the header of the released frame are unchanged,
+	// and the clone does not share buffers with the released frame
+	c.Check(destination, Equals, "/queue/a")
+	c.Check(fc.Header.Get("destination"), Equals, "/queue/a")
+	c.Check(string(fc.Body), Equals, "first")
+}
+
+func (s *ReaderSuite) TestHeaderStrings(c *C) {
+	text := "SEND\ndestination:/queue/a\n\nfirst\x00" +
+		"SEND\ndestination:/queue/b\ncontent-length:6\n\nsecond\x00"
+	reader := NewReaderSize(strings.NewReader(text), 16)
+
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	destination := f.Header.Get("destination")
+	fc := f.Clone()
+	f.Header.Set("destination", "/queue/c")
+
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get("destination"), Equals, "/queue/b")
+	c.Check(string(f.Body), Equals, "second")
+
+	// strings from the header of an earlier frame are unchanged
+	c.Check(destination, Equals, "/queue/a")
+	c.Check(fc.Header.Get("destination"), Equals, "/queue/a")
+	c.Check(string(fc.Body), Equals, "first")
+}
+
+func (s *ReaderSuite) TestInvalidEscape(c *C) {
+	reader := NewReader(strings.NewReader("SEND\ndestination:a\\tb\n\n\x00"))
+
+	f, err := reader.Read()
+	c.Check(f, IsNil)
+	c.Check(err, Equals, ErrInvalidEscape)
+}
+
 func (s *ReaderSuite) TestMissingNull(c *C) {
 	reader := NewReader(strings.NewReader("SEND\ndeestination:xxx\ncontent-length:5\n\n\x00\x01\x02\x03\x04\n"))
 
@@ -13,9 +13,19 @@ var (
 	nullSlice    = []byte{0}      // null character
 )
 
+// A Flusher is an io.Writer that groups the data written to it, such as
+// a message-based transport. If the io.Writer passed to NewWriter
+// implements Flusher, its Flush method is called each time a complete
+// frame (or heart-beat) has been written to it.
+type Flusher interface {
+	Flush() error
+}
+
 // Writes STOMP frames to an underlying io.Writer.
 type Writer struct {
-	writer *bufio.Writer
+	writer  *bufio.Writer
+	flusher Flusher // nil if the io.Writer is not a Flusher
+	version string
 }
 
 // Creates a new Writer object, which writes to an underlying io.Writer.
@@ -24,10 +34,21 @@ func NewWriter(writer io.Writer) *Writer {
 }
 
 func NewWriterSize(writer io.Writer, bufferSize int) *Writer {
-	return &Writer{writer: bufio.NewWriterSize(writer, bufferSize)}
+	flusher, _ := writer.(Flusher)
+	return &Writer{writer: bufio.NewWriterSize(writer, bufferSize), flusher: flusher}
+}
+
+// SetVersion sets the version of the STOMP protocol negotiated for the
+// connection, which determines how header entries are escaped. Until
+// the version is set, header entries are escaped as per STOMP 1.2.
+// Header entries of the CONNECT and CONNECTED frames are never escaped.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
 }
 
-// Write the contents of a frame to the underlying io.Writer.
+// Write the contents of a frame to the underlying io.Writer. Returns
+// ErrInvalidHeader, without writing anything, if a header entry cannot
+// be encoded because it is not escaped.
 func (w *Writer) Write(f *Frame) error {
 	var err error
 
@@ -38,7 +59,16 @@ func (w *Writer) Write(f *Frame) error {
 			return err
 		}
 	} else {
-		_, err = w.writer.Write([]byte(f.Command))
+		esc := escapingFor(w.version, f.Command)
+		if f.Header != nil {
+			for i := 0; i < f.Header.Len(); i++ {
+				if err = esc.check(f.Header.GetAt(i)); err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err = w.writer.WriteString(f.Command)
 		if err != nil {
 			return err
 		}
@@ -53,7 +83,7 @@ func (w *Writer) Write(f *Frame) error {
 			for i := 0; i < f.Header.Len(); i++ {
 				key, value := f.Header.GetAt(i)
 				//println("   ", key, ":", value)
-				_, err = w.writer.Write(encodeValue(key))
+				err = esc.encode(w.writer, key)
 				if err != nil {
 					return err
 				}
@@ -61,7 +91,7 @@ func (w *Writer) Write(f *Frame) error {
 				if err != nil {
 					return err
 				}
-				_, err = w.writer.Write(encodeValue(value))
+				err = esc.encode(w.writer, value)
 				if err != nil {
 					return err
 				}
@@ -96,5 +126,9 @@ func (w *Writer) Write(f *Frame) error {
 		return err
 	}
 
+	if w.flusher != nil {
+		return w.flusher.Flush()
+	}
+
 	return nil
 }
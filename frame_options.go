@@ -0,0 +1,41 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/frame"
+)
+
+// FrameOpt contains options that apply to frames sent by the Conn.Ack,
+// Conn.Nack and Conn.BeginWithError functions, and the Transaction.Ack,
+// Transaction.Nack, Transaction.Commit and Transaction.Abort functions.
+var FrameOpt struct {
+	// Receipt specifies that the client should request acknowledgement
+	// from the server before the operation successfully completes. If the
+	// server responds with an ERROR frame that refers to the receipt, the
+	// operation returns a ReceiptError.
+	Receipt func(*frame.Frame) error
+
+	// Header provides the opportunity to include custom header entries
+	// in the frame that the client sends to the server.
+	Header func(key, value string) func(*frame.Frame) error
+}
+
+func init() {
+	FrameOpt.Receipt = func(f *frame.Frame) error {
+		switch f.Command {
+		case frame.CONNECT, frame.STOMP, frame.CONNECTED,
+			frame.MESSAGE, frame.RECEIPT, frame.ERROR:
+			// receipts are not permitted for connect frames, and
+			// server frames are never sent by the client
+			return ErrInvalidCommand
+		}
+		f.Header.Set(frame.Receipt, allocateId())
+		return nil
+	}
+
+	FrameOpt.Header = func(key, value string) func(*frame.Frame) error {
+		return func(f *frame.Frame) error {
+			f.Header.Add(key, value)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/go-stomp/stomp
+
+go 1.21
+
+require (
+	golang.org/x/crypto v0.21.0
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+)
+
+require (
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/rogpeppe/go-internal v1.9.0 // indirect
+)
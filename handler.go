@@ -0,0 +1,205 @@
+package stomp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+)
+
+// handleOptions is an opaque structure used to collect options
+// for the Subscription.Handle function.
+type handleOptions struct {
+	Workers    int
+	Retries    int
+	RetryDelay time.Duration
+	OnError    func(msg *Message, err error)
+}
+
+// HandleOpt contains options for the Subscription.Handle and
+// Subscription.HandleContext functions.
+var HandleOpt struct {
+	// Workers specifies the number of go-routines that call the handler
+	// concurrently. If not specified, messages are handled by a single
+	// go-routine in the order they are received.
+	Workers func(n int) func(*handleOptions) error
+
+	// Retry specifies that when the handler returns an error, it is called
+	// again with the same message after waiting for delay, up to the
+	// specified number of attempts, before the message is rejected.
+	// If not specified, a message is rejected the first time the handler
+	// returns an error.
+	Retry func(attempts int, delay time.Duration) func(*handleOptions) error
+
+	// OnError specifies a function that is called when the handler fails
+	// to process a message, or when the message cannot be acknowledged.
+	// If not specified, the error is logged.
+	OnError func(fn func(msg *Message, err error)) func(*handleOptions) error
+}
+
+func init() {
+	HandleOpt.Workers = func(n int) func(*handleOptions) error {
+		return func(o *handleOptions) error {
+			if n < 1 {
+				return ErrInvalidWorkerCount
+			}
+			o.Workers = n
+			return nil
+		}
+	}
+
+	HandleOpt.Retry = func(attempts int, delay time.Duration) func(*handleOptions) error {
+		return func(o *handleOptions) error {
+			if attempts < 0 {
+				attempts = 0
+			}
+			o.Retries = attempts
+			o.RetryDelay = delay
+			return nil
+		}
+	}
+
+	HandleOpt.OnError = func(fn func(msg *Message, err error)) func(*handleOptions) error {
+		return func(o *handleOptions) error {
+			o.OnError = fn
+			return nil
+		}
+	}
+}
+
+// Handle reads messages from the subscription and calls handler for
+// each message. It is an alternative to reading from the C channel.
+// Options in opts specify the number of worker go-routines, and how
+// handler errors are retried (see HandleOpt).
+//
+// For subscriptions that require acknowledgement, the message is
+// acknowledged when handler returns nil, and rejected with a NACK when
+// handler returns an error or panics. Note that for subscriptions with
+// AckClient, acknowledging a message also acknowledges all previous
+// messages, so AckClientIndividual is recommended with multiple workers.
+//
+// Handle returns nil once the subscription has been unsubscribed and all
+// messages in progress have been handled. If the subscription fails,
+// for example because the server sent an ERROR frame, that error is
+// returned.
+func (s *Subscription) Handle(handler func(*Message) error, opts ...func(*handleOptions) error) error {
+	return s.HandleContext(context.Background(), handler, opts...)
+}
+
+// HandleContext is like Handle, but stops reading messages when ctx is
+// cancelled, and returns ctx.Err() once all messages in progress have been
+// handled. The subscription remains active.
+func (s *Subscription) HandleContext(ctx context.Context, handler func(*Message) error, opts ...func(*handleOptions) error) error {
+	if handler == nil {
+		return ErrNilHandler
+	}
+
+	options := &handleOptions{Workers: 1}
+	for _, opt := range opts {
+		if opt == nil {
+			return ErrNilOption
+		}
+		if err := opt(options); err != nil {
+			return err
+		}
+	}
+	if options.OnError == nil {
+		options.OnError = func(msg *Message, err error) {
+			s.conn.options.Logger.Error("stomp: handler error",
+				"subscription", s.id,
+				"destination", s.destination,
+				"message-id", msg.Header.Get(frame.MessageId),
+				"error", err)
+		}
+	}
+
+	if s.isCompleted() {
+		return ErrCompletedSubscription
+	}
+
+	var (
+		wg       sync.WaitGroup
+		stop     = make(chan struct{})
+		stopOnce sync.Once
+		result   error
+	)
+
+	for i := 0; i < options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case msg, ok := <-s.C:
+					if !ok {
+						return
+					}
+					if msg.Err != nil {
+						stopOnce.Do(func() {
+							result = msg.Err
+							close(stop)
+						})
+						return
+					}
+					s.handleMessage(ctx, msg, handler, options)
+				case <-stop:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	if result != nil {
+		return result
+	}
+	return ctx.Err()
+}
+
+// handleMessage calls handler for msg, retrying as specified in options,
+// and then acknowledges or rejects the message.
+func (s *Subscription) handleMessage(ctx context.Context, msg *Message, handler func(*Message) error, options *handleOptions) {
+	err := callHandler(handler, msg)
+
+retry:
+	for attempt := 0; err != nil && attempt < options.Retries; attempt++ {
+		timer := time.NewTimer(options.RetryDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			break retry
+		}
+		err = callHandler(handler, msg)
+	}
+
+	if err != nil {
+		options.OnError(msg, err)
+	}
+
+	if !msg.ShouldAck() {
+		return
+	}
+	if err == nil {
+		err = s.conn.Ack(msg)
+	} else {
+		err = s.conn.Nack(msg)
+	}
+	if err != nil {
+		options.OnError(msg, err)
+	}
+}
+
+// callHandler calls handler, converting a panic into an error.
+func callHandler(handler func(*Message) error, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newErrorMessage(fmt.Sprintf("handler panic: %v", r))
+		}
+	}()
+	return handler(msg)
+}
@@ -0,0 +1,138 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) Test_handle_ack_nack(c *C) {
+	conn, rw := connectHelper(c, V12)
+	serverDone := make(chan bool)
+
+	go func() {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		id := f.Header.Get(frame.Id)
+
+		for i, body := range []string{"ok", "fail", "panic", "retry"} {
+			msg := frame.New(frame.MESSAGE,
+				frame.Subscription, id,
+				frame.MessageId, fmt.Sprint(i+1),
+				frame.Ack, fmt.Sprintf("ack-%d", i+1),
+				frame.Destination, "/queue/test-1")
+			msg.Body = []byte(body)
+			rw.Write(msg)
+		}
+
+		expected := []string{frame.ACK, frame.NACK, frame.NACK, frame.ACK}
+		for i, command := range expected {
+			f, err = rw.Read()
+			c.Check(err, IsNil)
+			c.Check(f.Command, Equals, command)
+			c.Check(f.Header.Get(frame.Id), Equals, fmt.Sprintf("ack-%d", i+1))
+		}
+		serverDone <- true
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.UNSUBSCRIBE)
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f.Header.Get(frame.Receipt)))
+		serverDone <- true
+	}()
+
+	sub, err := conn.Subscribe("/queue/test-1", AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	attempts := make(map[string]int)
+	handler := func(msg *Message) error {
+		body := string(msg.Body)
+		attempts[body]++
+		switch body {
+		case "fail":
+			return errors.New("failed")
+		case "panic":
+			panic("boom")
+		case "retry":
+			if attempts[body] == 1 {
+				return errors.New("try again")
+			}
+		}
+		return nil
+	}
+
+	var handlerErrors []string
+	result := make(chan error)
+	go func() {
+		result <- sub.Handle(handler,
+			HandleOpt.Retry(1, time.Millisecond),
+			HandleOpt.OnError(func(msg *Message, err error) {
+				handlerErrors = append(handlerErrors, err.Error())
+			}))
+	}()
+
+	<-serverDone
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(<-result, IsNil)
+
+	c.Check(attempts, DeepEquals, map[string]int{"ok": 1, "fail": 2, "panic": 2, "retry": 2})
+	c.Check(handlerErrors, DeepEquals, []string{"failed", "handler panic: boom"})
+	<-serverDone
+	rw.Close()
+}
+
+func (s *StompSuite) Test_handle_workers(c *C) {
+	conn, rw := connectHelper(c, V12)
+	defer rw.Close()
+
+	go func() {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		for i := 0; i < 3; i++ {
+			rw.Write(frame.New(frame.MESSAGE,
+				frame.Subscription, f.Header.Get(frame.Id),
+				frame.MessageId, fmt.Sprint(i),
+				frame.Destination, "/queue/test-1"))
+		}
+	}()
+
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto)
+	c.Assert(err, IsNil)
+
+	// all three messages must be in progress at the same time
+	// before any of the handlers can return
+	started := make(chan bool)
+	release := make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- sub.HandleContext(ctx, func(msg *Message) error {
+			started <- true
+			<-release
+			return nil
+		}, HandleOpt.Workers(3))
+	}()
+
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	cancel()
+	close(release)
+	c.Assert(<-result, Equals, context.Canceled)
+	c.Assert(sub.Active(), Equals, true)
+}
+
+func (s *StompSuite) Test_handle_invalid_options(c *C) {
+	sub := &Subscription{}
+	c.Assert(sub.Handle(nil), Equals, ErrNilHandler)
+
+	handler := func(*Message) error { return nil }
+	c.Assert(sub.Handle(handler, HandleOpt.Workers(0)), Equals, ErrInvalidWorkerCount)
+	c.Assert(sub.Handle(handler, nil), Equals, ErrNilOption)
+}
@@ -0,0 +1,74 @@
+package stomp
+
+import "time"
+
+// The OverflowPolicy type is an enumeration of the actions taken when
+// messages arrive for a subscription faster than the client program
+// reads them from the subscription's C channel.
+//
+// Each subscription buffers received messages until they are read from
+// the C channel. The overflow policy determines what happens when the
+// buffer is full. The buffer capacity and overflow policy are specified
+// for all subscriptions using the ConnOpt.SubscriptionBuffer connect
+// option, or for an individual subscription using the SubscribeOpt.Buffer
+// subscribe option.
+type OverflowPolicy int
+
+const (
+	// Stop reading from the STOMP server until the client program has
+	// read a message from the subscription. This is the default policy.
+	// Note that while the connection is blocked, no frames are processed
+	// for any subscription on the connection. If the client program does
+	// not read a message within the block timeout, the subscription fails
+	// as for OverflowError, so that the connection does not stay blocked.
+	// See ConnOpt.OverflowBlockTimeout.
+	OverflowBlock OverflowPolicy = iota
+
+	// Discard the oldest buffered message to make room for the
+	// newly received message.
+	OverflowDropOldest
+
+	// Discard the newly received message.
+	OverflowDropNewest
+
+	// Unsubscribe from the STOMP server. After any buffered messages,
+	// the client program receives a message whose Err field is
+	// ErrSubscriptionOverflow, and the C channel is closed.
+	OverflowError
+)
+
+// String returns the string representation of the OverflowPolicy value.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowError:
+		return "error"
+	}
+	panic("invalid OverflowPolicy value")
+}
+
+// Default number of messages buffered for each subscription.
+const DefaultSubscriptionBuffer = 16
+
+// Default maximum time that a subscription with the OverflowBlock
+// policy stops the client reading from the STOMP server.
+const DefaultOverflowBlockTimeout = 10 * time.Second
+
+// checkBuffer returns an error if a subscription buffer capacity or
+// overflow policy is not valid.
+func checkBuffer(capacity int, overflow OverflowPolicy) error {
+	switch overflow {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowError:
+	default:
+		return ErrInvalidOverflowPolicy
+	}
+	if capacity < 1 {
+		return ErrInvalidBufferCapacity
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package stomp
+
+import (
+	"io"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+)
+
+// Default values used by a ReconnectPolicy when the corresponding
+// field is zero.
+const (
+	DefaultReconnectInitialInterval = time.Second
+	DefaultReconnectMaxInterval     = time.Minute
+	DefaultReconnectMultiplier      = 2.0
+)
+
+// A ReconnectPolicy specifies how a Conn re-establishes its connection
+// to the STOMP server after the underlying network connection fails.
+// A reconnect policy is specified using the ConnOpt.Reconnect connect option.
+//
+// When the network connection fails, the Conn dials the server again,
+// repeats the STOMP connect protocol sequence using the original connect
+// options, and re-issues a SUBSCRIBE frame for every active subscription
+// using the same subscription id. The Subscription.C channels remain
+// open while the connection is being re-established.
+//
+// Frames that were awaiting a RECEIPT when the connection failed are
+// completed with an error. Transactions in progress, and messages that
+// were received but not acknowledged, are not recovered: the STOMP
+// server discards them when the original connection closes.
+//
+// The Conn does not attempt to reconnect after the server sends an
+// ERROR frame, or after the client program calls Disconnect or
+// MustDisconnect. Calling Disconnect or MustDisconnect while the Conn
+// is waiting to reconnect stops any further attempts, and closes the
+// subscription channels.
+type ReconnectPolicy struct {
+	// Dial creates a new connection to the STOMP server. When the Conn is
+	// created using the Dial or DialTLS function, and this field is nil, the
+	// network and address (and TLS configuration) passed to Dial or DialTLS
+	// are used. This field must be specified when the Conn is created using
+	// the Connect function.
+	Dial func() (io.ReadWriteCloser, error)
+
+	// MaxAttempts is the number of reconnect attempts made before the
+	// Conn gives up and reports the failure on all subscriptions. If zero,
+	// the Conn keeps attempting to reconnect until Disconnect or
+	// MustDisconnect is called.
+	MaxAttempts int
+
+	// InitialInterval is the time to wait before the first reconnect attempt.
+	// If zero, DefaultReconnectInitialInterval is used.
+	InitialInterval time.Duration
+
+	// MaxInterval is the maximum time to wait between reconnect attempts.
+	// If zero, DefaultReconnectMaxInterval is used.
+	MaxInterval time.Duration
+
+	// Multiplier is the factor by which the interval between reconnect
+	// attempts is increased after each failed attempt. If zero,
+	// DefaultReconnectMultiplier is used.
+	Multiplier float64
+
+	// OnDisconnect, if not nil, is called when the connection to the
+	// STOMP server fails and the Conn is about to attempt to reconnect.
+	// The err parameter describes the reason for the failure.
+	OnDisconnect func(c *Conn, err error)
+
+	// OnReconnect, if not nil, is called after the Conn has successfully
+	// reconnected to the STOMP server and re-issued its subscriptions.
+	OnReconnect func(c *Conn)
+}
+
+// Returns a copy of the policy with default values applied.
+func (p ReconnectPolicy) withDefaults() *ReconnectPolicy {
+	if p.InitialInterval <= 0 {
+		p.InitialInterval = DefaultReconnectInitialInterval
+	}
+	if p.MaxInterval <= 0 {
+		p.MaxInterval = DefaultReconnectMaxInterval
+	}
+	if p.MaxInterval < p.InitialInterval {
+		p.MaxInterval = p.InitialInterval
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultReconnectMultiplier
+	}
+	return &p
+}
+
+// reconnect is called by the processLoop go-routine when the connection
+// with the STOMP server has failed. The cause parameter describes the failure.
+// The receipts and subs maps contain the channels waiting for a RECEIPT and
+// the subscription channels known to the processLoop, and subscribed contains
+// the SUBSCRIBE frame for each active subscription, keyed by subscription id.
+//
+// Returns a writer for the new connection, or an error if the connection
+// could not be re-established.
+func (c *Conn) reconnect(cause error, receipts, subs map[string]chan *frame.Frame, subscribed map[string]*frame.Frame) (*frame.Writer, error) {
+	policy := c.options.Reconnect
+	if c.isStopped() {
+		return nil, ErrAlreadyClosed
+	}
+
+	// Close the failed connection. The readLoop go-routine will exit once
+	// it notices, and anything it has read is discarded.
+	c.mutex.Lock()
+	c.conn.Close()
+	c.mutex.Unlock()
+	go func(ch chan *frame.Frame) {
+		for range ch {
+		}
+	}(c.readCh)
+
+	// Anything waiting on a receipt will not receive it now, so close the
+	// channel. Active subscription channels are left alone, they survive
+	// the reconnection, but subscriptions that were being unsubscribed
+	// are finished.
+	for id, ch := range receipts {
+		close(ch)
+		delete(receipts, id)
+	}
+	for id, ch := range subs {
+		if _, ok := subscribed[id]; !ok {
+			close(ch)
+			delete(subs, id)
+		}
+	}
+
+	c.options.Logger.Warn("stomp: connection failed; reconnecting",
+		"session", c.Session(),
+		"error", cause)
+
+	if policy.OnDisconnect != nil {
+		policy.OnDisconnect(c, cause)
+	}
+
+	interval := policy.InitialInterval
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-c.stop:
+			timer.Stop()
+			return nil, ErrAlreadyClosed
+		}
+		interval = time.Duration(float64(interval) * policy.Multiplier)
+		if interval > policy.MaxInterval {
+			interval = policy.MaxInterval
+		}
+
+		conn, err := policy.Dial()
+		if err != nil {
+			c.options.Logger.Debug("stomp: reconnect attempt failed",
+				"attempt", attempt,
+				"error", err)
+			cause = err
+			continue
+		}
+
+		reader, writer, err := c.connect(conn, c.options)
+		if err == nil {
+			for _, f := range subscribed {
+				if err = writer.Write(f); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			c.options.Logger.Debug("stomp: reconnect attempt failed",
+				"attempt", attempt,
+				"error", err)
+			conn.Close()
+			cause = err
+			continue
+		}
+
+		// Disconnect or MustDisconnect might have been called while
+		// connecting. Checking under the mutex ensures that they close
+		// either this connection or the failed one.
+		c.mutex.Lock()
+		if c.isStopped() {
+			c.mutex.Unlock()
+			conn.Close()
+			return nil, ErrAlreadyClosed
+		}
+		c.conn = conn
+		c.mutex.Unlock()
+
+		c.readCh = make(chan *frame.Frame, 8)
+		go readLoop(c.readCh, reader)
+
+		c.options.Logger.Info("stomp: reconnected",
+			"session", c.Session(),
+			"attempt", attempt)
+
+		if policy.OnReconnect != nil {
+			policy.OnReconnect(c)
+		}
+		return writer, nil
+	}
+
+	c.options.Logger.Error("stomp: reconnect failed", "error", cause)
+	return nil, newErrorMessage("reconnect failed: " + cause.Error())
+}
@@ -0,0 +1,203 @@
+package stomp
+
+import (
+	"io"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/testutil"
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) Test_reconnect_requires_dial(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	defer fc2.Close()
+
+	conn, err := Connect(fc1, ConnOpt.Reconnect(ReconnectPolicy{}))
+	c.Assert(conn, IsNil)
+	c.Assert(err, Equals, ErrReconnectDialMissing)
+}
+
+func (s *StompSuite) Test_reconnect_resubscribes(c *C) {
+	servers := make(chan *fakeReaderWriter, 2)
+	disconnected := make(chan error, 1)
+	reconnected := make(chan bool, 1)
+
+	dial := func() (io.ReadWriteCloser, error) {
+		fc1, fc2 := testutil.NewFakeConn(c)
+		servers <- &fakeReaderWriter{
+			reader: frame.NewReader(fc2),
+			writer: frame.NewWriter(fc2),
+			conn:   fc2,
+		}
+		return fc1, nil
+	}
+
+	// acts as the server side of the connection
+	accept := func() *fakeReaderWriter {
+		rw := <-servers
+		f, err := rw.Read()
+		c.Assert(err, IsNil)
+		c.Assert(f.Command, Equals, frame.CONNECT)
+		rw.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+		return rw
+	}
+
+	go func() {
+		// first connection receives the subscription, then fails
+		rw := accept()
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		rw.Close()
+
+		// second connection receives the subscription again
+		rw = accept()
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		c.Check(f.Header.Get(frame.Id), Equals, "sub-1")
+		c.Check(f.Header.Get(frame.Destination), Equals, "/queue/test-1")
+
+		msg := frame.New(frame.MESSAGE,
+			frame.Subscription, "sub-1",
+			frame.MessageId, "1",
+			frame.Destination, "/queue/test-1")
+		msg.Body = []byte("after reconnect")
+		rw.Write(msg)
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.DISCONNECT)
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f.Header.Get(frame.Receipt)))
+	}()
+
+	client, err := dial()
+	c.Assert(err, IsNil)
+
+	conn, err := Connect(client, ConnOpt.Reconnect(ReconnectPolicy{
+		Dial:            dial,
+		InitialInterval: time.Millisecond,
+		OnDisconnect: func(conn *Conn, err error) {
+			disconnected <- err
+		},
+		OnReconnect: func(conn *Conn) {
+			reconnected <- true
+		},
+	}))
+	c.Assert(err, IsNil)
+
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto, SubscribeOpt.Id("sub-1"))
+	c.Assert(err, IsNil)
+
+	c.Assert(<-disconnected, ErrorMatches, "connection closed")
+	c.Assert(<-reconnected, Equals, true)
+
+	msg, ok := <-sub.C
+	c.Assert(ok, Equals, true)
+	c.Assert(msg.Err, IsNil)
+	c.Assert(string(msg.Body), Equals, "after reconnect")
+
+	c.Assert(conn.Disconnect(), IsNil)
+}
+
+func (s *StompSuite) Test_reconnect_gives_up(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	go func() {
+		reader := frame.NewReader(fc2)
+		writer := frame.NewWriter(fc2)
+		_, err := reader.Read()
+		c.Check(err, IsNil)
+		writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+		_, err = reader.Read()
+		c.Check(err, IsNil)
+		fc2.Close()
+	}()
+
+	attempts := 0
+	conn, err := Connect(fc1, ConnOpt.Reconnect(ReconnectPolicy{
+		Dial: func() (io.ReadWriteCloser, error) {
+			attempts++
+			return nil, io.ErrUnexpectedEOF
+		},
+		MaxAttempts:     3,
+		InitialInterval: time.Millisecond,
+	}))
+	c.Assert(err, IsNil)
+
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto)
+	c.Assert(err, IsNil)
+
+	msg, ok := <-sub.C
+	c.Assert(ok, Equals, true)
+	c.Assert(msg.Err, ErrorMatches, "reconnect failed: unexpected EOF")
+	c.Assert(attempts, Equals, 3)
+
+	_, ok = <-sub.C
+	c.Assert(ok, Equals, false)
+}
+
+func (s *StompSuite) Test_reconnect_stopped_by_disconnect(c *C) {
+	for _, must := range []bool{false, true} {
+		fc1, fc2 := testutil.NewFakeConn(c)
+		go func() {
+			reader := frame.NewReader(fc2)
+			writer := frame.NewWriter(fc2)
+			_, err := reader.Read()
+			c.Check(err, IsNil)
+			writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+			_, err = reader.Read()
+			c.Check(err, IsNil)
+			fc2.Close()
+		}()
+
+		disconnected := make(chan bool, 1)
+		dialled := make(chan bool, 1)
+		conn, err := Connect(fc1, ConnOpt.Reconnect(ReconnectPolicy{
+			Dial: func() (io.ReadWriteCloser, error) {
+				dialled <- true
+				return nil, io.ErrUnexpectedEOF
+			},
+			InitialInterval: time.Hour,
+			OnDisconnect: func(conn *Conn, err error) {
+				disconnected <- true
+			},
+		}))
+		c.Assert(err, IsNil)
+
+		sub, err := conn.Subscribe("/queue/test-1", AckAuto)
+		c.Assert(err, IsNil)
+		<-disconnected
+
+		// waiting to reconnect, so the connection details can be read
+		// while the processLoop go-routine is reconnecting
+		c.Check(conn.Version(), Equals, V12)
+
+		done := make(chan error, 1)
+		go func() {
+			if must {
+				done <- conn.MustDisconnect()
+			} else {
+				done <- conn.Disconnect()
+			}
+		}()
+		select {
+		case err = <-done:
+			c.Check(err, IsNil)
+		case <-time.After(time.Second):
+			c.Fatal("disconnect did not stop reconnecting")
+		}
+
+		msg, ok := <-sub.C
+		c.Assert(ok, Equals, true)
+		c.Check(msg.Err, ErrorMatches, "connection already closed")
+		_, ok = <-sub.C
+		c.Check(ok, Equals, false)
+
+		select {
+		case <-dialled:
+			c.Error("reconnect attempted after disconnect")
+		default:
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-stomp/stomp/server/topic"
+)
+
+// Permission is a set of operations that a client can perform on a
+// destination.
+type Permission int
+
+const (
+	// PermissionRead allows a client to subscribe to a destination.
+	PermissionRead Permission = 1 << iota
+
+	// PermissionWrite allows a client to send messages to a destination.
+	PermissionWrite
+
+	// PermissionAdmin allows administrative operations on a destination,
+	// and includes read and write permission.
+	PermissionAdmin
+)
+
+var permissionNames = []struct {
+	perm Permission
+	name string
+}{
+	{PermissionRead, "read"},
+	{PermissionWrite, "write"},
+	{PermissionAdmin, "admin"},
+}
+
+// String returns the permission names separated by commas, eg "read,write".
+func (p Permission) String() string {
+	var names []string
+	for _, pn := range permissionNames {
+		if p&pn.perm != 0 {
+			names = append(names, pn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParsePermission parses permission names separated by commas, as
+// returned by Permission.String.
+func ParsePermission(s string) (Permission, error) {
+	var p Permission
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, pn := range permissionNames {
+			if strings.EqualFold(strings.TrimSpace(name), pn.name) {
+				p |= pn.perm
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown permission %q", name)
+		}
+	}
+	return p, nil
+}
+
+// Interface for authorizing operations by STOMP clients, after they have
+// been authenticated.
+type Authorizer interface {
+	// Authorize returns true if the principal is allowed the permission
+	// on the destination. The principal is the login of the client, or
+	// the common name of its certificate if the client logged in with a
+	// TLS client certificate and no login. It is blank for anonymous
+	// clients.
+	Authorize(principal, destination string, perm Permission) bool
+}
+
+// An ACLRule grants permissions on destinations to a principal.
+type ACLRule struct {
+	// Principal is the login that is granted permission, or "*" to grant
+	// permission to all clients, including anonymous clients.
+	Principal string
+
+	// Pattern is matched against destinations. It can contain the wildcards
+	// supported for topic destinations: see topic.Match. A client that
+	// subscribes to a wildcard destination needs a rule with a pattern
+	// that matches every destination that the wildcard destination
+	// matches, so "/topic/>" allows subscribing to "/topic/orders.>",
+	// but "/topic/*" does not allow subscribing to "/topic/>".
+	Pattern string
+
+	// Permissions granted on matching destinations.
+	Permissions Permission
+}
+
+// ACL is an access control list, which is an Authorizer that allows
+// an operation if any of its rules grants permission. Operations that
+// are not granted permission by a rule are not allowed. The rules
+// must not be changed after the ACL is first used.
+type ACL struct {
+	Rules []ACLRule
+
+	once     sync.Once
+	patterns []*topic.Pattern // compiled rule patterns, indexed like Rules
+}
+
+// Authorize returns true if any rule grants the principal the permission
+// on the destination. Admin permission includes read and write permission.
+func (acl *ACL) Authorize(principal, destination string, perm Permission) bool {
+	acl.once.Do(func() {
+		for _, rule := range acl.Rules {
+			acl.patterns = append(acl.patterns, topic.Compile(rule.Pattern))
+		}
+	})
+	dest := topic.Compile(destination)
+	for i, rule := range acl.Rules {
+		granted := rule.Permissions
+		if granted&PermissionAdmin != 0 {
+			granted |= PermissionRead | PermissionWrite
+		}
+		if granted&perm != perm {
+			continue
+		}
+		if rule.Principal != "*" && rule.Principal != principal {
+			continue
+		}
+		if acl.patterns[i].Covers(dest) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseACL reads an ACL with one rule per line. Each line contains
+// the principal, the permissions and the destination pattern, separated
+// by white space:
+//
+//	# principal  permissions  pattern
+//	alice        read,write   /queue/orders.*
+//	*            read         /topic/public.>
+//	admin        admin        /#
+//
+// Blank lines, and lines starting with '#', are ignored.
+func ParseACL(r io.Reader) (*ACL, error) {
+	acl := &ACL{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected principal, permissions and pattern", lineNumber)
+		}
+		perm, err := ParsePermission(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+		}
+		acl.Rules = append(acl.Rules, ACLRule{
+			Principal:   fields[0],
+			Pattern:     fields[2],
+			Permissions: perm,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// LoadACL reads an ACL from a file, in the format read by ParseACL.
+func LoadACL(filename string) (*ACL, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	acl, err := ParseACL(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	return acl, nil
+}
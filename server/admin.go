@@ -0,0 +1,437 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/client"
+	"github.com/go-stomp/stomp/server/queue"
+)
+
+// AdminDestination is the destination on which a principal needs
+// PermissionAdmin for admin API requests that are not about a queue,
+// if the server has an Authorizer. See Server.AdminHandler.
+const AdminDestination = "/admin"
+
+// Default number of messages returned when browsing a queue.
+const defaultBrowseLimit = 100
+
+// AdminHandler returns an http.Handler providing a JSON API for
+// inspecting and managing the server:
+//
+//	GET    /connections                list client connections and their subscriptions
+//	GET    /connections/{id}           describe a client connection
+//	DELETE /connections/{id}           close a client connection
+//	GET    /destinations               list destinations, with queue depths and message counts
+//	GET    /messages?queue=Q&limit=N   browse up to N (default 100) messages waiting in queue Q
+//	POST   /purge?queue=Q              remove all messages waiting in queue Q
+//	POST   /move?queue=Q&to=T&limit=N  move up to N (default all) messages from queue Q to queue T
+//
+// A queue is a destination starting with QueuePrefix, or the queue of a
+// durable subscription as shown in the subscriptions of a connection.
+// Browsing requires queue storage that implements queue.BrowsingStorage.
+//
+// If the server has an Authenticator, requests must use HTTP basic
+// authentication. If the server has an Authorizer, the principal needs
+// PermissionAdmin on the queue for queue requests, and on
+// AdminDestination for other requests. The handler can be served under a
+// path prefix using http.StripPrefix.
+func (s *Server) AdminHandler() http.Handler {
+	return &adminHandler{server: s}
+}
+
+type adminHandler struct {
+	server *Server
+}
+
+// Errors returned to admin API clients, with their HTTP status.
+type adminError struct {
+	status int
+	msg    string
+}
+
+func (e *adminError) Error() string {
+	return e.msg
+}
+
+var (
+	errAdminNotFound           = &adminError{http.StatusNotFound, "not found"}
+	errAdminMethod             = &adminError{http.StatusMethodNotAllowed, "method not allowed"}
+	errAdminUnauthenticated    = &adminError{http.StatusUnauthorized, "authentication failed"}
+	errAdminConnNotFound       = &adminError{http.StatusNotFound, "connection not found"}
+	errAdminBrowseNotSupported = &adminError{http.StatusNotImplemented, "browsing is not supported by the queue storage"}
+)
+
+func badRequest(format string, args ...interface{}) error {
+	return &adminError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func forbidden(destination string) error {
+	return &adminError{http.StatusForbidden, "not authorized: " + destination}
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := h.serve(r)
+	if err != nil {
+		var adminErr *adminError
+		switch {
+		case errors.As(err, &adminErr):
+		case err == ErrServerClosed:
+			adminErr = &adminError{http.StatusServiceUnavailable, err.Error()}
+		default:
+			adminErr = &adminError{http.StatusInternalServerError, err.Error()}
+		}
+		if adminErr == errAdminUnauthenticated {
+			w.Header().Set("WWW-Authenticate", `Basic realm="stomp"`)
+		}
+		writeJSON(w, adminErr.status, map[string]string{"error": adminErr.msg})
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// Handles a request, returning the value to send as JSON, or nil
+// if there is no content.
+func (h *adminHandler) serve(r *http.Request) (interface{}, error) {
+	principal, err := h.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/connections":
+		if err := h.authorize(principal, AdminDestination, r, http.MethodGet); err != nil {
+			return nil, err
+		}
+		return h.connections(), nil
+	case strings.HasPrefix(path, "/connections/"):
+		if err := h.authorize(principal, AdminDestination, r, http.MethodGet, http.MethodDelete); err != nil {
+			return nil, err
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(path, "/connections/"), 10, 64)
+		if err != nil {
+			return nil, errAdminNotFound
+		}
+		return h.connection(id, r.Method == http.MethodDelete)
+	case path == "/destinations":
+		if err := h.authorize(principal, AdminDestination, r, http.MethodGet); err != nil {
+			return nil, err
+		}
+		return h.destinations()
+	case path == "/messages":
+		name, err := h.queue(principal, r, "queue", http.MethodGet)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := queryInt(r, "limit", defaultBrowseLimit)
+		if err != nil {
+			return nil, err
+		}
+		return h.browse(name, limit)
+	case path == "/purge":
+		name, err := h.queue(principal, r, "queue", http.MethodPost)
+		if err != nil {
+			return nil, err
+		}
+		return h.purge(name)
+	case path == "/move":
+		name, err := h.queue(principal, r, "queue", http.MethodPost)
+		if err != nil {
+			return nil, err
+		}
+		target, err := h.queue(principal, r, "to", http.MethodPost)
+		if err != nil {
+			return nil, err
+		}
+		if !isQueueDestination(target) {
+			return nil, badRequest("messages can only be moved to destinations starting with %s", QueuePrefix)
+		}
+		if target == name {
+			return nil, badRequest("cannot move messages to the same queue")
+		}
+		limit, err := queryInt(r, "limit", 0)
+		if err != nil {
+			return nil, err
+		}
+		return h.move(name, target, limit)
+	}
+	return nil, errAdminNotFound
+}
+
+// Returns the principal for a request, which is the login, or the common
+// name of the verified client certificate if there is no login.
+func (h *adminHandler) authenticate(r *http.Request) (string, error) {
+	login, passcode, _ := r.BasicAuth()
+	var cert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert = r.TLS.VerifiedChains[0][0]
+	}
+	if !newConfig(h.server).Authenticate(login, passcode, cert) {
+		return "", errAdminUnauthenticated
+	}
+	if login == "" && cert != nil {
+		return cert.Subject.CommonName, nil
+	}
+	return login, nil
+}
+
+// Checks that the request uses one of the methods, and that the
+// principal has admin permission on the destination.
+func (h *adminHandler) authorize(principal, destination string, r *http.Request, methods ...string) error {
+	allowed := false
+	for _, method := range methods {
+		allowed = allowed || r.Method == method
+	}
+	if !allowed {
+		return errAdminMethod
+	}
+	if !newConfig(h.server).authorize(principal, destination, PermissionAdmin) {
+		return forbidden(destination)
+	}
+	return nil
+}
+
+// Returns the queue named by a query parameter, after checking that the
+// principal can administer it.
+func (h *adminHandler) queue(principal string, r *http.Request, param, method string) (string, error) {
+	name := r.URL.Query().Get(param)
+	if name == "" {
+		return "", badRequest("missing %s parameter", param)
+	}
+	if !isQueueDestination(name) && !strings.HasPrefix(name, durableRegistryQueue+"/") {
+		return "", badRequest("not a queue: %s", name)
+	}
+	if err := h.authorize(principal, name, r, method); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Returns the value of an integer query parameter, which
+// must not be negative, or def if it is not present.
+func queryInt(r *http.Request, param string, def int) (int, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, badRequest("invalid %s parameter: %s", param, value)
+	}
+	return n, nil
+}
+
+type adminConnection struct {
+	Id             uint64              `json:"id"`
+	RemoteAddr     string              `json:"remote-addr"`
+	ConnectedAt    time.Time           `json:"connected-at"`
+	Login          string              `json:"login,omitempty"`
+	Principal      string              `json:"principal,omitempty"`
+	ClientId       string              `json:"client-id,omitempty"`
+	Session        string              `json:"session,omitempty"`
+	Version        string              `json:"version,omitempty"`
+	ReadHeartBeat  string              `json:"read-heart-beat"`
+	WriteHeartBeat string              `json:"write-heart-beat"`
+	Subscriptions  []adminSubscription `json:"subscriptions"`
+}
+
+type adminSubscription struct {
+	Id          string `json:"id"`
+	Destination string `json:"destination"`
+	Ack         string `json:"ack"`
+	Prefetch    int    `json:"prefetch"`
+	Selector    string `json:"selector,omitempty"`
+	Queue       string `json:"queue,omitempty"`
+}
+
+func newAdminConnection(info client.ConnInfo) adminConnection {
+	c := adminConnection{
+		Id:             info.Id,
+		RemoteAddr:     info.RemoteAddr,
+		ConnectedAt:    info.ConnectedAt,
+		Login:          info.Login,
+		Principal:      info.Principal,
+		ClientId:       info.ClientId,
+		Session:        info.Session,
+		Version:        string(info.Version),
+		ReadHeartBeat:  info.ReadHeartBeat.String(),
+		WriteHeartBeat: info.WriteHeartBeat.String(),
+		Subscriptions:  make([]adminSubscription, 0, len(info.Subscriptions)),
+	}
+	for _, sub := range info.Subscriptions {
+		c.Subscriptions = append(c.Subscriptions, adminSubscription(sub))
+	}
+	return c
+}
+
+func (h *adminHandler) connections() []adminConnection {
+	conns := h.server.processor().connections()
+	result := make([]adminConnection, 0, len(conns))
+	for _, conn := range conns {
+		result = append(result, newAdminConnection(conn.Info()))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+// Describes the connection with an id, and closes it if close is true.
+func (h *adminHandler) connection(id uint64, close bool) (interface{}, error) {
+	for _, conn := range h.server.processor().connections() {
+		if conn.Id() != id {
+			continue
+		}
+		if close {
+			newConfig(h.server).Logger().Info("closing connection from admin API", "conn", id)
+			conn.Close()
+			return nil, nil
+		}
+		return newAdminConnection(conn.Info()), nil
+	}
+	return nil, errAdminConnNotFound
+}
+
+type adminDestination struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"` // queue, topic or durable
+	Depth         int64  `json:"depth"`
+	Subscriptions int64  `json:"subscriptions"`
+	Enqueued      int64  `json:"enqueued"`
+	Delivered     int64  `json:"delivered"`
+}
+
+// Lists the destinations in queue storage, and the destinations
+// that have been used since the server started.
+func (h *adminHandler) destinations() ([]adminDestination, error) {
+	proc := h.server.processor()
+	m := proc.metrics
+	var depths map[string]int64
+	err := proc.do(func() {
+		proc.countQueued()
+		depths = m.QueueDepth.Values()
+	})
+	if err != nil {
+		return nil, err
+	}
+	subs := m.Subscriptions.Values()
+	enqueued := m.MessagesEnqueued.Values()
+	delivered := m.MessagesDelivered.Values()
+
+	names := make(map[string]bool)
+	for _, values := range []map[string]int64{depths, subs, enqueued, delivered} {
+		for name := range values {
+			names[name] = true
+		}
+	}
+	result := make([]adminDestination, 0, len(names))
+	for name := range names {
+		d := adminDestination{
+			Name:          name,
+			Type:          "topic",
+			Depth:         depths[name],
+			Subscriptions: subs[name],
+			Enqueued:      enqueued[name],
+			Delivered:     delivered[name],
+		}
+		if isQueueDestination(name) {
+			d.Type = "queue"
+		} else if strings.HasPrefix(name, durableRegistryQueue+"/") {
+			d.Type = "durable"
+		}
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+type adminMessage struct {
+	Headers    [][2]string `json:"headers"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 []byte      `json:"body-base64,omitempty"` // if the body is not UTF-8
+}
+
+func newAdminMessage(f *frame.Frame) adminMessage {
+	msg := adminMessage{Headers: make([][2]string, 0, f.Header.Len())}
+	for i := 0; i < f.Header.Len(); i++ {
+		key, value := f.Header.GetAt(i)
+		msg.Headers = append(msg.Headers, [2]string{key, value})
+	}
+	if utf8.Valid(f.Body) {
+		msg.Body = string(f.Body)
+	} else {
+		msg.BodyBase64 = f.Body
+	}
+	return msg
+}
+
+func (h *adminHandler) browse(name string, limit int) ([]adminMessage, error) {
+	proc := h.server.processor()
+	var frames []*frame.Frame
+	var browseErr error
+	if err := proc.do(func() { frames, browseErr = proc.qm.Browse(name, limit) }); err != nil {
+		return nil, err
+	}
+	if browseErr == queue.ErrNotSupported {
+		return nil, errAdminBrowseNotSupported
+	} else if browseErr != nil {
+		return nil, browseErr
+	}
+	result := make([]adminMessage, 0, len(frames))
+	for _, f := range frames {
+		result = append(result, newAdminMessage(f))
+	}
+	return result, nil
+}
+
+func (h *adminHandler) purge(name string) (interface{}, error) {
+	proc := h.server.processor()
+	var count int
+	var purgeErr error
+	if err := proc.do(func() { count, purgeErr = proc.qm.Purge(name) }); err != nil {
+		return nil, err
+	}
+	if purgeErr != nil {
+		return nil, purgeErr
+	}
+	proc.logger().Info("purged queue from admin API", "queue", name, "count", count)
+	return map[string]int{"purged": count}, nil
+}
+
+func (h *adminHandler) move(name, target string, limit int) (interface{}, error) {
+	proc := h.server.processor()
+	var count int
+	var moveErr error
+	if err := proc.do(func() { count, moveErr = proc.qm.Move(name, target, limit) }); err != nil {
+		return nil, err
+	}
+	if moveErr != nil {
+		return nil, moveErr
+	}
+	proc.logger().Info("moved messages from admin API", "queue", name, "to", target, "count", count)
+	return map[string]int{"moved": count}, nil
+}
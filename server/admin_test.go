@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp"
+	"github.com/go-stomp/stomp/frame"
+	. "gopkg.in/check.v1"
+)
+
+type testPasscodes map[string]string
+
+func (p testPasscodes) Authenticate(login, passcode string) bool {
+	expected, ok := p[login]
+	return ok && passcode == expected
+}
+
+func (s *ServerSuite) TestAdmin(c *C) {
+	addr := "127.0.0.1:59102"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{
+		Authenticator: testPasscodes{"admin": "a", "bob": "b"},
+		Authorizer: &ACL{Rules: []ACLRule{
+			{Principal: "admin", Pattern: AdminDestination, Permissions: PermissionAdmin},
+			{Principal: "admin", Pattern: "/queue/>", Permissions: PermissionAdmin},
+			{Principal: "bob", Pattern: "/queue/>", Permissions: PermissionRead | PermissionWrite},
+		}},
+	}
+	go server.Serve(l)
+	defer server.Close()
+	admin := httptest.NewServer(server.AdminHandler())
+	defer admin.Close()
+
+	request := func(method, path, login string, result interface{}) int {
+		req, err := http.NewRequest(method, admin.URL+path, nil)
+		c.Assert(err, IsNil)
+		if login != "" {
+			req.SetBasicAuth(login, login[:1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		if result != nil && resp.StatusCode == http.StatusOK {
+			c.Assert(json.NewDecoder(resp.Body).Decode(result), IsNil)
+		}
+		return resp.StatusCode
+	}
+
+	bob, err := stomp.Dial("tcp", addr,
+		stomp.ConnOpt.Login("bob", "b"),
+		stomp.ConnOpt.Header(frame.ClientId, "bob-client"))
+	c.Assert(err, IsNil)
+	sub, err := bob.Subscribe("/queue/a", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(frame.Selector, "n > 0"),
+		stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+	for i := 1; i <= 3; i++ {
+		err = bob.Send("/queue/b", "text/plain", []byte("message "+strconv.Itoa(i)),
+			stomp.SendOpt.Header("n", strconv.Itoa(i)),
+			stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	// authentication and authorization
+	c.Check(request("GET", "/connections", "", nil), Equals, http.StatusUnauthorized)
+	c.Check(request("GET", "/connections", "bob", nil), Equals, http.StatusForbidden)
+	c.Check(request("POST", "/purge?queue=/queue/b", "bob", nil), Equals, http.StatusForbidden)
+	c.Check(request("POST", "/connections", "admin", nil), Equals, http.StatusMethodNotAllowed)
+	c.Check(request("GET", "/unknown", "admin", nil), Equals, http.StatusNotFound)
+	c.Check(request("POST", "/purge?queue=/topic/b", "admin", nil), Equals, http.StatusBadRequest)
+	c.Check(request("POST", "/purge?queue=/durable", "admin", nil), Equals, http.StatusBadRequest)
+
+	var conns []adminConnection
+	c.Assert(request("GET", "/connections", "admin", &conns), Equals, http.StatusOK)
+	c.Assert(conns, HasLen, 1)
+	conn := conns[0]
+	c.Check(conn.Login, Equals, "bob")
+	c.Check(conn.ClientId, Equals, "bob-client")
+	c.Check(conn.Session, Equals, bob.Session())
+	c.Check(conn.Version, Equals, string(bob.Version()))
+	c.Check(conn.Subscriptions, HasLen, 1)
+	c.Check(conn.Subscriptions[0].Destination, Equals, "/queue/a")
+	c.Check(conn.Subscriptions[0].Selector, Equals, "n > 0")
+
+	var conn2 adminConnection
+	path := "/connections/" + strconv.FormatUint(conn.Id, 10)
+	c.Assert(request("GET", path, "admin", &conn2), Equals, http.StatusOK)
+	c.Check(conn2.RemoteAddr, Equals, conn.RemoteAddr)
+	c.Check(request("GET", "/connections/0", "admin", nil), Equals, http.StatusNotFound)
+
+	var messages []adminMessage
+	c.Assert(request("GET", "/messages?queue=/queue/b&limit=2", "admin", &messages), Equals, http.StatusOK)
+	c.Assert(messages, HasLen, 2)
+	c.Check(messages[0].Body, Equals, "message 1")
+	c.Check(messages[1].Body, Equals, "message 2")
+	c.Check(messages[0].Headers, HasLen, 4)
+	for _, header := range messages[0].Headers {
+		if header[0] == "n" {
+			c.Check(header[1], Equals, "1")
+		}
+	}
+
+	// the subscription receives the moved message
+	var result map[string]int
+	c.Assert(request("POST", "/move?queue=/queue/b&to=/queue/a&limit=1", "admin", &result), Equals, http.StatusOK)
+	c.Check(result, DeepEquals, map[string]int{"moved": 1})
+	select {
+	case msg := <-sub.C:
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, "message 1")
+		c.Check(msg.Destination, Equals, "/queue/a")
+	case <-time.After(time.Second):
+		c.Fatal("timed out waiting for moved message")
+	}
+
+	result = nil
+	c.Assert(request("POST", "/purge?queue=/queue/b", "admin", &result), Equals, http.StatusOK)
+	c.Check(result, DeepEquals, map[string]int{"purged": 2})
+
+	var destinations []adminDestination
+	c.Assert(request("GET", "/destinations", "admin", &destinations), Equals, http.StatusOK)
+	c.Check(destinations, DeepEquals, []adminDestination{
+		{Name: "/queue/a", Type: "queue", Subscriptions: 1, Delivered: 1},
+		{Name: "/queue/b", Type: "queue", Enqueued: 3},
+	})
+
+	// closing the connection
+	c.Check(request("DELETE", path, "admin", nil), Equals, http.StatusNoContent)
+	for i := 0; ; i++ {
+		c.Assert(request("GET", "/connections", "admin", &conns), Equals, http.StatusOK)
+		if len(conns) == 0 {
+			break
+		}
+		if i == 100 {
+			c.Fatal("connection not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-stomp/stomp/server"
+	"golang.org/x/crypto/bcrypt"
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type AuthSuite struct {
+	dir string
+}
+
+var _ = Suite(&AuthSuite{})
+
+func (s *AuthSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *AuthSuite) writeFile(c *C, name string, lines ...string) string {
+	filename := filepath.Join(s.dir, name)
+	err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	c.Assert(err, IsNil)
+	return filename
+}
+
+func bcryptHash(c *C, passcode string) string {
+	// minimum cost, so that the tests run quickly
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.MinCost)
+	c.Assert(err, IsNil)
+	return string(hash)
+}
+
+func (s *AuthSuite) TestUsers(c *C) {
+	filename := s.writeFile(c, "users",
+		"# login passcode-hash",
+		"alice "+bcryptHash(c, "alice-secret"),
+		"",
+		"bob\t"+bcryptHash(c, "bob-secret"))
+
+	users, err := LoadUsers(filename)
+	c.Assert(err, IsNil)
+	c.Check(users.Filename(), Equals, filename)
+	c.Check(users.Authenticate("alice", "alice-secret"), Equals, true)
+	c.Check(users.Authenticate("alice", "bob-secret"), Equals, false)
+	c.Check(users.Authenticate("bob", "bob-secret"), Equals, true)
+	c.Check(users.Authenticate("carol", ""), Equals, false)
+
+	// reloaded with changes
+	s.writeFile(c, "users", "carol "+bcryptHash(c, "carol-secret"))
+	c.Assert(users.Reload(), IsNil)
+	c.Check(users.Authenticate("alice", "alice-secret"), Equals, false)
+	c.Check(users.Authenticate("carol", "carol-secret"), Equals, true)
+
+	// an invalid file leaves the users unchanged
+	s.writeFile(c, "users", "dave plaintext")
+	c.Check(users.Reload(), ErrorMatches, ".*users: line 1: passcode hash is not a bcrypt hash")
+	c.Check(users.Authenticate("carol", "carol-secret"), Equals, true)
+
+	s.writeFile(c, "users", "erin")
+	_, err = LoadUsers(filename)
+	c.Check(err, ErrorMatches, ".*users: line 1: expected login and passcode hash")
+	_, err = LoadUsers(filepath.Join(s.dir, "missing"))
+	c.Check(err, NotNil)
+}
+
+func (s *AuthSuite) TestHashPasscode(c *C) {
+	hash, err := HashPasscode("secret")
+	c.Assert(err, IsNil)
+	filename := s.writeFile(c, "users", "alice "+hash)
+	users, err := LoadUsers(filename)
+	c.Assert(err, IsNil)
+	c.Check(users.Authenticate("alice", "secret"), Equals, true)
+}
+
+func (s *AuthSuite) TestHtpasswd(c *C) {
+	// htpasswd -B creates hashes with the $2y$ prefix
+	bcrypt := "$2y$" + strings.TrimPrefix(bcryptHash(c, "bcrypt-secret"), "$2a$")
+	filename := s.writeFile(c, "htpasswd",
+		"bcrypt:"+bcrypt,
+		"md5:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0",
+		"empty:$apr1$ab$S8K6Sgp3W8c9Jb6LxgywZ.",
+		"sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=")
+
+	users, err := LoadHtpasswd(filename)
+	c.Assert(err, IsNil)
+	testCases := []struct {
+		login, passcode string
+		ok              bool
+	}{
+		{"bcrypt", "bcrypt-secret", true},
+		{"bcrypt", "secret", false},
+		{"md5", "secret", true},
+		{"md5", "Secret", false},
+		{"empty", "", true},
+		{"empty", "secret", false},
+		{"sha", "secret", true},
+		{"sha", "", false},
+		{"unknown", "secret", false},
+	}
+	for _, tc := range testCases {
+		c.Check(users.Authenticate(tc.login, tc.passcode), Equals, tc.ok,
+			Commentf("login=%s passcode=%s", tc.login, tc.passcode))
+	}
+
+	s.writeFile(c, "htpasswd", "crypt:rOVCjSyIkFPi.")
+	_, err = LoadHtpasswd(filename)
+	c.Check(err, ErrorMatches, ".*htpasswd: line 1: unsupported passcode hash for crypt")
+	s.writeFile(c, "htpasswd", "nocolon")
+	_, err = LoadHtpasswd(filename)
+	c.Check(err, ErrorMatches, ".*htpasswd: line 1: expected login:hash")
+}
+
+type testTLSAuthenticator struct{}
+
+func (testTLSAuthenticator) Authenticate(login, passcode string) bool {
+	return false
+}
+
+func (testTLSAuthenticator) AuthenticateTLS(login, passcode string, cert *x509.Certificate) bool {
+	return cert != nil && cert.Subject.CommonName == login
+}
+
+type testAuthenticator map[string]string
+
+func (a testAuthenticator) Authenticate(login, passcode string) bool {
+	p, ok := a[login]
+	return ok && p == passcode
+}
+
+func (s *AuthSuite) TestChain(c *C) {
+	var chain server.TLSAuthenticator = Chain{
+		testAuthenticator{"alice": "a"},
+		testTLSAuthenticator{},
+		testAuthenticator{"bob": "b"},
+	}
+
+	c.Check(chain.Authenticate("alice", "a"), Equals, true)
+	c.Check(chain.Authenticate("bob", "b"), Equals, true)
+	c.Check(chain.Authenticate("bob", "a"), Equals, false)
+
+	cert := &x509.Certificate{}
+	cert.Subject.CommonName = "carol"
+	c.Check(chain.AuthenticateTLS("carol", "", cert), Equals, true)
+	c.Check(chain.AuthenticateTLS("carol", "", nil), Equals, false)
+	c.Check(chain.AuthenticateTLS("alice", "a", cert), Equals, true)
+
+	c.Check(Chain{}.Authenticate("alice", "a"), Equals, false)
+}
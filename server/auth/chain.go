@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/x509"
+
+	"github.com/go-stomp/stomp/server"
+)
+
+// Chain is an Authenticator that tries each of its authenticators in
+// turn, and authenticates the client if any of them does. Chain
+// implements server.TLSAuthenticator, and passes the client certificate
+// to the authenticators in the chain that also implement it.
+type Chain []server.Authenticator
+
+// Authenticate returns true if any authenticator in the chain
+// authenticates the login and passcode.
+func (c Chain) Authenticate(login, passcode string) bool {
+	return c.AuthenticateTLS(login, passcode, nil)
+}
+
+// AuthenticateTLS returns true if any authenticator in the chain
+// authenticates the login, passcode and client certificate.
+func (c Chain) AuthenticateTLS(login, passcode string, cert *x509.Certificate) bool {
+	for _, auth := range c {
+		if tlsAuth, ok := auth.(server.TLSAuthenticator); ok {
+			if tlsAuth.AuthenticateTLS(login, passcode, cert) {
+				return true
+			}
+		} else if auth.Authenticate(login, passcode) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+/*
+Package auth provides implementations of server.Authenticator.
+*/
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Checks a passcode against a stored hash.
+type verifier func(passcode string) bool
+
+// Parses a file of users, returning a verifier for each login.
+type parser func(r io.Reader) (map[string]verifier, error)
+
+// File is an Authenticator that checks logins and passcodes against a
+// file of users with hashed passcodes. The file is read when the File
+// is created, and again when Reload is called, so that users can be
+// changed without restarting the server. It is safe to call
+// Authenticate and Reload concurrently.
+type File struct {
+	filename string
+	parse    parser
+
+	mutex sync.RWMutex
+	users map[string]verifier
+}
+
+// LoadUsers reads a users file, with one user per line. Each line
+// contains the login and the bcrypt hash of the passcode, separated by
+// white space. Blank lines, and lines starting with '#', are ignored.
+// Use HashPasscode to create the hash.
+func LoadUsers(filename string) (*File, error) {
+	return load(filename, parseUsers)
+}
+
+// LoadHtpasswd reads a password file in the format created by the
+// Apache htpasswd utility, with one "login:hash" entry per line.
+// Passcodes hashed with bcrypt (htpasswd -B), MD5 (the default, or
+// htpasswd -m) and SHA-1 (htpasswd -s) are supported. Passcodes hashed
+// with crypt(3) are not supported.
+func LoadHtpasswd(filename string) (*File, error) {
+	return load(filename, parseHtpasswd)
+}
+
+func load(filename string, parse parser) (*File, error) {
+	f := &File{filename: filename, parse: parse}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Filename returns the name of the file containing the users.
+func (f *File) Filename() string {
+	return f.filename
+}
+
+// Reload reads the file again. If the file cannot be read, or is
+// invalid, an error is returned and the users are not changed.
+func (f *File) Reload() error {
+	file, err := os.Open(f.filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	users, err := f.parse(file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.filename, err.Error())
+	}
+	f.mutex.Lock()
+	f.users = users
+	f.mutex.Unlock()
+	return nil
+}
+
+// Authenticate returns true if the file contains the login, and the
+// passcode matches its hash.
+func (f *File) Authenticate(login, passcode string) bool {
+	f.mutex.RLock()
+	verify, ok := f.users[login]
+	f.mutex.RUnlock()
+	if !ok {
+		// take about as long as checking a bcrypt hash,
+		// so that valid logins cannot be discovered by timing
+		dummyVerifier()(passcode)
+		return false
+	}
+	return verify(passcode)
+}
+
+// HashPasscode returns the bcrypt hash of a passcode, for use in a
+// users file (see LoadUsers) or htpasswd file.
+func HashPasscode(passcode string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+var dummyVerifier = sync.OnceValue(func() verifier {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy passcode"), bcrypt.DefaultCost)
+	return bcryptVerifier(hash)
+})
+
+func bcryptVerifier(hash []byte) verifier {
+	return func(passcode string) bool {
+		return bcrypt.CompareHashAndPassword(hash, []byte(passcode)) == nil
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+// Calls fn with each line of r, and its line number, ignoring blank
+// lines and comments.
+func scanLines(r io.Reader, fn func(line string, lineNumber int) error) error {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line, lineNumber); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func parseUsers(r io.Reader) (map[string]verifier, error) {
+	users := make(map[string]verifier)
+	err := scanLines(r, func(line string, lineNumber int) error {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: expected login and passcode hash", lineNumber)
+		}
+		if !isBcrypt(fields[1]) {
+			return fmt.Errorf("line %d: passcode hash is not a bcrypt hash", lineNumber)
+		}
+		users[fields[0]] = bcryptVerifier([]byte(fields[1]))
+		return nil
+	})
+	return users, err
+}
+
+func parseHtpasswd(r io.Reader) (map[string]verifier, error) {
+	users := make(map[string]verifier)
+	err := scanLines(r, func(line string, lineNumber int) error {
+		login, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("line %d: expected login:hash", lineNumber)
+		}
+		switch {
+		case isBcrypt(hash):
+			users[login] = bcryptVerifier([]byte(hash))
+		case strings.HasPrefix(hash, "{SHA}"):
+			users[login] = shaVerifier(strings.TrimPrefix(hash, "{SHA}"))
+		case strings.HasPrefix(hash, apr1Magic):
+			users[login] = apr1Verifier(hash)
+		default:
+			return fmt.Errorf("line %d: unsupported passcode hash for %s", lineNumber, login)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func shaVerifier(hash string) verifier {
+	return func(passcode string) bool {
+		sum := sha1.Sum([]byte(passcode))
+		computed := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	}
+}
+
+func apr1Verifier(hash string) verifier {
+	salt := strings.TrimPrefix(hash, apr1Magic)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	return func(passcode string) bool {
+		computed := apr1(passcode, salt)
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+	}
+}
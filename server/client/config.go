@@ -1,15 +1,31 @@
 package client
 
 import (
+	"crypto/x509"
+	"log/slog"
 	"time"
+
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/metrics"
 )
 
 // Contains information the client package needs from the
 // rest of the STOMP server code.
 type Config interface {
 	// Method to authenticate a login and associated passcode.
-	// Returns true if login/passcode is valid, false otherwise.
-	Authenticate(login, passcode string) bool
+	// If the client connected using TLS and presented a certificate
+	// that has been verified, cert contains the client certificate,
+	// otherwise it is nil. Returns true if login/passcode is valid,
+	// false otherwise.
+	Authenticate(login, passcode string, cert *x509.Certificate) bool
+
+	// Methods to authorize an authenticated client to send messages to
+	// a destination, and to subscribe to a destination. The principal
+	// is the client's login, or the common name of its certificate if
+	// it logged in with a TLS client certificate and no login. Returns
+	// true if the operation is allowed, false otherwise.
+	AuthorizeSend(principal, destination string) bool
+	AuthorizeSubscribe(principal, destination string) bool
 
 	// Default duration for read/write heart-beat values. If this
 	// returns zero, no heart-beat will take place. If this value is
@@ -17,4 +33,23 @@ type Config interface {
 	// 11 days, but less than 12 days), then it is truncated to the
 	// maximum permitted values.
 	HeartBeat() time.Duration
+
+	// Default maximum number of queue frames sent to a subscription
+	// that have not been acknowledged by the client. Clients can
+	// override this with the prefetch-count header in the SUBSCRIBE
+	// frame.
+	Prefetch() int
+
+	// Limits on the size of frames received from the client. A client
+	// that sends a frame exceeding a limit is sent an ERROR frame and
+	// disconnected.
+	FrameLimits() frame.Limits
+
+	// Logger for events on client connections. Each connection adds
+	// its connection id and remote address to the log records.
+	Logger() *slog.Logger
+
+	// Metrics for counting connections, and the frames sent and
+	// received by each connection.
+	Metrics() *metrics.Metrics
 }
@@ -1,15 +1,23 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-stomp/stomp"
 	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/selector"
 )
 
 // Maximum number of pending frames allowed to a client.
@@ -22,24 +30,37 @@ const maxPendingWrites = 16
 // go routine starts blocking.
 const maxPendingReads = 16
 
+// Used to allocate a unique id to each connection.
+var lastConnId uint64
+
 // Represents a connection with the STOMP client.
 type Conn struct {
+	id             uint64 // Unique connection id, used for logging
 	config         Config
+	log            *slog.Logger                        // Logs events on this connection
 	rw             net.Conn                            // Network connection to client
 	writer         *frame.Writer                       // Writes STOMP frames directly to the network connection
 	requestChannel chan Request                        // For sending requests to upper layer
-	subChannel     chan *Subscription                  // Receives subscription messages for client
+	queueReady     chan struct{}                       // Signalled when queue messages are pending
+	queueMutex     sync.Mutex                          // Protects queueFrames
+	queueFrames    []queueFrame                        // Queue messages pending for client
 	writeChannel   chan *frame.Frame                   // Receives unacknowledged (topic) messages for client
 	readChannel    chan *frame.Frame                   // Receives frames from the client
+	readErr        error                               // Frame limit exceeded, set before readChannel is closed
 	stateFunc      func(c *Conn, f *frame.Frame) error // State processing function
 	writeTimeout   time.Duration                       // Heart beat write timeout
 	version        stomp.Version                       // Negotiated STOMP protocol version
 	closed         bool                                // Is the connection closed
 	txStore        *txStore                            // Stores transactions in progress
 	lastMsgId      uint64                              // last message-id value
-	subList        *SubscriptionList                   // List of subscriptions requiring acknowledgement
+	clientId       string                              // client-id header from CONNECT frame
+	principal      string                              // authenticated identity, for authorization
+	unacked        *unackedList                        // Queue messages requiring acknowledgement
 	subs           map[string]*Subscription            // All subscriptions, keyed by id
 	validator      stomp.Validator                     // For validating STOMP frames
+	infoMutex      sync.Mutex                          // Protects info, and changes to subs
+	info           ConnInfo                            // Connection details, for monitoring
+	done           chan struct{}                       // Closed once the connection has been cleaned up
 }
 
 // Creates a new client connection. The config parameter contains
@@ -48,22 +69,91 @@ type Conn struct {
 // the client. All client requests are sent via the ch channel to the
 // upper layer.
 func NewConn(config Config, rw net.Conn, ch chan Request) *Conn {
+	id := atomic.AddUint64(&lastConnId, 1)
+	config.Metrics().Connections.Inc()
+	config.Metrics().ConnectionsTotal.Inc()
 	c := &Conn{
+		id:             id,
 		config:         config,
+		log:            config.Logger().With("conn", id, "remote", rw.RemoteAddr().String()),
 		rw:             rw,
 		requestChannel: ch,
-		subChannel:     make(chan *Subscription, maxPendingWrites),
+		queueReady:     make(chan struct{}, 1),
 		writeChannel:   make(chan *frame.Frame, maxPendingWrites),
 		readChannel:    make(chan *frame.Frame, maxPendingReads),
 		txStore:        &txStore{},
-		subList:        NewSubscriptionList(),
+		unacked:        newUnackedList(),
 		subs:           make(map[string]*Subscription),
+		done:           make(chan struct{}),
+		info: ConnInfo{
+			Id:          id,
+			RemoteAddr:  rw.RemoteAddr().String(),
+			ConnectedAt: time.Now(),
+		},
 	}
 	go c.readLoop()
 	go c.processLoop()
 	return c
 }
 
+// Id returns the connection id, which uniquely identifies
+// the connection within the process.
+func (c *Conn) Id() uint64 {
+	return c.id
+}
+
+// ConnInfo describes a client connection, for monitoring. The details
+// from the CONNECT frame are blank until the client has connected.
+type ConnInfo struct {
+	Id             uint64
+	RemoteAddr     string
+	ConnectedAt    time.Time // when the network connection was accepted
+	Login          string
+	Principal      string // login, or common name of the client certificate
+	ClientId       string
+	Session        string // session header of the CONNECTED frame
+	Version        stomp.Version
+	ReadHeartBeat  time.Duration // zero if the client does not send heart-beats
+	WriteHeartBeat time.Duration // zero if the server does not send heart-beats
+	Subscriptions  []SubscriptionInfo
+}
+
+// SubscriptionInfo describes a subscription, for monitoring.
+type SubscriptionInfo struct {
+	Id          string
+	Destination string
+	Ack         string
+	Prefetch    int
+	Selector    string // blank if the subscription has no selector
+	Queue       string // queue name for a durable subscription, otherwise blank
+}
+
+// Info returns details of the connection and its subscriptions, sorted
+// by subscription id. It is safe to call from any go-routine.
+func (c *Conn) Info() ConnInfo {
+	c.infoMutex.Lock()
+	defer c.infoMutex.Unlock()
+	info := c.info
+	info.Subscriptions = make([]SubscriptionInfo, 0, len(c.subs))
+	for _, sub := range c.subs {
+		si := SubscriptionInfo{
+			Id:          sub.id,
+			Destination: sub.dest,
+			Ack:         sub.ack,
+			Prefetch:    sub.prefetch,
+			Queue:       sub.durableQueue,
+		}
+		if sub.selector != nil {
+			si.Selector = sub.selector.String()
+		}
+		info.Subscriptions = append(info.Subscriptions, si)
+	}
+	sort.Slice(info.Subscriptions, func(i, j int) bool {
+		return info.Subscriptions[i].Id < info.Subscriptions[j].Id
+	})
+	return info
+}
+
 // Write a frame to the connection without requiring
 // any acknowledgement.
 func (c *Conn) Send(f *frame.Frame) {
@@ -77,8 +167,31 @@ func (c *Conn) Send(f *frame.Frame) {
 // message has been transmitted. The message header
 // will be based on the contents of the err parameter.
 func (c *Conn) SendError(err error) {
+	c.SendErrorContext(context.Background(), err)
+}
+
+// SendErrorContext is like SendError, but gives up if ctx is done
+// before the ERROR frame can be placed on the write channel. Returns
+// ctx.Err() if it gives up. Does not block if the connection has
+// already been closed.
+func (c *Conn) SendErrorContext(ctx context.Context, err error) error {
 	f := frame.New(frame.ERROR, frame.Message, err.Error())
-	c.Send(f) // will close after successful send
+	select {
+	case c.writeChannel <- f:
+		// will close after successful send
+		return nil
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close the network connection to the client immediately. The
+// connection is cleaned up in the same way as if the client had
+// closed the connection: unacknowledged messages are requeued.
+func (c *Conn) Close() error {
+	return c.rw.Close()
 }
 
 // Send an ERROR frame to the client and immediately. The error
@@ -105,7 +218,18 @@ func (c *Conn) sendErrorImmediately(err error, f *frame.Frame) {
 // Sends a STOMP frame to the client immediately, does not push onto the
 // write channel to be processed in turn.
 func (c *Conn) sendImmediately(f *frame.Frame) error {
-	return c.writer.Write(f)
+	return c.write(f)
+}
+
+// Writes a frame to the client, or a heart-beat if f is nil.
+func (c *Conn) write(f *frame.Frame) error {
+	if err := c.writer.Write(f); err != nil {
+		return err
+	}
+	if f != nil {
+		c.config.Metrics().FramesSent.With(f.Command).Inc()
+	}
+	return nil
 }
 
 // Go routine for reading bytes from a client and assembling into
@@ -115,6 +239,7 @@ func (c *Conn) sendImmediately(f *frame.Frame) error {
 // this connection on the one go-routine and avoids race conditions.
 func (c *Conn) readLoop() {
 	reader := frame.NewReader(c.rw)
+	reader.SetLimits(c.config.FrameLimits())
 	expectingConnect := true
 	readTimeout := time.Duration(0)
 	for {
@@ -126,10 +251,15 @@ func (c *Conn) readLoop() {
 		}
 		f, err := reader.Read()
 		if err != nil {
-			if err == io.EOF {
-				log.Println("connection closed:", c.rw.RemoteAddr())
-			} else {
-				log.Println("read failed:", err, ":", c.rw.RemoteAddr())
+			switch err {
+			case io.EOF:
+				c.log.Info("connection closed")
+			case frame.ErrTooManyHeaders, frame.ErrHeaderTooLong, frame.ErrBodyTooLarge:
+				// the processing loop sends an ERROR frame
+				c.log.Warn("frame limit exceeded", "error", err)
+				c.readErr = err
+			default:
+				c.log.Warn("read failed", "error", err)
 			}
 
 			// Close the read channel so that the processing loop will
@@ -172,6 +302,12 @@ func (c *Conn) readLoop() {
 
 				expectingConnect = false
 			}
+
+			// Header entries are unescaped according to the version
+			// negotiated, which the processing loop works out again.
+			if version, err := determineVersion(f); err == nil {
+				reader.SetVersion(string(version))
+			}
 		}
 
 		// Add the frame to the read channel. Note that this will block
@@ -217,7 +353,7 @@ func (c *Conn) processLoop() {
 			c.allocateMessageId(f, nil)
 
 			// write the frame to the client
-			err := c.writer.Write(f)
+			err := c.write(f)
 			if err != nil {
 				// if there is an error writing to
 				// the client, there is not much
@@ -237,16 +373,21 @@ func (c *Conn) processLoop() {
 			if !ok {
 				// read channel has been closed, so
 				// exit go-routine (after cleaning up)
+				if c.readErr != nil {
+					c.sendErrorImmediately(c.readErr, nil)
+				}
 				return
 			}
 
 			// Just received a frame from the client.
+			c.config.Metrics().FramesReceived.With(f.Command).Inc()
+
 			// Validate the frame, checking for mandatory
 			// headers and prohibited headers.
 			if c.validator != nil {
 				err := c.validator.Validate(f)
 				if err != nil {
-					log.Println("validation failed for", f.Command, "frame", err)
+					c.log.Warn("validation failed", "command", f.Command, "error", err)
 					c.sendErrorImmediately(err, f)
 					return
 				}
@@ -260,11 +401,10 @@ func (c *Conn) processLoop() {
 				return
 			}
 
-		case sub, ok := <-c.subChannel:
+		case <-c.queueReady:
+			qf, ok := c.nextQueueFrame()
 			if !ok {
-				// subscription channel has been closed,
-				// so exit go-routine (after cleaning up)
-				return
+				continue
 			}
 
 			// have a frame to the client which requires
@@ -279,39 +419,41 @@ func (c *Conn) processLoop() {
 			// there is the possibility that the subscription
 			// has been unsubscribed just prior to receiving
 			// this, so we check
+			sub := qf.sub
 			if _, ok = c.subs[sub.id]; ok {
 				// allocate a message-id, note that the
 				// subscription id has already been set
-				c.allocateMessageId(sub.frame, sub)
+				msgId := c.allocateMessageId(qf.frame, sub)
+				countDelivery(qf.frame)
 
 				// write the frame to the client
-				err := c.writer.Write(sub.frame)
+				err := c.write(qf.frame)
 				if err != nil {
 					// if there is an error writing to
 					// the client, there is not much
 					// point trying to send an ERROR frame,
 					// so just exit go-routine (after cleaning up)
+					c.requestChannel <- Request{Op: RequeueOp, Sub: qf.sub, Frame: qf.frame}
 					return
 				}
 
 				if sub.ack == frame.AckAuto {
 					// subscription does not require acknowledgement,
-					// so send the subscription back the upper layer
-					// straight away
-					sub.frame = nil
-					c.requestChannel <- Request{Op: SubscribeOp, Sub: sub}
+					// so let the upper layer know straight away that
+					// the subscription is ready for another frame
+					c.requestChannel <- Request{Op: ReadyOp, Sub: sub, Frame: qf.frame}
 				} else {
-					// subscription requires acknowledgement
-					c.subList.Add(sub)
+					// frame requires acknowledgement
+					c.unacked.Add(sub, msgId, qf.frame)
 				}
 			} else {
 				// Subscription no longer exists, requeue
-				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
+				c.requestChannel <- Request{Op: RequeueOp, Sub: qf.sub, Frame: qf.frame}
 			}
 
 		case _ = <-timerChannel:
 			// write a heart-beat
-			err := c.writer.Write(nil)
+			err := c.write(nil)
 			if err != nil {
 				return
 			}
@@ -319,6 +461,21 @@ func (c *Conn) processLoop() {
 	}
 }
 
+// Unsubscribes every subscription from the upper layer, and
+// clears out the map of subscriptions.
+func (c *Conn) unsubscribeAll() {
+	for _, sub := range c.subs {
+		// Note that we only really need to send a request if the
+		// subscription does not have a frame, but for simplicity
+		// all subscriptions are unsubscribed from the upper layer.
+		c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
+	}
+
+	c.infoMutex.Lock()
+	c.subs = make(map[string]*Subscription)
+	c.infoMutex.Unlock()
+}
+
 // Called when the connection is closing, and takes care of
 // unsubscribing all subscriptions with the upper layer, and
 // re-queueing all unacknowledged messages to the upper layer.
@@ -332,35 +489,33 @@ func (c *Conn) cleanupConn() {
 	// This should be done before cleaning up the subscription
 	// channel. If we requeued messages before doing this,
 	// we might end up getting them back again.
-	for _, sub := range c.subs {
-		// Note that we only really need to send a request if the
-		// subscription does not have a frame, but for simplicity
-		// all subscriptions are unsubscribed from the upper layer.
-		c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
-	}
+	c.unsubscribeAll()
 
-	// Clear out the map of subscriptions
-	c.subs = nil
-
-	// Every subscription requiring acknowledgement has a frame
-	// that needs to be requeued in the upper layer
-	for sub := c.subList.Get(); sub != nil; sub = c.subList.Get() {
-		c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
+	// Every frame that has not been acknowledged
+	// needs to be requeued in the upper layer
+	for m := c.unacked.Get(); m != nil; m = c.unacked.Get() {
+		c.requestChannel <- Request{Op: RequeueOp, Sub: m.sub, Frame: m.frame}
 	}
 
-	// empty the subscription and write queue
+	// empty the pending queue frames and write queue
 	c.discardWriteChannelFrames()
-	c.cleanupSubChannel()
+	for _, r := range c.UnsentQueueFrames() {
+		c.requestChannel <- r
+	}
 
-	// Tell the upper layer we are now disconnected
+	// Tell the upper layer we are now disconnected. Any queue
+	// frames sent to the connection before the upper layer
+	// processed the unsubscribe requests are requeued by the
+	// upper layer using UnsentQueueFrames.
 	c.requestChannel <- Request{Op: DisconnectedOp, Conn: c}
 
-	// empty the subscription and write queue one more time
+	// empty the write queue one more time
 	c.discardWriteChannelFrames()
-	c.cleanupSubChannel()
 
 	// Should not hurt to call this if it is already closed?
 	c.rw.Close()
+	c.config.Metrics().Connections.Dec()
+	close(c.done)
 }
 
 // Discard anything on the write channel. These frames
@@ -380,26 +535,58 @@ func (c *Conn) discardWriteChannelFrames() {
 	}
 }
 
-func (c *Conn) cleanupSubChannel() {
-	// Read the subscription channel until it is empty.
-	// Each frame should be requeued to the upper layer.
-	for finished := false; !finished; {
-		select {
-		case sub, ok := <-c.subChannel:
-			if !ok {
-				finished = true
-			} else {
-				c.requestChannel <- Request{Op: RequeueOp, Frame: sub.frame}
-			}
+// Add a queue frame to the frames pending for the client. Never blocks,
+// as the number of pending frames is limited by the prefetch count of
+// each subscription.
+func (c *Conn) addQueueFrame(qf queueFrame) {
+	c.queueMutex.Lock()
+	c.queueFrames = append(c.queueFrames, qf)
+	c.queueMutex.Unlock()
+	c.signalQueueReady()
+}
 
-		default:
-			finished = true
-		}
+// Remove the first pending queue frame. Returns false if there are
+// no queue frames pending.
+func (c *Conn) nextQueueFrame() (queueFrame, bool) {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	if len(c.queueFrames) == 0 {
+		return queueFrame{}, false
+	}
+	qf := c.queueFrames[0]
+	c.queueFrames[0] = queueFrame{}
+	c.queueFrames = c.queueFrames[1:]
+	if len(c.queueFrames) > 0 {
+		c.signalQueueReady()
+	}
+	return qf, true
+}
+
+func (c *Conn) signalQueueReady() {
+	select {
+	case c.queueReady <- struct{}{}:
+	default:
 	}
 }
 
+// UnsentQueueFrames removes the queue frames sent to the connection's
+// subscriptions that have not been written to the client, and returns
+// a RequeueOp request for each of them. Called by the upper layer when
+// the connection has disconnected, so that the frames can be requeued.
+func (c *Conn) UnsentQueueFrames() []Request {
+	c.queueMutex.Lock()
+	defer c.queueMutex.Unlock()
+	var requests []Request
+	for _, qf := range c.queueFrames {
+		requests = append(requests, Request{Op: RequeueOp, Sub: qf.sub, Frame: qf.frame})
+	}
+	c.queueFrames = nil
+	return requests
+}
+
 // Send a frame to the client, allocating necessary headers prior.
-func (c *Conn) allocateMessageId(f *frame.Frame, sub *Subscription) {
+// Returns the message-id allocated, or zero if f is not a MESSAGE.
+func (c *Conn) allocateMessageId(f *frame.Frame, sub *Subscription) uint64 {
 	if f.Command == frame.MESSAGE {
 		// allocate the value of message-id for this frame
 		c.lastMsgId++
@@ -413,6 +600,71 @@ func (c *Conn) allocateMessageId(f *frame.Frame, sub *Subscription) {
 		} else {
 			f.Header.Set(frame.Ack, messageId)
 		}
+		return c.lastMsgId
+	}
+	return 0
+}
+
+// Checks that the client is authorized to send a SEND frame, or to
+// subscribe with a SUBSCRIBE frame. Other frames are always authorized.
+func (c *Conn) authorize(f *frame.Frame) error {
+	dest := f.Header.Get(frame.Destination)
+	var ok bool
+	switch f.Command {
+	case frame.SEND:
+		ok = c.config.AuthorizeSend(c.principal, dest)
+	case frame.SUBSCRIBE:
+		ok = c.config.AuthorizeSubscribe(c.principal, dest)
+	default:
+		return nil
+	}
+	if !ok {
+		c.log.Warn("not authorized",
+			"command", f.Command,
+			"destination", dest)
+		return notAuthorized(dest)
+	}
+	return nil
+}
+
+// Returns the name of the queue that stores messages for the durable
+// subscription named in a SUBSCRIBE or UNSUBSCRIBE frame, or a blank
+// string if the frame does not name a durable subscription. The ActiveMQ
+// header name is also accepted. Queue destinations start with "/queue",
+// so the queue name cannot clash with a queue that clients send to. The
+// client id and the durable subscription name cannot contain "/", so that
+// each pair of them has a different queue name.
+func (c *Conn) durableQueue(f *frame.Frame) (string, error) {
+	name := f.Header.Get(frame.DurableSubscriptionName)
+	if name == "" {
+		name = f.Header.Get("activemq.subscriptionName")
+	}
+	switch {
+	case name == "":
+		return "", nil
+	case c.clientId == "":
+		return "", durableWithoutClientId
+	case strings.Contains(c.clientId, "/"):
+		return "", invalidDurableClientId
+	case strings.Contains(name, "/"):
+		return "", invalidDurableName
+	}
+	return "/durable/" + c.clientId + "/" + name, nil
+}
+
+// Increment the delivery-count header of a queue frame that is about
+// to be sent to the client. Frames that have been delivered before
+// also have a redelivered header.
+func countDelivery(f *frame.Frame) {
+	count := 1
+	if value, ok := f.Header.Contains(frame.DeliveryCount); ok {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			count = n + 1
+		}
+	}
+	f.Header.Set(frame.DeliveryCount, strconv.Itoa(count))
+	if count > 1 {
+		f.Header.Set(frame.Redelivered, "true")
 	}
 }
 
@@ -455,6 +707,24 @@ func connected(c *Conn, f *frame.Frame) error {
 	return unknownCommand
 }
 
+// Returns the verified client certificate if the client has connected
+// using TLS, or nil if there is no verified client certificate.
+func (c *Conn) peerCertificate() *x509.Certificate {
+	// Implemented by *tls.Conn, and by connections that use
+	// TLS for their transport, such as WebSocket connections.
+	if tlsConn, ok := c.rw.(interface {
+		ConnectionState() tls.ConnectionState
+	}); ok {
+		// The handshake has completed, as the CONNECT
+		// frame has been read from the connection.
+		state := tlsConn.ConnectionState()
+		if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+			return state.VerifiedChains[0][0]
+		}
+	}
+	return nil
+}
+
 func (c *Conn) handleConnect(f *frame.Frame) error {
 	var err error
 
@@ -468,30 +738,32 @@ func (c *Conn) handleConnect(f *frame.Frame) error {
 	// authenticator function.
 	login, _ := f.Header.Contains(frame.Login)
 	passcode, _ := f.Header.Contains(frame.Passcode)
-	if !c.config.Authenticate(login, passcode) {
+	cert := c.peerCertificate()
+	if !c.config.Authenticate(login, passcode, cert) {
 		// sleep to slow down a rogue client a little bit
-		log.Println("authentication failed")
+		c.log.Warn("authentication failed", "login", login)
 		time.Sleep(time.Second)
 		return authenticationFailed
 	}
 
 	c.version, err = determineVersion(f)
 	if err != nil {
-		log.Println("protocol version negotiation failed")
+		c.log.Warn("protocol version negotiation failed", "error", err)
 		return err
 	}
 	c.validator = stomp.NewValidator(c.version)
+	c.writer.SetVersion(string(c.version))
 
 	if c.version == stomp.V10 {
 		// don't want to handle V1.0 at the moment
 		// TODO: get working for V1.0
-		log.Println("unsupported version", c.version)
+		c.log.Warn("unsupported version", "version", c.version)
 		return unsupportedVersion
 	}
 
 	cx, cy, err := getHeartBeat(f)
 	if err != nil {
-		log.Println("invalid heart-beat")
+		c.log.Warn("invalid heart-beat", "error", err)
 		return err
 	}
 
@@ -512,13 +784,32 @@ func (c *Conn) handleConnect(f *frame.Frame) error {
 	// go-routine
 	c.writeTimeout = time.Duration(cy) * time.Millisecond
 
+	session := strconv.FormatUint(c.id, 10)
 	response := frame.New(frame.CONNECTED,
 		frame.Version, string(c.version),
 		frame.Server, "stompd/x.y.z", // TODO: get version
+		frame.Session, session,
 		frame.HeartBeat, fmt.Sprintf("%d,%d", cy, cx))
 
 	c.sendImmediately(response)
 	c.stateFunc = connected
+	c.clientId, _ = f.Header.Contains(frame.ClientId)
+	c.principal = login
+	if c.principal == "" && cert != nil {
+		c.principal = cert.Subject.CommonName
+	}
+	c.log = c.log.With("login", login)
+	c.log.Info("connected", "version", c.version)
+
+	c.infoMutex.Lock()
+	c.info.Login = login
+	c.info.Principal = c.principal
+	c.info.ClientId = c.clientId
+	c.info.Session = session
+	c.info.Version = c.version
+	c.info.ReadHeartBeat = time.Duration(cx) * time.Millisecond
+	c.info.WriteHeartBeat = c.writeTimeout
+	c.infoMutex.Unlock()
 
 	// tell the upper layer we are connected
 	c.requestChannel <- Request{Op: ConnectedOp, Conn: c}
@@ -549,6 +840,10 @@ func (c *Conn) handleDisconnect(f *frame.Frame) error {
 	// of a RECEIPT frame if the client has requested one.
 	// Ignore the error condition if we cannot send a RECEIPT frame,
 	// as the connection is about to close anyway.
+	// Subscriptions are unsubscribed first, so that the upper layer
+	// has removed them before the client receives the RECEIPT, and can
+	// connect again with the same durable subscriptions.
+	c.unsubscribeAll()
 	_ = c.sendReceiptImmediately(f)
 	return nil
 }
@@ -572,8 +867,15 @@ func (c *Conn) handleCommit(f *frame.Frame) error {
 	// the frame should already have been validated for the
 	// transaction header, but we check again here.
 	if transaction, ok := f.Header.Contains(frame.Transaction); ok {
+		// Check that all the frames in the transaction are authorized
+		// first, so that none of them are processed if one is not.
+		err := c.txStore.Check(transaction, c.authorize)
+		if err != nil {
+			return err
+		}
+
 		// Send a receipt and remove the header
-		err := c.sendReceiptImmediately(f)
+		err = c.sendReceiptImmediately(f)
 		if err != nil {
 			return err
 		}
@@ -617,17 +919,48 @@ func (c *Conn) handleSubscribe(f *frame.Frame) error {
 		ack = frame.AckAuto
 	}
 
+	prefetch := c.config.Prefetch()
+	if value, ok := f.Header.Contains(frame.PrefetchCount); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return invalidPrefetchCount
+		}
+		prefetch = n
+	}
+
+	var sel *selector.Selector
+	if expr, ok := f.Header.Contains(frame.Selector); ok {
+		var err error
+		sel, err = selector.Parse(expr)
+		if err != nil {
+			return invalidSelector(err)
+		}
+	}
+
 	sub, ok := c.subs[id]
 	if ok {
 		return subscriptionExists
 	}
 
-	sub = newSubscription(c, dest, id, ack)
+	if err := c.authorize(f); err != nil {
+		return err
+	}
+
+	durableQueue, err := c.durableQueue(f)
+	if err != nil {
+		return err
+	}
+
+	sub = newSubscription(c, dest, id, ack, prefetch)
+	sub.selector = sel
+	sub.durableQueue = durableQueue
+	c.infoMutex.Lock()
 	c.subs[id] = sub
+	c.infoMutex.Unlock()
 
 	// send information about new subscription to upper layer
 	c.requestChannel <- Request{Op: SubscribeOp, Sub: sub}
-	return nil
+	return c.sendReceiptImmediately(f)
 }
 
 func (c *Conn) handleUnsubscribe(f *frame.Frame) error {
@@ -636,31 +969,43 @@ func (c *Conn) handleUnsubscribe(f *frame.Frame) error {
 		return missingHeader(frame.Id)
 	}
 
+	durableQueue, err := c.durableQueue(f)
+	if err != nil {
+		return err
+	}
+	durable := durableQueue != ""
+
 	sub, ok := c.subs[id]
-	if !ok {
+	if !ok && !durable {
 		return subscriptionNotFound
 	}
 
-	// remove the subscription
-	delete(c.subs, id)
+	if ok {
+		// remove the subscription
+		c.infoMutex.Lock()
+		delete(c.subs, id)
+		c.infoMutex.Unlock()
+	}
+
+	if durable {
+		// Remove the durable subscription, along with any stored
+		// messages. This is possible when the client is not
+		// subscribed, so a separate subscription is used.
+		if ok {
+			c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
+		}
+		sub = newSubscription(c, "", id, frame.AckAuto, 0)
+		sub.durableQueue = durableQueue
+		sub.removeDurable = true
+	}
 
 	// tell the upper layer of the unsubscribe
 	c.requestChannel <- Request{Op: UnsubscribeOp, Sub: sub}
-	return nil
+	return c.sendReceiptImmediately(f)
 }
 
 func (c *Conn) handleAck(f *frame.Frame) error {
-	var err error
-	var msgId string
-
-	if ack, ok := f.Header.Contains(frame.Ack); ok {
-		msgId = ack
-	} else if msgId, ok = f.Header.Contains(frame.MessageId); !ok {
-		return missingHeader(frame.MessageId)
-	}
-
-	// expecting message id to be a uint64
-	msgId64, err := strconv.ParseUint(msgId, 10, 64)
+	msgId64, err := c.ackMessageId(f)
 	if err != nil {
 		return err
 	}
@@ -678,14 +1023,11 @@ func (c *Conn) handleAck(f *frame.Frame) error {
 			return err
 		}
 	} else {
-		// handle any subscriptions that are acknowledged by this msg
-		c.subList.Ack(msgId64, func(s *Subscription) {
-			// remove frame from the subscription, it has been delivered
-			s.frame = nil
-
+		// handle any messages that are acknowledged by this msg
+		c.unacked.Ack(msgId64, func(m *unackedMessage) {
 			// let the upper layer know that this subscription
 			// is ready for another frame
-			c.requestChannel <- Request{Op: SubscribeOp, Sub: s}
+			c.requestChannel <- Request{Op: ReadyOp, Sub: m.sub, Frame: m.frame}
 		})
 	}
 
@@ -693,17 +1035,7 @@ func (c *Conn) handleAck(f *frame.Frame) error {
 }
 
 func (c *Conn) handleNack(f *frame.Frame) error {
-	var err error
-	var msgId string
-
-	if ack, ok := f.Header.Contains(frame.Ack); ok {
-		msgId = ack
-	} else if msgId, ok = f.Header.Contains(frame.MessageId); !ok {
-		return missingHeader(frame.MessageId)
-	}
-
-	// expecting message id to be a uint64
-	msgId64, err := strconv.ParseUint(msgId, 10, 64)
+	msgId64, err := c.ackMessageId(f)
 	if err != nil {
 		return err
 	}
@@ -721,33 +1053,55 @@ func (c *Conn) handleNack(f *frame.Frame) error {
 			return err
 		}
 	} else {
-		// handle any subscriptions that are acknowledged by this msg
-		c.subList.Nack(msgId64, func(s *Subscription) {
+		// handle the message that is rejected by this msg
+		c.unacked.Nack(msgId64, func(m *unackedMessage) {
 			// send frame back to upper layer for requeue
-			c.requestChannel <- Request{Op: RequeueOp, Frame: s.frame}
-
-			// remove frame from the subscription, it has been requeued
-			s.frame = nil
+			c.requestChannel <- Request{Op: RequeueOp, Sub: m.sub, Frame: m.frame}
 
 			// let the upper layer know that this subscription
 			// is ready for another frame
-			c.requestChannel <- Request{Op: SubscribeOp, Sub: s}
+			c.requestChannel <- Request{Op: ReadyOp, Sub: m.sub}
 		})
 	}
 	return nil
 }
 
+// Returns the message-id (or ack) value identifying the message
+// acknowledged by an ACK or NACK frame. STOMP 1.2 uses the id header,
+// earlier versions use the message-id header.
+func (c *Conn) ackMessageId(f *frame.Frame) (uint64, error) {
+	var msgId string
+	if id, ok := f.Header.Contains(frame.Id); ok && c.version == stomp.V12 {
+		msgId = id
+	} else if ack, ok := f.Header.Contains(frame.Ack); ok {
+		msgId = ack
+	} else if msgId, ok = f.Header.Contains(frame.MessageId); !ok {
+		return 0, missingHeader(frame.MessageId)
+	}
+
+	// expecting message id to be a uint64
+	return strconv.ParseUint(msgId, 10, 64)
+}
+
 // Handle a SEND frame received from the client. Note that
 // this method is called after a SEND message is received,
 // but also after a transaction commit.
 func (c *Conn) handleSend(f *frame.Frame) error {
+	tx, inTx := f.Header.Contains(frame.Transaction)
+	if !inTx {
+		// frames in a transaction are authorized when committed
+		if err := c.authorize(f); err != nil {
+			return err
+		}
+	}
+
 	// Send a receipt and remove the header
 	err := c.sendReceiptImmediately(f)
 	if err != nil {
 		return err
 	}
 
-	if tx, ok := f.Header.Contains(frame.Transaction); ok {
+	if inTx {
 		// the transaction header is removed from the frame
 		err = c.txStore.Add(tx, f)
 		if err != nil {
@@ -757,6 +1111,11 @@ func (c *Conn) handleSend(f *frame.Frame) error {
 		// not in a transaction
 		// change from SEND to MESSAGE
 		f.Command = frame.MESSAGE
+
+		// headers set by the server cannot be set by the client
+		f.Header.Del(frame.DeliveryCount)
+		f.Header.Del(frame.Redelivered)
+		f.Header.Del(frame.OriginalDestination)
 		c.requestChannel <- Request{Op: EnqueueOp, Frame: f}
 	}
 
@@ -19,6 +19,10 @@ const (
 	invalidOperationForFrame = errorMessage("invalid operation for frame")
 	exceededMaxFrameSize     = errorMessage("exceeded max frame size")
 	invalidHeaderValue       = errorMessage("invalid header value")
+	invalidPrefetchCount     = errorMessage("invalid prefetch-count")
+	durableWithoutClientId   = errorMessage("durable subscription requires client-id in CONNECT frame")
+	invalidDurableClientId   = errorMessage("durable subscription requires client-id without '/'")
+	invalidDurableName       = errorMessage("durable subscription name cannot contain '/'")
 )
 
 type errorMessage string
@@ -31,6 +35,14 @@ func missingHeader(name string) errorMessage {
 	return errorMessage("missing header: " + name)
 }
 
+func invalidSelector(err error) errorMessage {
+	return errorMessage("invalid selector: " + err.Error())
+}
+
+func notAuthorized(destination string) errorMessage {
+	return errorMessage("not authorized: " + destination)
+}
+
 func prohibitedHeader(name string) errorMessage {
 	return errorMessage("prohibited header: " + name)
 }
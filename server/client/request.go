@@ -21,12 +21,13 @@ const (
 	RequeueOp                       // re-queue a message, not successfully sent
 	ConnectedOp                     // connection established
 	DisconnectedOp                  // connection disconnected
+	ReadyOp                         // subscription ready for another queue frame, Frame acknowledged
 )
 
 // Client requests received to be processed by main processing loop
 type Request struct {
 	Op    RequestOp     // opcode for request
-	Sub   *Subscription // SubscribeOp, UnsubscribeOp
-	Frame *frame.Frame  // EnqueueOp, RequeueOp
+	Sub   *Subscription // SubscribeOp, UnsubscribeOp, ReadyOp, RequeueOp
+	Frame *frame.Frame  // EnqueueOp, RequeueOp, ReadyOp
 	Conn  *Conn         // ConnectedOp, DisconnectedOp
 }
@@ -2,24 +2,29 @@ package client
 
 import (
 	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/selector"
 )
 
 type Subscription struct {
-	conn    *Conn
-	dest    string
-	id      string            // client's subscription id
-	ack     string            // auto, client, client-individual
-	msgId   uint64            // message-id (or ack) for acknowledgement
-	subList *SubscriptionList // am I in a list
-	frame   *frame.Frame      // message allocated to subscription
+	conn     *Conn
+	dest     string
+	id       string             // client's subscription id
+	ack      string             // auto, client, client-individual
+	prefetch int                // max number of unacknowledged queue frames
+	selector *selector.Selector // selects frames, nil selects all frames
+	subList  *SubscriptionList  // am I in a list
+
+	durableQueue  string // queue storage name, for durable subscriptions only
+	removeDurable bool   // remove the durable subscription on unsubscribe
 }
 
-func newSubscription(c *Conn, dest string, id string, ack string) *Subscription {
+func newSubscription(c *Conn, dest string, id string, ack string, prefetch int) *Subscription {
 	return &Subscription{
-		conn: c,
-		dest: dest,
-		id:   id,
-		ack:  ack,
+		conn:     c,
+		dest:     dest,
+		id:       id,
+		ack:      ack,
+		prefetch: prefetch,
 	}
 }
 
@@ -35,34 +40,73 @@ func (s *Subscription) Id() string {
 	return s.id
 }
 
-func (s *Subscription) IsAckedBy(msgId uint64) bool {
-	switch s.ack {
-	case frame.AckAuto:
-		return true
-	case frame.AckClient:
-		// any later message acknowledges an earlier message
-		return msgId >= s.msgId
-	case frame.AckClientIndividual:
-		return msgId == s.msgId
+// Durable returns true if this is a durable topic subscription. The
+// messages for a durable subscription are stored in a queue while the
+// client is not subscribed.
+func (s *Subscription) Durable() bool {
+	return s.durableQueue != ""
+}
+
+// QueueName returns the name of the queue in queue storage that holds
+// messages for the subscription. For durable subscriptions this is
+// unique to the client id and subscription name, otherwise it is the
+// destination.
+func (s *Subscription) QueueName() string {
+	if s.durableQueue != "" {
+		return s.durableQueue
 	}
+	return s.dest
+}
+
+// Principal returns the authenticated identity of the client, which is
+// its login, or the common name of its certificate if it logged in with
+// a TLS client certificate and no login.
+func (s *Subscription) Principal() string {
+	return s.conn.principal
+}
+
+// Reject sends an ERROR frame to the client, because the subscription
+// cannot be accepted. The client connection is closed once the ERROR
+// frame has been sent.
+func (s *Subscription) Reject(err error) {
+	s.conn.SendError(err)
+}
 
-	// should not get here
-	panic("invalid value for subscript.ack")
+// RemoveDurable returns true if the client has requested that a durable
+// subscription be removed, rather than just unsubscribed.
+func (s *Subscription) RemoveDurable() bool {
+	return s.removeDurable
 }
 
-func (s *Subscription) IsNackedBy(msgId uint64) bool {
-	// TODO: not sure about this, interpreting NACK
-	// to apply to an individual message
-	return msgId == s.msgId
+// Selector returns the selector specified by the client when subscribing,
+// or nil if the subscription receives all frames.
+func (s *Subscription) Selector() *selector.Selector {
+	return s.selector
 }
 
+// Selects returns true if the frame should be sent to the subscription,
+// according to its selector.
+func (s *Subscription) Selects(f *frame.Frame) bool {
+	return s.selector == nil || s.selector.Matches(f.Header)
+}
+
+// Prefetch returns the maximum number of queue frames that can be
+// sent to the subscription before they are acknowledged by the client.
+func (s *Subscription) Prefetch() int {
+	return s.prefetch
+}
+
+// Send a message frame to the client, as part of this
+// subscription. Called within the queue when a message
+// frame is available. The queue must not send another frame
+// after Prefetch frames have been sent, until the connection
+// indicates that one of them has been acknowledged.
 func (s *Subscription) SendQueueFrame(f *frame.Frame) {
 	s.setSubscriptionHeader(f)
-	s.frame = f
 
 	// let the connection deal with the subscription
 	// acknowledgement
-	s.conn.subChannel <- s
+	s.conn.addQueueFrame(queueFrame{sub: s, frame: f})
 }
 
 // Send a message frame to the client, as part of this
@@ -77,8 +121,11 @@ func (s *Subscription) SendTopicFrame(f *frame.Frame) {
 }
 
 func (s *Subscription) setSubscriptionHeader(f *frame.Frame) {
-	if s.frame != nil {
-		panic("subscription already has a frame pending")
-	}
 	f.Header.Set(frame.Subscription, s.id)
 }
+
+// A frame sent to a subscription from a queue.
+type queueFrame struct {
+	sub   *Subscription
+	frame *frame.Frame
+}
@@ -2,6 +2,8 @@ package client
 
 import (
 	"container/list"
+
+	"github.com/go-stomp/stomp/frame"
 )
 
 // Maintains a list of subscriptions. Not thread-safe.
@@ -41,6 +43,21 @@ func (sl *SubscriptionList) Get() *Subscription {
 	return sub
 }
 
+// Gets the first subscription in the list that selects the frame,
+// or nil if there is no such subscription. The subscription is
+// removed from the list.
+func (sl *SubscriptionList) GetSelecting(f *frame.Frame) *Subscription {
+	for e := sl.subs.Front(); e != nil; e = e.Next() {
+		sub := e.Value.(*Subscription)
+		if sub.Selects(f) {
+			sl.subs.Remove(e)
+			sub.subList = nil
+			return sub
+		}
+	}
+	return nil
+}
+
 // Removes the subscription from the list.
 func (sl *SubscriptionList) Remove(s *Subscription) {
 	for e := sl.subs.Front(); e != nil; e = e.Next() {
@@ -65,37 +82,6 @@ func (sl *SubscriptionList) FindByIdAndRemove(id string) *Subscription {
 	return nil
 }
 
-// Finds all subscriptions in the subscription list that are acked by the
-// specified message-id (or ack) header. The subscription is removed from
-// the list and the callback function called for that subscription.
-func (sl *SubscriptionList) Ack(msgId uint64, callback func(s *Subscription)) {
-	for e := sl.subs.Front(); e != nil; {
-		next := e.Next()
-		sub := e.Value.(*Subscription)
-		if sub.IsAckedBy(msgId) {
-			sl.subs.Remove(e)
-			callback(sub)
-		}
-		e = next
-	}
-}
-
-// Finds all subscriptions in the subscription list that are *nacked* by the
-// specified message-id (or ack) header. The subscription is removed from
-// the list and the callback function called for that subscription. Current
-// understanding that all NACKs are individual, but not sure
-func (sl *SubscriptionList) Nack(msgId uint64, callback func(s *Subscription)) {
-	for e := sl.subs.Front(); e != nil; {
-		next := e.Next()
-		sub := e.Value.(*Subscription)
-		if sub.IsNackedBy(msgId) {
-			sl.subs.Remove(e)
-			callback(sub)
-		}
-		e = next
-	}
-}
-
 // Invoke a callback function for every subscription in the list.
 func (sl *SubscriptionList) ForEach(callback func(s *Subscription, isLast bool)) {
 	for e := sl.subs.Front(); e != nil; {
@@ -55,6 +55,21 @@ func (txs *txStore) Commit(tx string, commitFunc func(f *frame.Frame) error) err
 	return txUnknown
 }
 
+// Check calls checkFunc in order for each frame queued for the
+// transaction, without removing them, and returns the first error
+// returned by checkFunc.
+func (txs *txStore) Check(tx string, checkFunc func(f *frame.Frame) error) error {
+	if list, ok := txs.transactions[tx]; ok {
+		for element := list.Front(); element != nil; element = element.Next() {
+			if err := checkFunc(element.Value.(*frame.Frame)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return txUnknown
+}
+
 func (txs *txStore) Add(tx string, f *frame.Frame) error {
 	if list, ok := txs.transactions[tx]; ok {
 		f.Header.Del(frame.Transaction)
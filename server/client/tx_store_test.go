@@ -79,3 +79,37 @@ func (s *TxStoreSuite) TestSuccessfulTx(c *C) {
 	})
 	c.Check(err, Equals, txUnknown)
 }
+
+func (s *TxStoreSuite) TestCheck(c *C) {
+	txs := txStore{}
+
+	err := txs.Check("tx1", func(f *frame.Frame) error { return nil })
+	c.Assert(err, Equals, txUnknown)
+
+	err = txs.Begin("tx1")
+	c.Assert(err, IsNil)
+	f1 := frame.New(frame.MESSAGE, frame.Destination, "/queue/1")
+	f2 := frame.New(frame.MESSAGE, frame.Destination, "/queue/2")
+	c.Assert(txs.Add("tx1", f1), IsNil)
+	c.Assert(txs.Add("tx1", f2), IsNil)
+
+	var checked []*frame.Frame
+	err = txs.Check("tx1", func(f *frame.Frame) error {
+		checked = append(checked, f)
+		if f == f2 {
+			return invalidFrameFormat
+		}
+		return nil
+	})
+	c.Check(err, Equals, invalidFrameFormat)
+	c.Check(checked, DeepEquals, []*frame.Frame{f1, f2})
+
+	// the frames remain in the transaction
+	var committed []*frame.Frame
+	err = txs.Commit("tx1", func(f *frame.Frame) error {
+		committed = append(committed, f)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(committed, DeepEquals, []*frame.Frame{f1, f2})
+}
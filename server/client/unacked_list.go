@@ -0,0 +1,86 @@
+package client
+
+import (
+	"container/list"
+
+	"github.com/go-stomp/stomp/frame"
+)
+
+// A queue frame sent to the client that requires acknowledgement.
+type unackedMessage struct {
+	sub   *Subscription
+	msgId uint64       // message-id (or ack) for acknowledgement
+	frame *frame.Frame // requeued if not acknowledged
+}
+
+// Maintains a list of frames sent to the client that have not
+// been acknowledged, in the order they were sent. Not thread-safe.
+type unackedList struct {
+	msgs *list.List
+}
+
+func newUnackedList() *unackedList {
+	return &unackedList{list.New()}
+}
+
+// Add a message to the back of the list.
+func (ul *unackedList) Add(sub *Subscription, msgId uint64, f *frame.Frame) {
+	ul.msgs.PushBack(&unackedMessage{sub: sub, msgId: msgId, frame: f})
+}
+
+// Gets the first message in the list, or nil if the list is
+// empty. The message is removed from the list.
+func (ul *unackedList) Get() *unackedMessage {
+	front := ul.msgs.Front()
+	if front == nil {
+		return nil
+	}
+	ul.msgs.Remove(front)
+	return front.Value.(*unackedMessage)
+}
+
+// Finds all messages that are acknowledged by the specified message-id
+// (or ack) header. For subscriptions with an ack mode of "client", this
+// is the message with the matching id, and all earlier messages sent to
+// the same subscription. For "client-individual" subscriptions it is only
+// the matching message. Each message is removed from the list and the
+// callback function called for that message.
+func (ul *unackedList) Ack(msgId uint64, callback func(m *unackedMessage)) {
+	acked := ul.find(msgId)
+	if acked == nil {
+		return
+	}
+	for e := ul.msgs.Front(); e != nil; {
+		next := e.Next()
+		m := e.Value.(*unackedMessage)
+		if m == acked || (m.sub == acked.sub && m.sub.ack == frame.AckClient && m.msgId < msgId) {
+			ul.msgs.Remove(e)
+			callback(m)
+		}
+		e = next
+	}
+}
+
+// Finds the message that is *nacked* by the specified message-id (or ack)
+// header. The message is removed from the list and the callback function
+// called for that message. Current understanding that all NACKs are
+// individual, but not sure
+func (ul *unackedList) Nack(msgId uint64, callback func(m *unackedMessage)) {
+	for e := ul.msgs.Front(); e != nil; e = e.Next() {
+		m := e.Value.(*unackedMessage)
+		if m.msgId == msgId {
+			ul.msgs.Remove(e)
+			callback(m)
+			return
+		}
+	}
+}
+
+func (ul *unackedList) find(msgId uint64) *unackedMessage {
+	for e := ul.msgs.Front(); e != nil; e = e.Next() {
+		if m := e.Value.(*unackedMessage); m.msgId == msgId {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type UnackedListSuite struct{}
+
+var _ = Suite(&UnackedListSuite{})
+
+func (s *UnackedListSuite) TestAck(c *C) {
+	sub1 := &Subscription{dest: "/dest1", id: "1", ack: "client"}
+	sub2 := &Subscription{dest: "/dest3", id: "2", ack: "client-individual"}
+	sub3 := &Subscription{dest: "/dest4", id: "3", ack: "client"}
+
+	ul := newUnackedList()
+	ul.Add(sub1, 101, nil)
+	ul.Add(sub2, 102, nil)
+	ul.Add(sub1, 103, nil)
+	ul.Add(sub3, 104, nil)
+	ul.Add(sub1, 105, nil)
+
+	var msgIds []uint64
+	callback := func(m *unackedMessage) {
+		msgIds = append(msgIds, m.msgId)
+	}
+
+	// acknowledges earlier messages for the same subscription only
+	ul.Ack(103, callback)
+	c.Assert(msgIds, DeepEquals, []uint64{101, 103})
+
+	// unknown message-id acknowledges nothing
+	msgIds = nil
+	ul.Ack(999, callback)
+	c.Assert(msgIds, IsNil)
+
+	// client-individual acknowledges only the one message
+	ul.Add(sub2, 106, nil)
+	ul.Ack(106, callback)
+	c.Assert(msgIds, DeepEquals, []uint64{106})
+
+	c.Assert(ul.Get().msgId, Equals, uint64(102))
+	c.Assert(ul.Get().msgId, Equals, uint64(104))
+	c.Assert(ul.Get().msgId, Equals, uint64(105))
+	c.Assert(ul.Get(), IsNil)
+}
+
+func (s *UnackedListSuite) TestNack(c *C) {
+	sub1 := &Subscription{dest: "/dest1", id: "1", ack: "client"}
+	sub2 := &Subscription{dest: "/dest3", id: "2", ack: "client-individual"}
+
+	ul := newUnackedList()
+	ul.Add(sub1, 101, nil)
+	ul.Add(sub2, 102, nil)
+	ul.Add(sub1, 103, nil)
+	ul.Add(sub2, 104, nil)
+
+	var msgIds []uint64
+	callback := func(m *unackedMessage) {
+		msgIds = append(msgIds, m.msgId)
+	}
+
+	ul.Nack(103, callback)
+	c.Assert(msgIds, DeepEquals, []uint64{103})
+
+	c.Assert(ul.Get().msgId, Equals, uint64(101))
+	c.Assert(ul.Get().msgId, Equals, uint64(102))
+	c.Assert(ul.Get().msgId, Equals, uint64(104))
+	c.Assert(ul.Get(), IsNil)
+}
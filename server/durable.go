@@ -0,0 +1,201 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/queue"
+	"github.com/go-stomp/stomp/server/selector"
+	"github.com/go-stomp/stomp/server/topic"
+)
+
+// Name of the queue in queue storage that holds the durable topic
+// subscriptions, so that they survive a server restart when the queue
+// storage is persistent. Clients cannot send to this queue, because
+// queue destinations start with QueuePrefix.
+const durableRegistryQueue = "/durable"
+
+// Registry of durable topic subscriptions. Each durable subscription
+// has a queue in queue storage that receives a copy of every message
+// sent to its topic, and selected by its selector, whether or not the
+// client is subscribed.
+type durableRegistry struct {
+	qstore queue.Storage
+	subs   map[string]*durableSubscription // keyed by durable queue name
+}
+
+// A durable subscription in the registry.
+type durableSubscription struct {
+	topic     *topic.Pattern
+	selector  *selector.Selector
+	principal string // identity of the client that created the subscription
+}
+
+// Creates a registry, loading any durable subscriptions saved in qstore.
+func newDurableRegistry(qstore queue.Storage) (*durableRegistry, error) {
+	r := &durableRegistry{
+		qstore: qstore,
+		subs:   make(map[string]*durableSubscription),
+	}
+	entries, err := r.dequeueAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range entries {
+		var sel *selector.Selector
+		if expr, ok := f.Header.Contains(frame.Selector); ok {
+			// already validated when the client subscribed
+			sel, _ = selector.Parse(expr)
+		}
+		r.subs[f.Header.Get(frame.Id)] = &durableSubscription{
+			topic:     topic.Compile(f.Header.Get(frame.Destination)),
+			selector:  sel,
+			principal: f.Header.Get(frame.Login),
+		}
+	}
+	// the entries were removed from storage while loading
+	if err := r.save(entries); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Queues returns the names of the durable subscription queues that
+// should receive a copy of f. These are the queues for subscriptions
+// to topics that match the destination of f, which can include
+// wildcard topics, and whose selectors select f.
+func (r *durableRegistry) Queues(f *frame.Frame) []string {
+	destination := f.Header.Get(frame.Destination)
+	var names []string
+	for name, sub := range r.subs {
+		if sub.topic.Match(destination) && (sub.selector == nil || sub.selector.Matches(f.Header)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Contains returns true if a durable subscription stores its messages
+// in the queue called queueName.
+func (r *durableRegistry) Contains(queueName string) bool {
+	_, ok := r.subs[queueName]
+	return ok
+}
+
+// Principal returns the identity of the client that created the durable
+// subscription whose messages are stored in the queue called queueName.
+// Returns false if the durable subscription is not registered.
+func (r *durableRegistry) Principal(queueName string) (string, bool) {
+	sub, ok := r.subs[queueName]
+	if !ok {
+		return "", false
+	}
+	return sub.principal, true
+}
+
+// Destination returns the topic destination of the durable subscription
+// whose messages are stored in the queue called queueName. Returns false
+// if the durable subscription is not registered.
+func (r *durableRegistry) Destination(queueName string) (string, bool) {
+	sub, ok := r.subs[queueName]
+	if !ok {
+		return "", false
+	}
+	return sub.topic.String(), true
+}
+
+// Add registers a durable subscription to the topic destination, created
+// by principal, whose messages are stored in the queue called queueName.
+// Returns false if it is already registered. If the queue is registered
+// for a different topic or selector, the subscription is changed.
+func (r *durableRegistry) Add(destination, queueName string, sel *selector.Selector, principal string) (bool, error) {
+	if existing, ok := r.subs[queueName]; ok &&
+		existing.topic.String() == destination &&
+		existing.principal == principal &&
+		selectorString(existing.selector) == selectorString(sel) {
+		return false, nil
+	}
+	if _, err := r.Remove(queueName); err != nil {
+		return false, err
+	}
+	sub := &durableSubscription{topic: topic.Compile(destination), selector: sel, principal: principal}
+	r.subs[queueName] = sub
+	return true, r.qstore.Enqueue(durableRegistryQueue, sub.entry(queueName))
+}
+
+// Remove removes the durable subscription whose messages are stored in
+// the queue called queueName. Returns false if it was not registered.
+func (r *durableRegistry) Remove(queueName string) (bool, error) {
+	if _, ok := r.subs[queueName]; !ok {
+		return false, nil
+	}
+	delete(r.subs, queueName)
+
+	// rewrite the registry without the subscription
+	entries, err := r.dequeueAll()
+	if err != nil {
+		return true, err
+	}
+	return true, r.save(entries)
+}
+
+// Removes all the registry entries from queue storage, and returns them.
+func (r *durableRegistry) dequeueAll() ([]*frame.Frame, error) {
+	var entries []*frame.Frame
+	for {
+		f, err := r.qstore.Dequeue(durableRegistryQueue)
+		if err != nil {
+			return entries, err
+		}
+		if f == nil {
+			return entries, nil
+		}
+		entries = append(entries, f)
+	}
+}
+
+// Writes all the registry entries to queue storage, and then acknowledges
+// the previous entries, which have been removed by dequeueAll. If the
+// server stops part way through, queue storage that keeps frames until
+// they are acknowledged returns the previous entries to the registry,
+// and duplicate entries are ignored when the registry is loaded.
+func (r *durableRegistry) save(previous []*frame.Frame) error {
+	names := make([]string, 0, len(r.subs))
+	for name := range r.subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := r.qstore.Enqueue(durableRegistryQueue, r.subs[name].entry(name)); err != nil {
+			return err
+		}
+	}
+	for _, f := range previous {
+		if err := queue.Ack(r.qstore, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the frame that records a durable subscription in queue storage.
+func (sub *durableSubscription) entry(queueName string) *frame.Frame {
+	f := frame.New(frame.SUBSCRIBE,
+		frame.Destination, sub.topic.String(),
+		frame.Id, queueName)
+	if sub.selector != nil {
+		f.Header.Set(frame.Selector, sub.selector.String())
+	}
+	if sub.principal != "" {
+		f.Header.Set(frame.Login, sub.principal)
+	}
+	return f
+}
+
+func selectorString(sel *selector.Selector) string {
+	if sel == nil {
+		return ""
+	}
+	return sel.String()
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/topic"
+)
+
+// Default interval between removing expired messages from queue storage.
+// Override by setting Server.ExpirySweepInterval.
+const DefaultExpirySweepInterval = time.Minute
+
+// A DestinationTTL specifies the default time-to-live of messages sent
+// to destinations that match a pattern. Messages sent with an "expires"
+// header keep the expiry time set by the client.
+type DestinationTTL struct {
+	// Pattern is matched against the destination. It can contain the
+	// wildcards supported for topic destinations: see topic.Match. So
+	// "/queue/>" matches every queue, and "/topic/news.*" matches
+	// "/topic/news.sport". Use topic.Validate to check a pattern.
+	Pattern string
+
+	// TTL is the time-to-live of messages sent to matching destinations.
+	// A value of zero means that messages do not expire.
+	TTL time.Duration
+}
+
+// A DestinationTTL with a compiled pattern.
+type ttlRule struct {
+	pattern *topic.Pattern
+	ttl     time.Duration
+}
+
+// Compiles the patterns of rules.
+func compileTTL(rules []DestinationTTL) []ttlRule {
+	var compiled []ttlRule
+	for _, rule := range rules {
+		compiled = append(compiled, ttlRule{topic.Compile(rule.Pattern), rule.TTL})
+	}
+	return compiled
+}
+
+// Sets the expires header of f, if it does not already have one, using
+// the first entry in rules with a pattern matching the destination.
+func applyTTL(rules []ttlRule, destination string, f *frame.Frame, now time.Time) {
+	if _, ok := f.Header.Contains(frame.Expires); ok {
+		return
+	}
+	for _, rule := range rules {
+		if rule.pattern.Match(destination) {
+			if rule.ttl > 0 {
+				expires := now.Add(rule.ttl).UnixMilli()
+				f.Header.Set(frame.Expires, strconv.FormatInt(expires, 10))
+			}
+			return
+		}
+	}
+}
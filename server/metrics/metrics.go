@@ -0,0 +1,295 @@
+/*
+Package metrics provides counters and gauges that describe the activity
+of a STOMP server: connections, frames, subscriptions, and messages sent
+to and delivered from each destination.
+
+A Metrics value is an http.Handler that serves the metrics in the
+Prometheus text exposition format, and an expvar.Var, so it can be
+published with expvar.Publish:
+
+	m := srv.Metrics()
+	http.Handle("/metrics", m)
+	expvar.Publish("stomp", m)
+*/
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a metric whose value only increases.
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add adds n, which must not be negative, to the counter.
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+// Gauge is a metric whose value can increase and decrease.
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Inc adds one to the gauge.
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+// Dec subtracts one from the gauge.
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+// Add adds n, which can be negative, to the gauge.
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(n int64) {
+	g.value.Store(n)
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// MaxLabels is the maximum number of label values in a CounterVec or
+// GaugeVec. Once it is reached, label values without a counter or gauge
+// share the one for OtherLabel, so that clients cannot grow the metrics
+// without bound by sending to many destinations.
+const MaxLabels = 1000
+
+// OtherLabel is the label value shared by label values that exceed
+// MaxLabels.
+const OtherLabel = "(other)"
+
+// Returns the label value to use for label in a vec containing n
+// label values, which does not contain label.
+func newLabel(label string, n int) string {
+	if n >= MaxLabels {
+		return OtherLabel
+	}
+	return label
+}
+
+// CounterVec is a set of counters, one for each value of a label,
+// such as a destination. The zero value is ready to use.
+type CounterVec struct {
+	mutex    sync.Mutex
+	counters map[string]*Counter
+}
+
+// With returns the counter for a label value, creating it if necessary.
+func (v *CounterVec) With(label string) *Counter {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	c, ok := v.counters[label]
+	if !ok {
+		if v.counters == nil {
+			v.counters = make(map[string]*Counter)
+		}
+		label = newLabel(label, len(v.counters))
+		if c, ok = v.counters[label]; !ok {
+			c = &Counter{}
+			v.counters[label] = c
+		}
+	}
+	return c
+}
+
+// Delete removes the counter for a label value, for example when the
+// destination it describes no longer exists.
+func (v *CounterVec) Delete(label string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.counters, label)
+}
+
+// Values returns the value of the counter for each label value.
+func (v *CounterVec) Values() map[string]int64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	values := make(map[string]int64, len(v.counters))
+	for label, c := range v.counters {
+		values[label] = c.Value()
+	}
+	return values
+}
+
+// GaugeVec is a set of gauges, one for each value of a label, such as
+// a destination. The zero value is ready to use.
+type GaugeVec struct {
+	mutex  sync.Mutex
+	gauges map[string]*Gauge
+}
+
+// With returns the gauge for a label value, creating it if necessary.
+func (v *GaugeVec) With(label string) *Gauge {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	g, ok := v.gauges[label]
+	if !ok {
+		if v.gauges == nil {
+			v.gauges = make(map[string]*Gauge)
+		}
+		label = newLabel(label, len(v.gauges))
+		if g, ok = v.gauges[label]; !ok {
+			g = &Gauge{}
+			v.gauges[label] = g
+		}
+	}
+	return g
+}
+
+// Delete removes the gauge for a label value, for example when the
+// destination it describes no longer exists.
+func (v *GaugeVec) Delete(label string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.gauges, label)
+}
+
+// Values returns the value of the gauge for each label value.
+func (v *GaugeVec) Values() map[string]int64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	values := make(map[string]int64, len(v.gauges))
+	for label, g := range v.gauges {
+		values[label] = g.Value()
+	}
+	return values
+}
+
+// Metrics contains the metrics for a server. The zero value is ready to
+// use, and all methods are safe to call concurrently. Metrics by
+// destination are labelled with the destination of the message or
+// subscription, except that messages delivered to topic subscriptions
+// are labelled with the destination of the topic subscribed to. So topic
+// subscriptions with wildcard destinations are labelled with the
+// wildcard destination. The labels for a topic are deleted when it has
+// no subscriptions left.
+type Metrics struct {
+	Connections          Gauge      // client connections currently open
+	ConnectionsTotal     Counter    // client connections accepted
+	FramesReceived       CounterVec // frames received from clients, by command
+	FramesSent           CounterVec // frames sent to clients, by command
+	Subscriptions        GaugeVec   // current subscriptions, by destination
+	MessagesEnqueued     CounterVec // messages sent by clients, by destination
+	MessagesDelivered    CounterVec // messages sent to subscribers, by destination
+	MessagesRedelivered  CounterVec // queue messages sent to subscribers more than once, by destination
+	MessagesDeadLettered CounterVec // messages moved to a dead-letter queue, by original destination
+	MessagesExpired      CounterVec // messages that expired before delivery, by destination
+	QueueDepth           GaugeVec   // messages stored in each queue, waiting for a subscriber
+}
+
+// Description of a metric, for exporting.
+type metric struct {
+	name  string // name in the Prometheus exposition format
+	help  string
+	kind  string // "counter" or "gauge"
+	label string // name of the label, if the metric has one
+	value func(m *Metrics) interface{}
+}
+
+// All metrics in the order they are exported. The value function
+// returns an int64, or a map[string]int64 for labelled metrics.
+var allMetrics = []metric{
+	{"stomp_connections", "Number of open client connections.", "gauge", "",
+		func(m *Metrics) interface{} { return m.Connections.Value() }},
+	{"stomp_connections_total", "Number of client connections accepted.", "counter", "",
+		func(m *Metrics) interface{} { return m.ConnectionsTotal.Value() }},
+	{"stomp_frames_received_total", "Number of frames received from clients.", "counter", "command",
+		func(m *Metrics) interface{} { return m.FramesReceived.Values() }},
+	{"stomp_frames_sent_total", "Number of frames sent to clients.", "counter", "command",
+		func(m *Metrics) interface{} { return m.FramesSent.Values() }},
+	{"stomp_subscriptions", "Number of subscriptions.", "gauge", "destination",
+		func(m *Metrics) interface{} { return m.Subscriptions.Values() }},
+	{"stomp_messages_enqueued_total", "Number of messages sent by clients.", "counter", "destination",
+		func(m *Metrics) interface{} { return m.MessagesEnqueued.Values() }},
+	{"stomp_messages_delivered_total", "Number of messages sent to subscribers.", "counter", "destination",
+		func(m *Metrics) interface{} { return m.MessagesDelivered.Values() }},
+	{"stomp_messages_redelivered_total", "Number of queue messages sent to subscribers more than once.", "counter", "destination",
+		func(m *Metrics) interface{} { return m.MessagesRedelivered.Values() }},
+	{"stomp_messages_dead_lettered_total", "Number of messages moved to a dead-letter queue.", "counter", "destination",
+		func(m *Metrics) interface{} { return m.MessagesDeadLettered.Values() }},
+	{"stomp_messages_expired_total", "Number of messages that expired before delivery.", "counter", "destination",
+		func(m *Metrics) interface{} { return m.MessagesExpired.Values() }},
+	{"stomp_queue_depth", "Number of messages waiting in a queue.", "gauge", "destination",
+		func(m *Metrics) interface{} { return m.QueueDepth.Values() }},
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text
+// exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, mt := range allMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", mt.name, mt.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", mt.name, mt.kind)
+		switch value := mt.value(m).(type) {
+		case int64:
+			fmt.Fprintf(bw, "%s %d\n", mt.name, value)
+		case map[string]int64:
+			for _, label := range sortedKeys(value) {
+				fmt.Fprintf(bw, "%s{%s=\"%s\"} %d\n", mt.name, mt.label, escapeLabel(label), value[label])
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// String returns the metrics as a JSON object, so that Metrics
+// implements expvar.Var. Metrics are named as in the Prometheus format
+// without the "stomp_" prefix, and labelled metrics are objects keyed
+// by label value.
+func (m *Metrics) String() string {
+	values := make(map[string]interface{}, len(allMetrics))
+	for _, mt := range allMetrics {
+		values[strings.TrimPrefix(mt.name, "stomp_")] = mt.value(m)
+	}
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+func sortedKeys(values map[string]int64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Escapes a label value for the Prometheus text exposition format.
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
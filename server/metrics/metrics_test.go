@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type MetricsSuite struct{}
+
+var _ = Suite(&MetricsSuite{})
+
+func (s *MetricsSuite) TestCounterAndGauge(c *C) {
+	var v CounterVec
+	v.With("a").Inc()
+	v.With("a").Add(2)
+	v.With("b").Inc()
+	c.Check(v.Values(), DeepEquals, map[string]int64{"a": 3, "b": 1})
+
+	var g GaugeVec
+	g.With("a").Inc()
+	g.With("a").Inc()
+	g.With("a").Dec()
+	g.With("b").Set(5)
+	g.With("b").Add(-2)
+	c.Check(g.Values(), DeepEquals, map[string]int64{"a": 1, "b": 3})
+	g.Delete("a")
+	c.Check(g.Values(), DeepEquals, map[string]int64{"b": 3})
+	v.Delete("b")
+	c.Check(v.Values(), DeepEquals, map[string]int64{"a": 3})
+}
+
+func (s *MetricsSuite) TestMaxLabels(c *C) {
+	var v CounterVec
+	var g GaugeVec
+	for i := 0; i < MaxLabels+10; i++ {
+		v.With(strconv.Itoa(i)).Inc()
+		g.With(strconv.Itoa(i)).Inc()
+	}
+	c.Check(len(v.Values()), Equals, MaxLabels+1)
+	c.Check(v.Values()["0"], Equals, int64(1))
+	c.Check(v.Values()[OtherLabel], Equals, int64(10))
+	c.Check(len(g.Values()), Equals, MaxLabels+1)
+	c.Check(g.Values()[OtherLabel], Equals, int64(10))
+
+	// existing labels are still counted separately
+	v.With("0").Inc()
+	c.Check(v.Values()["0"], Equals, int64(2))
+}
+
+func (s *MetricsSuite) TestWritePrometheus(c *C) {
+	m := &Metrics{}
+	m.Connections.Inc()
+	m.ConnectionsTotal.Add(3)
+	m.MessagesEnqueued.With("/queue/b").Add(2)
+	m.MessagesEnqueued.With("/queue/a").Inc()
+	m.MessagesEnqueued.With(`/topic/"quoted"\n`).Inc()
+
+	var b strings.Builder
+	c.Assert(m.WritePrometheus(&b), IsNil)
+	text := b.String()
+	c.Check(strings.Contains(text, `# HELP stomp_connections Number of open client connections.
+# TYPE stomp_connections gauge
+stomp_connections 1
+# HELP stomp_connections_total Number of client connections accepted.
+# TYPE stomp_connections_total counter
+stomp_connections_total 3
+`), Equals, true, Commentf("%s", text))
+	c.Check(strings.Contains(text, `# TYPE stomp_messages_enqueued_total counter
+stomp_messages_enqueued_total{destination="/queue/a"} 1
+stomp_messages_enqueued_total{destination="/queue/b"} 2
+stomp_messages_enqueued_total{destination="/topic/\"quoted\"\\n"} 1
+`), Equals, true, Commentf("%s", text))
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	c.Check(recorder.Header().Get("Content-Type"), Matches, "text/plain; version=0.0.4.*")
+	c.Check(recorder.Body.String(), Equals, text)
+}
+
+func (s *MetricsSuite) TestString(c *C) {
+	m := &Metrics{}
+	m.Connections.Set(2)
+	m.QueueDepth.With("/queue/a").Set(7)
+
+	var values map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(m.String()), &values), IsNil)
+	c.Check(values["connections"], Equals, float64(2))
+	c.Check(values["queue_depth"], DeepEquals, map[string]interface{}{"/queue/a": float64(7)})
+	c.Check(values["frames_sent_total"], DeepEquals, map[string]interface{}{})
+}
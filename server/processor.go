@@ -1,50 +1,241 @@
 package server
 
 import (
-	"log"
+	"context"
+	"crypto/x509"
+	"errors"
+	"log/slog"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-stomp/stomp/frame"
 	"github.com/go-stomp/stomp/server/client"
+	"github.com/go-stomp/stomp/server/metrics"
 	"github.com/go-stomp/stomp/server/queue"
 	"github.com/go-stomp/stomp/server/topic"
 )
 
+// Error messages sent to clients.
+var (
+	errShuttingDown    = errors.New("server shutting down")
+	errDurableActive   = errors.New("durable subscription already active")
+	errDurableNotOwner = errors.New("durable subscription created by another user")
+)
+
+func errNotAuthorized(destination string) error {
+	return errors.New("not authorized: " + destination)
+}
+
 type requestProcessor struct {
-	server *Server
-	ch     chan client.Request
-	tm     *topic.Manager
-	qm     *queue.Manager
-	stop   bool // has stop been requested
+	server  *Server
+	ch      chan client.Request
+	admin   chan func() // functions run by the admin API
+	tm      *topic.Manager
+	qm      *queue.Manager
+	qstore  queue.Storage
+	durable *durableRegistry
+	ttl     []ttlRule                       // compiled from server.TTL
+	active  map[string]*client.Subscription // subscribed durable subscriptions, by queue name
+	metrics *metrics.Metrics
+	once    sync.Once
+
+	mutex     sync.Mutex
+	stop      bool                  // has stop been requested
+	stopCh    chan struct{}         // closed when stop is requested
+	sends     sync.WaitGroup        // ERROR frames being sent to clients by Stop
+	sendCtx   context.Context       // cancelled when stop is forced
+	cancel    context.CancelFunc    // cancels sendCtx
+	done      chan struct{}         // closed when stopped
+	listeners map[net.Listener]bool // listeners accepting connections
+	conns     map[*client.Conn]bool // client connections not yet disconnected
 }
 
 func newRequestProcessor(server *Server) *requestProcessor {
 	proc := &requestProcessor{
-		server: server,
-		ch:     make(chan client.Request, 128),
-		tm:     topic.NewManager(),
+		server:  server,
+		ch:      make(chan client.Request, 128),
+		admin:   make(chan func()),
+		tm:      topic.NewManager(),
+		active:  make(map[string]*client.Subscription),
+		ttl:     compileTTL(server.TTL),
+		metrics: server.Metrics(),
+
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[*client.Conn]bool),
+	}
+	proc.sendCtx, proc.cancel = context.WithCancel(context.Background())
+
+	qstore := server.QueueStorage
+	if qstore == nil {
+		qstore = queue.NewMemoryQueueStorage()
 	}
+	qstore.Start()
+	proc.qstore = qstore
+	proc.qm = queue.NewManager(qstore)
+	proc.qm.MaxDeliveries = server.MaxDeliveries
+	proc.qm.DeadLetterPrefix = server.DeadLetterPrefix
+	proc.qm.DeadLetterExpired = server.DeadLetterExpired
+	proc.qm.Logger = proc.logger()
+	proc.qm.Metrics = proc.metrics
+	proc.tm.Metrics = proc.metrics
 
-	if server.QueueStorage == nil {
-		proc.qm = queue.NewManager(queue.NewMemoryQueueStorage())
-	} else {
-		proc.qm = queue.NewManager(server.QueueStorage)
+	durable, err := newDurableRegistry(qstore)
+	if err != nil {
+		proc.logger().Error("failed to load durable subscriptions", "error", err)
+		durable = &durableRegistry{qstore: qstore, subs: make(map[string]*durableSubscription)}
 	}
+	proc.durable = durable
+	proc.countQueued()
 
 	return proc
 }
 
+// Serve starts processing requests, if not already started, and then
+// accepts connections from l until l returns an error. Connections from
+// all listeners share the same queues and topics.
 func (proc *requestProcessor) Serve(l net.Listener) error {
-	go proc.Listen(l)
+	proc.start()
+	return proc.Listen(l)
+}
+
+// start starts processing requests, if not already started.
+func (proc *requestProcessor) start() {
+	proc.once.Do(func() {
+		go proc.run()
+	})
+}
+
+// Stop stops accepting connections, and disconnects all clients. If force
+// is false, each client is sent an ERROR frame before it is disconnected,
+// otherwise the network connections are closed immediately. Use the done
+// channel to wait until all clients have disconnected, and the queue storage
+// has been stopped.
+func (proc *requestProcessor) Stop(force bool) {
+	proc.start()
+
+	proc.mutex.Lock()
+	if !proc.stop {
+		proc.stop = true
+		close(proc.stopCh)
+		for l := range proc.listeners {
+			l.Close()
+		}
+	}
+	conns := make([]*client.Conn, 0, len(proc.conns))
+	for conn := range proc.conns {
+		conns = append(conns, conn)
+	}
+	if !force {
+		// added while holding the mutex, so that stopped
+		// waits for the sends once all clients disconnect
+		proc.sends.Add(len(conns))
+	}
+	proc.mutex.Unlock()
 
+	if force {
+		proc.cancel()
+	}
+	for _, conn := range conns {
+		if force {
+			conn.Close()
+		} else {
+			// Might block if the client is not reading, in
+			// which case the send is cancelled and the client
+			// is closed when the caller gives up waiting.
+			go func(conn *client.Conn) {
+				defer proc.sends.Done()
+				conn.SendErrorContext(proc.sendCtx, errShuttingDown)
+			}(conn)
+		}
+	}
+}
+
+// run processes requests received from client connections.
+func (proc *requestProcessor) run() {
+	sweepInterval := proc.server.ExpirySweepInterval
+	if sweepInterval <= 0 {
+		sweepInterval = DefaultExpirySweepInterval
+	}
+	sweepTicker := time.NewTicker(sweepInterval)
+	defer sweepTicker.Stop()
+
+	stopCh := proc.stopCh
 	for {
-		r := <-proc.ch
+		var r client.Request
+		select {
+		case <-sweepTicker.C:
+			if stopCh != nil {
+				if err := proc.qm.Sweep(time.Now()); err != nil {
+					proc.logger().Error("failed to remove expired messages", "error", err)
+				}
+				proc.countQueued()
+			}
+			continue
+		case fn := <-proc.admin:
+			fn()
+			continue
+		case r = <-proc.ch:
+		case <-stopCh:
+			stopCh = nil
+			proc.qm.UnsubscribeAll()
+			if proc.stopped() {
+				return
+			}
+			continue
+		}
+
+		if stopCh == nil {
+			// Stopping: the queues have no subscriptions, so
+			// messages are no longer sent to clients, and messages
+			// requeued by disconnecting clients are returned to
+			// the queues.
+			switch r.Op {
+			case client.EnqueueOp:
+				destination := r.Frame.Header.Get(frame.Destination)
+				proc.metrics.MessagesEnqueued.With(destination).Inc()
+				applyTTL(proc.ttl, destination, r.Frame, time.Now())
+				if isQueueDestination(destination) {
+					proc.qm.Find(destination).Enqueue(r.Frame)
+				} else {
+					for _, name := range proc.durable.Queues(r.Frame) {
+						proc.qm.Find(name).Enqueue(r.Frame.Clone())
+					}
+				}
+			case client.RequeueOp:
+				if name, ok := proc.requeueName(r); ok {
+					proc.qm.Find(name).Requeue(r.Frame)
+				}
+			case client.ReadyOp:
+				proc.qm.Ack(r.Frame)
+			case client.DisconnectedOp:
+				for _, rr := range r.Conn.UnsentQueueFrames() {
+					if name, ok := proc.requeueName(rr); ok {
+						proc.qm.Find(name).Requeue(rr.Frame)
+					}
+				}
+				proc.removeConn(r.Conn)
+				if proc.stopped() {
+					return
+				}
+			}
+			continue
+		}
+
 		switch r.Op {
 		case client.SubscribeOp:
-			if isQueueDestination(r.Sub.Destination()) {
-				queue := proc.qm.Find(r.Sub.Destination())
+			if r.Sub.Durable() {
+				if err := proc.addDurable(r.Sub); err != nil {
+					proc.rejectDurable(r.Sub, err)
+					continue
+				}
+			}
+			proc.metrics.Subscriptions.With(r.Sub.Destination()).Inc()
+			if isQueueSubscription(r.Sub) {
+				queue := proc.qm.Find(r.Sub.QueueName())
 				// todo error handling
 				queue.Subscribe(r.Sub)
 			} else {
@@ -52,14 +243,40 @@ func (proc *requestProcessor) Serve(l net.Listener) error {
 				topic.Subscribe(r.Sub)
 			}
 
+		case client.ReadyOp:
+			// the frame acknowledged, if any, is no longer needed
+			proc.qm.Ack(r.Frame)
+
+			// only queue subscriptions wait for acknowledgement
+			if isQueueSubscription(r.Sub) {
+				queue := proc.qm.Find(r.Sub.QueueName())
+				// todo error handling
+				queue.Ready(r.Sub)
+			}
+
 		case client.UnsubscribeOp:
-			if isQueueDestination(r.Sub.Destination()) {
-				queue := proc.qm.Find(r.Sub.Destination())
+			if r.Sub.Durable() {
+				if proc.active[r.Sub.QueueName()] != r.Sub {
+					// Subscriptions that remove a durable subscription,
+					// and rejected subscriptions, are never subscribed.
+					if r.Sub.RemoveDurable() {
+						if err := proc.removeDurable(r.Sub); err != nil {
+							proc.rejectDurable(r.Sub, err)
+						}
+					}
+					continue
+				}
+				delete(proc.active, r.Sub.QueueName())
+			}
+			proc.metrics.Subscriptions.With(r.Sub.Destination()).Dec()
+			if isQueueSubscription(r.Sub) {
+				queue := proc.qm.Find(r.Sub.QueueName())
 				// todo error handling
 				queue.Unsubscribe(r.Sub)
 			} else {
 				topic := proc.tm.Find(r.Sub.Destination())
 				topic.Unsubscribe(r.Sub)
+				proc.tm.Remove(r.Sub.Destination())
 			}
 
 		case client.EnqueueOp:
@@ -69,37 +286,241 @@ func (proc *requestProcessor) Serve(l net.Listener) error {
 				panic("missing destination")
 			}
 
+			proc.metrics.MessagesEnqueued.With(destination).Inc()
+			now := time.Now()
+			applyTTL(proc.ttl, destination, r.Frame, now)
 			if isQueueDestination(destination) {
 				queue := proc.qm.Find(destination)
 				queue.Enqueue(r.Frame)
-			} else {
-				topic := proc.tm.Find(destination)
-				topic.Enqueue(r.Frame)
+			} else if !queue.Expired(r.Frame, now) {
+				// durable subscriptions receive a copy via their queues
+				for _, name := range proc.durable.Queues(r.Frame) {
+					proc.qm.Find(name).Enqueue(r.Frame.Clone())
+				}
+				proc.tm.Enqueue(destination, r.Frame)
 			}
 
 		case client.RequeueOp:
-			destination, ok := r.Frame.Header.Contains(frame.Destination)
-			if !ok {
-				// should not happen, already checked in lower layer
-				panic("missing destination")
-			}
-
 			// only requeue to queues, should never happen for topics
-			if isQueueDestination(destination) {
-				queue := proc.qm.Find(destination)
+			if name, ok := proc.requeueName(r); ok {
+				queue := proc.qm.Find(name)
 				queue.Requeue(r.Frame)
 			}
+
+		case client.DisconnectedOp:
+			// requeue any frames sent to the connection's
+			// subscriptions before they were unsubscribed
+			for _, rr := range r.Conn.UnsentQueueFrames() {
+				if name, ok := proc.requeueName(rr); ok {
+					proc.qm.Find(name).Requeue(rr.Frame)
+				}
+			}
+			proc.removeConn(r.Conn)
 		}
 	}
-	// this is no longer required for go 1.1
-	panic("not reached")
+}
+
+func (proc *requestProcessor) logger() *slog.Logger {
+	return newConfig(proc.server).Logger()
+}
+
+// Sets the queue depth metrics from the number of frames in each queue,
+// if the queue storage can count them. The depth is otherwise updated
+// as frames are added to and removed from queues, but this corrects the
+// depth after frames are removed by the queue storage, such as expired
+// frames, or frames recovered from persistent storage.
+func (proc *requestProcessor) countQueued() {
+	qstore, ok := proc.qstore.(queue.CountingStorage)
+	if !ok {
+		return
+	}
+	counts := qstore.Counts()
+	delete(counts, durableRegistryQueue)
+	for name := range proc.metrics.QueueDepth.Values() {
+		if _, ok := counts[name]; !ok {
+			proc.metrics.QueueDepth.With(name).Set(0)
+		}
+	}
+	for name, n := range counts {
+		proc.metrics.QueueDepth.With(name).Set(int64(n))
+	}
+}
+
+// do runs fn on the go-routine that processes requests, so that it can
+// use the queues and topics, and waits for it to return. Returns
+// ErrServerClosed if the processor has stopped.
+func (proc *requestProcessor) do(fn func()) error {
+	proc.start()
+	done := make(chan struct{})
+	select {
+	case proc.admin <- func() { fn(); close(done) }:
+		<-done
+		return nil
+	case <-proc.done:
+		return ErrServerClosed
+	}
+}
+
+// Returns the client connections that have not yet disconnected.
+func (proc *requestProcessor) connections() []*client.Conn {
+	proc.mutex.Lock()
+	defer proc.mutex.Unlock()
+	conns := make([]*client.Conn, 0, len(proc.conns))
+	for conn := range proc.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (proc *requestProcessor) removeConn(conn *client.Conn) {
+	proc.mutex.Lock()
+	delete(proc.conns, conn)
+	proc.mutex.Unlock()
+}
+
+// stopped is called by the run go-routine once stop has been requested.
+// If all clients have disconnected, it waits for the ERROR frames sent by
+// Stop, stops the queue storage, signals that the processor has stopped
+// and returns true.
+func (proc *requestProcessor) stopped() bool {
+	proc.mutex.Lock()
+	remaining := len(proc.conns)
+	proc.mutex.Unlock()
+	if remaining > 0 {
+		return false
+	}
+	proc.sends.Wait()
+	proc.cancel()
+	proc.qstore.Stop()
+	close(proc.done)
+	return true
 }
 
 func isQueueDestination(dest string) bool {
 	return strings.HasPrefix(dest, QueuePrefix)
 }
 
-func (proc *requestProcessor) Listen(l net.Listener) {
+// Returns true if frames are sent to the subscription from a queue,
+// which is the case for queue destinations and durable subscriptions.
+func isQueueSubscription(sub *client.Subscription) bool {
+	return sub.Durable() || isQueueDestination(sub.Destination())
+}
+
+// Returns the name of the queue that a RequeueOp request returns its
+// frame to. Returns false if the frame was not sent from a queue, or
+// if it was sent for a durable subscription that has been removed.
+func (proc *requestProcessor) requeueName(r client.Request) (string, bool) {
+	if r.Sub != nil && r.Sub.Durable() {
+		return r.Sub.QueueName(), proc.durable.Contains(r.Sub.QueueName())
+	}
+	destination, ok := r.Frame.Header.Contains(frame.Destination)
+	if !ok {
+		// should not happen, already checked in lower layer
+		panic("missing destination")
+	}
+	return destination, isQueueDestination(destination)
+}
+
+// Returns an error if a client cannot use a durable subscription, because
+// it is subscribed by another client, or was created by another user.
+func (proc *requestProcessor) checkDurable(sub *client.Subscription) error {
+	if _, ok := proc.active[sub.QueueName()]; ok {
+		return errDurableActive
+	}
+	if principal, ok := proc.durable.Principal(sub.QueueName()); ok && principal != sub.Principal() {
+		return errDurableNotOwner
+	}
+	return nil
+}
+
+// Sends an ERROR frame to the client of a subscription that cannot use
+// a durable subscription, which closes the client connection.
+func (proc *requestProcessor) rejectDurable(sub *client.Subscription, err error) {
+	proc.logger().Warn("rejected durable subscription",
+		"queue", sub.QueueName(),
+		"principal", sub.Principal(),
+		"error", err)
+	sub.Reject(err)
+}
+
+// Registers a durable subscription, so that its queue receives messages
+// sent to the topic while the client is not subscribed. Returns an error
+// if the client cannot use the durable subscription.
+func (proc *requestProcessor) addDurable(sub *client.Subscription) error {
+	if err := proc.checkDurable(sub); err != nil {
+		return err
+	}
+	proc.active[sub.QueueName()] = sub
+	added, err := proc.durable.Add(sub.Destination(), sub.QueueName(), sub.Selector(), sub.Principal())
+	if err != nil {
+		proc.logger().Error("failed to save durable subscription",
+			"destination", sub.Destination(),
+			"queue", sub.QueueName(),
+			"error", err)
+	}
+	if added {
+		proc.logger().Info("created durable subscription",
+			"destination", sub.Destination(),
+			"queue", sub.QueueName())
+	}
+	return nil
+}
+
+// Removes a durable subscription, and discards any messages stored for it.
+// Returns an error if the client cannot use the durable subscription.
+func (proc *requestProcessor) removeDurable(sub *client.Subscription) error {
+	if err := proc.checkDurable(sub); err != nil {
+		return err
+	}
+	// removing the subscription discards its messages, so the client
+	// needs permission to read them
+	if destination, ok := proc.durable.Destination(sub.QueueName()); ok &&
+		!newConfig(proc.server).AuthorizeSubscribe(sub.Principal(), destination) {
+		return errNotAuthorized(destination)
+	}
+	removed, err := proc.durable.Remove(sub.QueueName())
+	if err != nil {
+		proc.logger().Error("failed to remove durable subscription",
+			"queue", sub.QueueName(),
+			"error", err)
+	}
+	if !removed {
+		return nil
+	}
+	for {
+		f, err := proc.qstore.Dequeue(sub.QueueName())
+		if err != nil {
+			proc.logger().Error("failed to purge durable subscription",
+				"queue", sub.QueueName(),
+				"error", err)
+			break
+		}
+		if f == nil {
+			break
+		}
+		proc.qm.Ack(f)
+	}
+	proc.metrics.QueueDepth.Delete(sub.QueueName())
+	proc.logger().Info("removed durable subscription", "queue", sub.QueueName())
+	return nil
+}
+
+func (proc *requestProcessor) Listen(l net.Listener) error {
+	proc.mutex.Lock()
+	if proc.stop {
+		proc.mutex.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	proc.listeners[l] = true
+	proc.mutex.Unlock()
+
+	defer func() {
+		proc.mutex.Lock()
+		delete(proc.listeners, l)
+		proc.mutex.Unlock()
+	}()
+
 	config := newConfig(proc.server)
 	timeout := time.Duration(0) // how long to sleep on accept failure
 	for {
@@ -114,19 +535,34 @@ func (proc *requestProcessor) Listen(l net.Listener) {
 				if max := 5 * time.Second; timeout > max {
 					timeout = max
 				}
-				log.Printf("stomp: Accept error: %v; retrying in %v", err, timeout)
+				config.Logger().Warn("stomp: accept failed; retrying",
+					"error", err,
+					"retry", timeout)
 				time.Sleep(timeout)
 				continue
 			}
-			return
+			proc.mutex.Lock()
+			stopping := proc.stop
+			proc.mutex.Unlock()
+			if stopping {
+				return ErrServerClosed
+			}
+			return err
 		}
 		timeout = 0
+
+		proc.mutex.Lock()
+		if proc.stop {
+			proc.mutex.Unlock()
+			rw.Close()
+			return ErrServerClosed
+		}
 		// TODO: need to pass Server to connection so it has access to
 		// configuration parameters.
-		_ = client.NewConn(config, rw, proc.ch)
+		conn := client.NewConn(config, rw, proc.ch)
+		proc.conns[conn] = true
+		proc.mutex.Unlock()
 	}
-	// This is no longer required for go 1.1
-	panic("not reached")
 }
 
 type config struct {
@@ -144,7 +580,61 @@ func (c *config) HeartBeat() time.Duration {
 	return c.server.HeartBeat
 }
 
-func (c *config) Authenticate(login, passcode string) bool {
+func (c *config) Prefetch() int {
+	if c.server.Prefetch <= 0 {
+		return DefaultPrefetch
+	}
+	return c.server.Prefetch
+}
+
+func (c *config) FrameLimits() frame.Limits {
+	limit := func(value, defaultValue int) int {
+		switch {
+		case value == 0:
+			return defaultValue
+		case value < 0:
+			return 0
+		}
+		return value
+	}
+	return frame.Limits{
+		MaxHeaders:      limit(c.server.MaxHeaders, DefaultMaxHeaders),
+		MaxHeaderLength: limit(c.server.MaxHeaderLength, DefaultMaxHeaderLength),
+		MaxBodyLength:   limit(c.server.MaxBodyLength, DefaultMaxBodyLength),
+	}
+}
+
+func (c *config) Logger() *slog.Logger {
+	if c.server.Logger == nil {
+		return slog.Default()
+	}
+	return c.server.Logger
+}
+
+func (c *config) Metrics() *metrics.Metrics {
+	return c.server.Metrics()
+}
+
+func (c *config) AuthorizeSend(principal, destination string) bool {
+	return c.authorize(principal, destination, PermissionWrite)
+}
+
+func (c *config) AuthorizeSubscribe(principal, destination string) bool {
+	return c.authorize(principal, destination, PermissionRead)
+}
+
+func (c *config) authorize(principal, destination string, perm Permission) bool {
+	if c.server.Authorizer == nil {
+		// no authorization defined
+		return true
+	}
+	return c.server.Authorizer.Authorize(principal, destination, perm)
+}
+
+func (c *config) Authenticate(login, passcode string, cert *x509.Certificate) bool {
+	if auth, ok := c.server.Authenticator.(TLSAuthenticator); ok {
+		return auth.AuthenticateTLS(login, passcode, cert)
+	}
 	if c.server.Authenticator != nil {
 		return c.server.Authenticator.Authenticate(login, passcode)
 	}
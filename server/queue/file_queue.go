@@ -0,0 +1,872 @@
+package queue
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+)
+
+// Default values used by FileQueueStorage when the corresponding
+// FileQueueOptions field is zero.
+const (
+	DefaultSegmentSize  = 16 * 1024 * 1024
+	DefaultSyncInterval = time.Second
+)
+
+// Error values
+var (
+	ErrStorageClosed = errors.New("queue storage is closed")
+	ErrCorruptLog    = errors.New("queue storage log is corrupt")
+)
+
+// SyncPolicy specifies when FileQueueStorage flushes the log to stable
+// storage using fsync.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after every change. No acknowledged
+	// change is lost if the operating system crashes, at the cost of
+	// throughput.
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval flushes the log periodically. Changes made since
+	// the last flush can be lost if the operating system crashes, but
+	// not if only the server process crashes.
+	SyncInterval
+
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+// FileQueueOptions contains options for a FileQueueStorage.
+type FileQueueOptions struct {
+	// SegmentSize is the size in bytes at which the log moves on to a new
+	// segment file. If zero, DefaultSegmentSize is used.
+	SegmentSize int64
+
+	// Sync specifies when the log is flushed to stable storage.
+	Sync SyncPolicy
+
+	// SyncInterval is the time between flushes for the SyncInterval
+	// policy. If zero, DefaultSyncInterval is used.
+	SyncInterval time.Duration
+
+	// Logger is used to report errors that do not cause an operation
+	// to fail, such as failing to compact the log. If nil,
+	// slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// Log record types
+const (
+	recordEnqueue     = 1 // frame added to the end of a queue
+	recordRequeue     = 2 // frame added to the head of a queue
+	recordDequeue     = 3 // frame removed from a queue
+	recordSnapshot    = 4 // start of a compacted log segment
+	recordSnapshotEnd = 5 // end of the snapshot in a compacted log segment
+)
+
+// Each record has a header containing the length of the record
+// payload and a checksum of the payload.
+const recordHeaderSize = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Persistent implementation of the QueueStorage interface, which stores
+// queued frames in an append-only log in a directory of segment files.
+// Queued frames survive a restart of the server. FileQueueStorage
+// implements AcknowledgingStorage: frames that have been dequeued are
+// only removed from the log when they are acknowledged, so frames that
+// were sent to clients but not acknowledged are queued again on restart.
+//
+// Every change to a queue is appended to the log. When the log contains
+// considerably more data than the frames that are still queued, it is
+// compacted by writing the queued frames to a new segment and deleting
+// the older segments. The frames are also held in memory, so the amount
+// of queued data must fit in memory.
+type FileQueueStorage struct {
+	dir     string
+	options FileQueueOptions
+
+	mutex     sync.Mutex
+	queues    map[string]*list.List            // queued entries, keyed by queue name
+	entries   map[uint64]*list.Element         // queued entries, keyed by id
+	inFlight  map[*frame.Frame]*fileQueueEntry // dequeued entries, keyed by the frame returned
+	nextId    uint64                           // id of the next frame added
+	segments  []uint64                         // sequence numbers of segment files, in order
+	file      *os.File                         // last segment file, appended to
+	fileSize  int64                            // size of last segment file
+	totalSize int64                            // size of all segment files
+	liveSize  int64                            // size of records for queued and in-flight entries
+	dirty     bool                             // has the log been written since the last sync
+	closed    bool
+	stop      chan struct{} // closed to stop the sync go-routine
+	done      chan struct{} // closed when the sync go-routine exits
+}
+
+type fileQueueEntry struct {
+	id    uint64
+	queue string
+	frame *frame.Frame
+	size  int64 // size of the log record for the entry
+}
+
+// NewFileQueueStorage creates a FileQueueStorage that stores its log in
+// directory dir, creating the directory if necessary. Frames queued when
+// the storage was last used are recovered from the log.
+func NewFileQueueStorage(dir string, options FileQueueOptions) (*FileQueueStorage, error) {
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &FileQueueStorage{
+		dir:      dir,
+		options:  options,
+		queues:   make(map[string]*list.List),
+		entries:  make(map[uint64]*list.Element),
+		inFlight: make(map[*frame.Frame]*fileQueueEntry),
+		nextId:   1,
+	}
+	if err := s.recover(); err != nil {
+		if s.file != nil {
+			s.file.Close()
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Pushes a frame to the end of the queue. If the frame was returned by
+// Dequeue, it is also acknowledged.
+func (s *FileQueueStorage) Enqueue(queue string, f *frame.Frame) error {
+	return s.add(recordEnqueue, queue, f)
+}
+
+// Pushes a frame to the head of the queue. If the frame was returned by
+// Dequeue, it is also acknowledged.
+func (s *FileQueueStorage) Requeue(queue string, f *frame.Frame) error {
+	return s.add(recordRequeue, queue, f)
+}
+
+// Removes a frame from the head of the queue.
+// Returns nil if no frame is available. The frame
+// stays in the log until it is acknowledged.
+func (s *FileQueueStorage) Dequeue(queue string) (*frame.Frame, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrStorageClosed
+	}
+
+	l, ok := s.queues[queue]
+	if !ok || l.Len() == 0 {
+		return nil, nil
+	}
+	return s.dequeue(l.Front().Value.(*fileQueueEntry)), nil
+}
+
+// Removes the first frame in the queue for which match returns true.
+// Returns nil if there is no such frame. The frame stays in the log
+// until it is acknowledged.
+func (s *FileQueueStorage) DequeueFunc(queue string, match func(f *frame.Frame) bool) (*frame.Frame, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrStorageClosed
+	}
+
+	l, ok := s.queues[queue]
+	if !ok {
+		return nil, nil
+	}
+	for e := l.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*fileQueueEntry)
+		if !match(entry.frame) {
+			continue
+		}
+		return s.dequeue(entry), nil
+	}
+	return nil, nil
+}
+
+// Indicates that a frame returned by Dequeue or DequeueFunc is no
+// longer needed, and removes it from the log.
+func (s *FileQueueStorage) Ack(f *frame.Frame) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrStorageClosed
+	}
+	return s.ack(f)
+}
+
+// Removes all frames that have expired at time now,
+// and returns the removed frames.
+func (s *FileQueueStorage) RemoveExpired(now time.Time) ([]ExpiredFrame, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrStorageClosed
+	}
+
+	var expired []ExpiredFrame
+	for queue, l := range s.queues {
+		for e := l.Front(); e != nil; {
+			next := e.Next()
+			entry := e.Value.(*fileQueueEntry)
+			if Expired(entry.frame, now) {
+				if err := s.append(encodeRecord(recordDequeue, entry.id, "", nil)); err != nil {
+					return expired, err
+				}
+				s.remove(entry.id)
+				expired = append(expired, ExpiredFrame{Queue: queue, Frame: entry.frame})
+			}
+			e = next
+		}
+	}
+	if len(expired) > 0 {
+		s.maybeCompact()
+	}
+	return expired, nil
+}
+
+// Returns up to max frames from the head of the queue,
+// without removing them.
+func (s *FileQueueStorage) Browse(queue string, max int) ([]*frame.Frame, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrStorageClosed
+	}
+
+	l, ok := s.queues[queue]
+	if !ok {
+		return nil, nil
+	}
+	var frames []*frame.Frame
+	for e := l.Front(); e != nil && len(frames) < max; e = e.Next() {
+		frames = append(frames, e.Value.(*fileQueueEntry).frame)
+	}
+	return frames, nil
+}
+
+// Returns the number of frames in each queue that is not empty.
+func (s *FileQueueStorage) Counts() map[string]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counts := make(map[string]int, len(s.queues))
+	for queue, l := range s.queues {
+		counts[queue] = l.Len()
+	}
+	return counts
+}
+
+// Called at server startup. Starts flushing the log
+// periodically if the sync policy is SyncInterval.
+func (s *FileQueueStorage) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed || s.stop != nil || s.options.Sync != SyncInterval {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.syncLoop(s.stop, s.done)
+}
+
+// Called prior to server shutdown. Flushes and closes the log.
+func (s *FileQueueStorage) Stop() {
+	if err := s.Close(); err != nil {
+		s.options.Logger.Error("queue storage: close failed", "dir", s.dir, "error", err)
+	}
+}
+
+// Close flushes and closes the log. Subsequent operations
+// return ErrStorageClosed.
+func (s *FileQueueStorage) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	stop, done := s.stop, s.done
+	s.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *FileQueueStorage) syncLoop(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(s.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mutex.Lock()
+			if s.dirty {
+				if err := s.file.Sync(); err != nil {
+					s.options.Logger.Error("queue storage: sync failed", "dir", s.dir, "error", err)
+				}
+				s.dirty = false
+			}
+			s.mutex.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *FileQueueStorage) add(recordType byte, queue string, f *frame.Frame) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrStorageClosed
+	}
+
+	id := s.nextId
+	record := encodeRecord(recordType, id, queue, f)
+	if err := s.append(record); err != nil {
+		return err
+	}
+	s.nextId++
+	s.insert(recordType, id, queue, f, int64(len(record)))
+
+	// The frame has been added, so failing to remove a previous copy
+	// that has been dequeued does not fail the operation. The copy is
+	// queued again on recovery.
+	if err := s.ack(f); err != nil {
+		s.options.Logger.Warn("queue storage: ack failed", "dir", s.dir, "error", err)
+	}
+	s.maybeCompact()
+	return nil
+}
+
+// dequeue removes an entry from the in-memory queues, and keeps it as
+// in flight until it is acknowledged. The log is not changed, so the entry
+// is queued again on recovery if it has not been acknowledged. Returns a
+// copy of the frame, which identifies the entry, and can be modified by
+// the caller while the entry is still written to the log on compaction.
+func (s *FileQueueStorage) dequeue(entry *fileQueueEntry) *frame.Frame {
+	s.remove(entry.id)
+	s.liveSize += entry.size
+	f := entry.frame.Clone()
+	s.inFlight[f] = entry
+	return f
+}
+
+// ack removes an in-flight entry, if f identifies one, from the log.
+func (s *FileQueueStorage) ack(f *frame.Frame) error {
+	entry, ok := s.inFlight[f]
+	if !ok {
+		return nil
+	}
+	if err := s.append(encodeRecord(recordDequeue, entry.id, "", nil)); err != nil {
+		return err
+	}
+	delete(s.inFlight, f)
+	s.liveSize -= entry.size
+	s.maybeCompact()
+	return nil
+}
+
+// insert adds an entry to the in-memory queues.
+func (s *FileQueueStorage) insert(recordType byte, id uint64, queue string, f *frame.Frame, size int64) {
+	l, ok := s.queues[queue]
+	if !ok {
+		l = list.New()
+		s.queues[queue] = l
+	}
+	entry := &fileQueueEntry{id: id, queue: queue, frame: f, size: size}
+	if recordType == recordRequeue {
+		s.entries[id] = l.PushFront(entry)
+	} else {
+		s.entries[id] = l.PushBack(entry)
+	}
+	s.liveSize += size
+}
+
+// remove removes an entry from the in-memory queues.
+func (s *FileQueueStorage) remove(id uint64) {
+	element, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	entry := element.Value.(*fileQueueEntry)
+	l := s.queues[entry.queue]
+	l.Remove(element)
+	if l.Len() == 0 {
+		delete(s.queues, entry.queue)
+	}
+	delete(s.entries, id)
+	s.liveSize -= entry.size
+}
+
+// append writes a record to the end of the log.
+func (s *FileQueueStorage) append(record []byte) error {
+	if _, err := s.file.Write(record); err != nil {
+		// Remove any partially written record, so that
+		// subsequent records can be read on recovery.
+		if truncErr := s.file.Truncate(s.fileSize); truncErr == nil {
+			s.file.Seek(s.fileSize, io.SeekStart)
+		}
+		return err
+	}
+	s.fileSize += int64(len(record))
+	s.totalSize += int64(len(record))
+
+	if s.options.Sync == SyncAlways {
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+
+	// The record has been written, so failing to start a new segment
+	// does not fail the operation. It is attempted again next time.
+	if s.fileSize >= s.options.SegmentSize {
+		if err := s.roll(); err != nil {
+			s.options.Logger.Warn("queue storage: new segment failed", "dir", s.dir, "error", err)
+		}
+	}
+	return nil
+}
+
+// roll closes the last segment file and starts a new one.
+func (s *FileQueueStorage) roll() error {
+	seq := s.segments[len(s.segments)-1] + 1
+	file, err := s.createSegment(seq)
+	if err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		file.Close()
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	s.fileSize = 0
+	s.segments = append(s.segments, seq)
+	return nil
+}
+
+// maybeCompact compacts the log if most of it is no longer needed.
+// If compaction fails, the existing log remains valid, so the failure
+// is logged and compaction is attempted again later.
+func (s *FileQueueStorage) maybeCompact() {
+	if len(s.segments) > 1 && s.totalSize > s.options.SegmentSize && s.totalSize > 2*s.liveSize {
+		if err := s.compact(); err != nil {
+			s.options.Logger.Warn("queue storage: compaction failed", "dir", s.dir, "error", err)
+		}
+	}
+}
+
+// compact writes all queued and in-flight entries to a new segment file,
+// which starts with a snapshot record and has a snapshot end record
+// following the entries. Once the new segment is flushed to stable storage, all of the
+// previous segment files are deleted. If the server crashes part way through
+// compaction, recovery discards the incomplete new segment.
+func (s *FileQueueStorage) compact() error {
+	seq := s.segments[len(s.segments)-1] + 1
+	file, err := s.createSegment(seq)
+	if err != nil {
+		return err
+	}
+
+	// In-flight entries were removed from the heads of their queues, so
+	// they are written first, in the order they were added. Each queue is
+	// then written in order from head to tail, so that the order is
+	// preserved when the records are replayed as enqueues.
+	buf := encodeRecord(recordSnapshot, s.nextId, "", nil)
+	inFlight := make([]*fileQueueEntry, 0, len(s.inFlight))
+	for _, entry := range s.inFlight {
+		inFlight = append(inFlight, entry)
+	}
+	sort.Slice(inFlight, func(i, j int) bool { return inFlight[i].id < inFlight[j].id })
+	for _, entry := range inFlight {
+		record := encodeRecord(recordEnqueue, entry.id, entry.queue, entry.frame)
+		entry.size = int64(len(record))
+		buf = append(buf, record...)
+	}
+	for queue, l := range s.queues {
+		for e := l.Front(); e != nil; e = e.Next() {
+			entry := e.Value.(*fileQueueEntry)
+			record := encodeRecord(recordEnqueue, entry.id, queue, entry.frame)
+			entry.size = int64(len(record))
+			buf = append(buf, record...)
+		}
+	}
+	buf = append(buf, encodeRecord(recordSnapshotEnd, 0, "", nil)...)
+
+	if _, err = file.Write(buf); err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = syncDir(s.dir)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(segmentPath(s.dir, seq))
+		return err
+	}
+
+	s.file.Close()
+	for _, old := range s.segments {
+		if err := os.Remove(segmentPath(s.dir, old)); err != nil {
+			s.options.Logger.Warn("queue storage: remove segment failed", "dir", s.dir, "error", err)
+		}
+	}
+	if err := syncDir(s.dir); err != nil {
+		s.options.Logger.Warn("queue storage: sync failed", "dir", s.dir, "error", err)
+	}
+
+	s.file = file
+	s.fileSize = int64(len(buf))
+	s.totalSize = s.fileSize
+	s.liveSize = 0
+	for _, element := range s.entries {
+		s.liveSize += element.Value.(*fileQueueEntry).size
+	}
+	for _, entry := range s.inFlight {
+		s.liveSize += entry.size
+	}
+	s.segments = []uint64{seq}
+	s.dirty = false
+	return nil
+}
+
+// recover replays the segment files in the log directory to rebuild
+// the queues, and opens the last segment file for appending.
+func (s *FileQueueStorage) recover() error {
+	segments, err := listSegments(s.dir)
+	if err != nil {
+		return err
+	}
+
+	// Find the most recent complete snapshot, if any. Segments before it
+	// are no longer needed, and a segment with an incomplete snapshot is
+	// the result of a crash during compaction, so is discarded.
+	start := 0
+	for i := len(segments) - 1; i >= 0; i-- {
+		records, _, err := readSegment(s.dir, segments[i])
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 || records[0].recordType != recordSnapshot {
+			continue
+		}
+		if !containsSnapshotEnd(records) {
+			if i != len(segments)-1 {
+				return ErrCorruptLog
+			}
+			if err := os.Remove(segmentPath(s.dir, segments[i])); err != nil {
+				return err
+			}
+			segments = segments[:i]
+			continue
+		}
+		start = i
+		break
+	}
+	for _, seq := range segments[:start] {
+		if err := os.Remove(segmentPath(s.dir, seq)); err != nil {
+			return err
+		}
+	}
+	segments = segments[start:]
+
+	for i, seq := range segments {
+		records, validSize, err := readSegment(s.dir, seq)
+		if err != nil {
+			return err
+		}
+		fileSize, err := fileSize(segmentPath(s.dir, seq))
+		if err != nil {
+			return err
+		}
+		last := i == len(segments)-1
+		if validSize != fileSize {
+			if !last {
+				return ErrCorruptLog
+			}
+			// the server crashed while writing the last record
+			if err := os.Truncate(segmentPath(s.dir, seq), validSize); err != nil {
+				return err
+			}
+		}
+
+		for _, r := range records {
+			switch r.recordType {
+			case recordEnqueue, recordRequeue:
+				s.insert(r.recordType, r.id, r.queue, r.frame, r.size)
+				if r.id >= s.nextId {
+					s.nextId = r.id + 1
+				}
+			case recordDequeue:
+				s.remove(r.id)
+			case recordSnapshot:
+				if r.id > s.nextId {
+					s.nextId = r.id
+				}
+			}
+		}
+		s.totalSize += validSize
+		if last {
+			s.fileSize = validSize
+		}
+	}
+
+	if len(segments) == 0 {
+		segments = append(segments, 1)
+		s.file, err = s.createSegment(1)
+	} else {
+		s.file, err = os.OpenFile(segmentPath(s.dir, segments[len(segments)-1]), os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	s.segments = segments
+	if err != nil {
+		return err
+	}
+	// flush the creation of the segment and the removal of
+	// segments that are no longer needed
+	return syncDir(s.dir)
+}
+
+// containsSnapshotEnd returns true if the snapshot at the start of a
+// segment is complete. Records appended after compaction follow the
+// snapshot end record.
+func containsSnapshotEnd(records []logRecord) bool {
+	for _, r := range records {
+		if r.recordType == recordSnapshotEnd {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FileQueueStorage) createSegment(seq uint64) (*os.File, error) {
+	return os.OpenFile(segmentPath(s.dir, seq), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016d.log", seq))
+}
+
+// listSegments returns the sequence numbers of the segment files in dir.
+func listSegments(dir string) ([]uint64, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+type logRecord struct {
+	recordType byte
+	id         uint64
+	queue      string
+	frame      *frame.Frame
+	size       int64
+}
+
+// readSegment reads the records in a segment file. Reading stops at the
+// first incomplete or invalid record. Returns the records, and the size of
+// the segment file up to the end of the last valid record.
+func readSegment(dir string, seq uint64) ([]logRecord, int64, error) {
+	data, err := ioutil.ReadFile(segmentPath(dir, seq))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []logRecord
+	offset := 0
+	for len(data)-offset >= recordHeaderSize {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		checksum := binary.BigEndian.Uint32(data[offset+4:])
+		end := offset + recordHeaderSize + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		payload := data[offset+recordHeaderSize : end]
+		if crc32.Checksum(payload, crcTable) != checksum {
+			break
+		}
+		r, ok := decodeRecord(payload)
+		if !ok {
+			break
+		}
+		r.size = int64(end - offset)
+		records = append(records, r)
+		offset = end
+	}
+	return records, int64(offset), nil
+}
+
+// encodeRecord encodes a log record, including the record header.
+func encodeRecord(recordType byte, id uint64, queue string, f *frame.Frame) []byte {
+	buf := make([]byte, recordHeaderSize, 64)
+	buf = append(buf, recordType)
+	buf = appendUvarint(buf, id)
+	buf = appendString(buf, queue)
+	if f != nil {
+		buf = appendString(buf, f.Command)
+		n := 0
+		if f.Header != nil {
+			n = f.Header.Len()
+		}
+		buf = appendUvarint(buf, uint64(n))
+		for i := 0; i < n; i++ {
+			key, value := f.Header.GetAt(i)
+			buf = appendString(buf, key)
+			buf = appendString(buf, value)
+		}
+		buf = appendUvarint(buf, uint64(len(f.Body)))
+		buf = append(buf, f.Body...)
+	}
+
+	payload := buf[recordHeaderSize:]
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(payload, crcTable))
+	return buf
+}
+
+// decodeRecord decodes the payload of a log record.
+func decodeRecord(payload []byte) (logRecord, bool) {
+	d := decoder{buf: payload}
+	r := logRecord{recordType: d.byte()}
+	r.id = d.uvarint()
+	r.queue = d.string()
+	if r.recordType == recordEnqueue || r.recordType == recordRequeue {
+		r.frame = frame.New(d.string())
+		n := d.uvarint()
+		for i := uint64(0); i < n && d.ok; i++ {
+			key := d.string()
+			value := d.string()
+			r.frame.Header.Add(key, value)
+		}
+		if body := d.bytes(); len(body) > 0 {
+			r.frame.Body = body
+		}
+	}
+	return r, d.ok && len(d.buf) == 0
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads values from a record payload. If the payload is
+// too short, ok is set to false and zero values are returned.
+type decoder struct {
+	buf []byte
+	ok  bool
+}
+
+func (d *decoder) byte() byte {
+	if len(d.buf) < 1 {
+		d.buf, d.ok = nil, false
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	d.ok = true
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.buf, d.ok = nil, false
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if !d.ok || uint64(len(d.buf)) < n {
+		d.buf, d.ok = nil, false
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.buf)
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
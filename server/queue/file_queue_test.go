@@ -0,0 +1,263 @@
+package queue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+	. "gopkg.in/check.v1"
+)
+
+type FileQueueSuite struct {
+	dir string
+}
+
+var _ = Suite(&FileQueueSuite{})
+
+func (s *FileQueueSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func newTestFrame(id string) *frame.Frame {
+	f := frame.New(frame.MESSAGE,
+		frame.Destination, "/queue/test",
+		frame.MessageId, id)
+	f.Body = []byte("body of " + id + "\x00with null")
+	return f
+}
+
+func (s *FileQueueSuite) open(c *C, options FileQueueOptions) *FileQueueStorage {
+	fq, err := NewFileQueueStorage(s.dir, options)
+	c.Assert(err, IsNil)
+	fq.Start()
+	return fq
+}
+
+// Dequeues and acknowledges the frames with the specified ids,
+// and checks that the queue is then empty.
+func checkDequeue(c *C, fq *FileQueueStorage, queue string, ids ...string) {
+	for _, id := range ids {
+		f, err := fq.Dequeue(queue)
+		c.Assert(err, IsNil)
+		c.Assert(f, NotNil)
+		c.Check(f.Header.Get(frame.MessageId), Equals, id)
+		c.Check(f.Body, DeepEquals, newTestFrame(id).Body)
+		c.Assert(fq.Ack(f), IsNil)
+	}
+	f, err := fq.Dequeue(queue)
+	c.Assert(err, IsNil)
+	c.Assert(f, IsNil)
+}
+
+func (s *FileQueueSuite) TestRecovery(c *C) {
+	fq := s.open(c, FileQueueOptions{})
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-1")), IsNil)
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-2")), IsNil)
+	c.Assert(fq.Enqueue("/queue/test2", newTestFrame("msg-3")), IsNil)
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-4")), IsNil)
+
+	// dequeue and then requeue, as happens when a client
+	// does not acknowledge the message
+	f, err := fq.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(f.Header.Get(frame.MessageId), Equals, "msg-1")
+	c.Assert(fq.Ack(f), IsNil)
+	f, err = fq.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(f.Header.Get(frame.MessageId), Equals, "msg-2")
+	c.Assert(fq.Requeue("/queue/test", f), IsNil)
+	fq.Stop()
+
+	_, err = fq.Dequeue("/queue/test")
+	c.Assert(err, Equals, ErrStorageClosed)
+
+	fq = s.open(c, FileQueueOptions{Sync: SyncInterval})
+	checkDequeue(c, fq, "/queue/test", "msg-2", "msg-4")
+	checkDequeue(c, fq, "/queue/test2", "msg-3")
+	fq.Stop()
+
+	fq = s.open(c, FileQueueOptions{})
+	checkDequeue(c, fq, "/queue/test")
+	fq.Stop()
+}
+
+func (s *FileQueueSuite) TestRecoverUnacknowledged(c *C) {
+	options := FileQueueOptions{SegmentSize: 512}
+	fq := s.open(c, options)
+	for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+		c.Assert(fq.Enqueue("/queue/test", newTestFrame(id)), IsNil)
+	}
+
+	// msg-1 is acknowledged, msg-2 is sent to a client but not
+	// acknowledged before the server stops
+	dequeueOne := func(id string) *frame.Frame {
+		f, err := fq.Dequeue("/queue/test")
+		c.Assert(err, IsNil)
+		c.Assert(f, NotNil)
+		c.Assert(f.Header.Get(frame.MessageId), Equals, id)
+		return f
+	}
+	c.Assert(fq.Ack(dequeueOne("msg-1")), IsNil)
+	f := dequeueOne("msg-2")
+	f.Header.Set(frame.Subscription, "1")
+
+	// in-flight frames survive compaction
+	for i := 0; i < 100; i++ {
+		c.Assert(fq.Enqueue("/queue/test2", newTestFrame(fmt.Sprint(i))), IsNil)
+		f, err := fq.Dequeue("/queue/test2")
+		c.Assert(err, IsNil)
+		c.Assert(fq.Ack(f), IsNil)
+	}
+	fq.Stop()
+
+	fq = s.open(c, options)
+	f = dequeueOne("msg-2")
+	c.Check(f.Header.Get(frame.Subscription), Equals, "")
+
+	// requeueing acknowledges the dequeued frame, leaving one copy
+	c.Assert(fq.Requeue("/queue/test", f), IsNil)
+	c.Assert(fq.Ack(f), IsNil)
+	fq.Stop()
+
+	fq = s.open(c, options)
+	checkDequeue(c, fq, "/queue/test", "msg-2", "msg-3")
+	checkDequeue(c, fq, "/queue/test2")
+	fq.Stop()
+}
+
+func (s *FileQueueSuite) TestTornWrite(c *C) {
+	fq := s.open(c, FileQueueOptions{Sync: SyncNever})
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-1")), IsNil)
+	fq.Stop()
+
+	// simulate a crash part way through writing a record
+	path := segmentPath(s.dir, 1)
+	good, err := fileSize(path)
+	c.Assert(err, IsNil)
+	record := encodeRecord(recordEnqueue, 2, "/queue/test", newTestFrame("msg-2"))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	_, err = file.Write(record[:len(record)-3])
+	c.Assert(err, IsNil)
+	file.Close()
+
+	fq = s.open(c, FileQueueOptions{})
+	size, err := fileSize(path)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, good)
+
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-3")), IsNil)
+	fq.Stop()
+
+	fq = s.open(c, FileQueueOptions{})
+	checkDequeue(c, fq, "/queue/test", "msg-1", "msg-3")
+	fq.Stop()
+}
+
+func (s *FileQueueSuite) TestCompaction(c *C) {
+	options := FileQueueOptions{SegmentSize: 512}
+	fq := s.open(c, options)
+
+	// these frames stay queued while many others come and go
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-1")), IsNil)
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-2")), IsNil)
+	for i := 0; i < 100; i++ {
+		c.Assert(fq.Enqueue("/queue/test2", newTestFrame(fmt.Sprint(i))), IsNil)
+		f, err := fq.Dequeue("/queue/test2")
+		c.Assert(err, IsNil)
+		c.Assert(fq.Ack(f), IsNil)
+	}
+
+	f1, err := fq.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	f2, err := fq.Dequeue("/queue/test")
+	c.Assert(err, IsNil)
+	c.Assert(fq.Requeue("/queue/test", f2), IsNil)
+	c.Assert(fq.Requeue("/queue/test", f1), IsNil)
+	c.Assert(fq.Enqueue("/queue/test2", newTestFrame("msg-3")), IsNil)
+	fq.Stop()
+
+	// without compaction, the first segment could never be deleted
+	files, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, IsNil)
+	c.Check(len(files) <= 2, Equals, true, Commentf("%d segment files", len(files)))
+
+	fq = s.open(c, options)
+	checkDequeue(c, fq, "/queue/test", "msg-1", "msg-2")
+	checkDequeue(c, fq, "/queue/test2", "msg-3")
+	fq.Stop()
+}
+
+func (s *FileQueueSuite) TestIncompleteCompaction(c *C) {
+	fq := s.open(c, FileQueueOptions{})
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-1")), IsNil)
+	fq.Stop()
+
+	// simulate a crash part way through writing a snapshot
+	snapshot := encodeRecord(recordSnapshot, 2, "", nil)
+	snapshot = append(snapshot, encodeRecord(recordEnqueue, 1, "/queue/test", newTestFrame("msg-1"))...)
+	err := ioutil.WriteFile(segmentPath(s.dir, 2), snapshot, 0644)
+	c.Assert(err, IsNil)
+
+	fq = s.open(c, FileQueueOptions{})
+	checkDequeue(c, fq, "/queue/test", "msg-1")
+	fq.Stop()
+
+	_, err = os.Stat(segmentPath(s.dir, 2))
+	c.Check(os.IsNotExist(err), Equals, true)
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.log"))
+	c.Assert(err, IsNil)
+	c.Check(matches, HasLen, 1)
+}
+
+func (s *FileQueueSuite) TestRemoveExpired(c *C) {
+	now := time.Now()
+	expired := newTestFrame("msg-2")
+	expired.Header.Set(frame.Expires, strconv.FormatInt(now.UnixMilli(), 10))
+
+	fq := s.open(c, FileQueueOptions{})
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-1")), IsNil)
+	c.Assert(fq.Enqueue("/queue/test", expired), IsNil)
+	c.Assert(fq.Enqueue("/queue/test", newTestFrame("msg-3")), IsNil)
+
+	removed, err := fq.RemoveExpired(now)
+	c.Assert(err, IsNil)
+	c.Assert(removed, HasLen, 1)
+	c.Check(removed[0].Queue, Equals, "/queue/test")
+	c.Check(removed[0].Frame.Header.Get(frame.MessageId), Equals, "msg-2")
+	c.Assert(fq.Close(), IsNil)
+
+	// the removal is recovered from the log
+	fq = s.open(c, FileQueueOptions{})
+	defer fq.Close()
+	checkDequeue(c, fq, "/queue/test", "msg-1", "msg-3")
+}
+
+func (s *FileQueueSuite) TestDequeueFunc(c *C) {
+	fq := s.open(c, FileQueueOptions{})
+	for _, id := range []string{"msg-1", "msg-2", "msg-3"} {
+		c.Assert(fq.Enqueue("/queue/test", newTestFrame(id)), IsNil)
+	}
+
+	match := func(f *frame.Frame) bool {
+		return f.Header.Get(frame.MessageId) == "msg-2"
+	}
+	f, err := fq.DequeueFunc("/queue/test", match)
+	c.Assert(err, IsNil)
+	c.Assert(f, NotNil)
+	c.Check(f.Header.Get(frame.MessageId), Equals, "msg-2")
+	c.Assert(fq.Ack(f), IsNil)
+	f, err = fq.DequeueFunc("/queue/test", match)
+	c.Assert(err, IsNil)
+	c.Check(f, IsNil)
+	c.Assert(fq.Close(), IsNil)
+
+	// the removal is recovered from the log
+	fq = s.open(c, FileQueueOptions{})
+	defer fq.Close()
+	checkDequeue(c, fq, "/queue/test", "msg-1", "msg-3")
+}
@@ -1,14 +1,57 @@
 package queue
 
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/metrics"
+)
+
+// Default prefix for dead-letter queue destinations. Messages that cannot
+// be delivered from "/queue/name" are moved to "/queue/DLQ.name".
+const DefaultDeadLetterPrefix = "/queue/DLQ."
+
 // Queue manager.
 type Manager struct {
 	qstore Storage // handles queue storage
 	queues map[string]*Queue
+
+	// MaxDeliveries is the number of times a message is delivered to
+	// clients before it is moved to a dead-letter queue, instead of
+	// being requeued when it is rejected or not acknowledged. If zero,
+	// messages are requeued indefinitely.
+	MaxDeliveries int
+
+	// DeadLetterPrefix is the prefix of dead-letter queue destinations.
+	// The dead-letter queue for "/queue/name" is the prefix followed by
+	// "name". If blank, DefaultDeadLetterPrefix is used.
+	DeadLetterPrefix string
+
+	// DeadLetterExpired specifies that expired messages are moved to
+	// the dead-letter queue. Otherwise expired messages are discarded.
+	DeadLetterExpired bool
+
+	// Logger is used to report messages moved to a dead-letter queue.
+	// If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	// Metrics records the queue depth, and the messages that are dead
+	// lettered or expire, for each queue. NewManager sets it to new
+	// metrics, which can be replaced before any queues are found.
+	Metrics *metrics.Metrics
 }
 
 // Create a queue manager with the specified queue storage mechanism
 func NewManager(qstore Storage) *Manager {
-	qm := &Manager{qstore: qstore, queues: make(map[string]*Queue)}
+	qm := &Manager{
+		qstore:  qstore,
+		queues:  make(map[string]*Queue),
+		Metrics: &metrics.Metrics{},
+	}
 	return qm
 }
 
@@ -16,8 +59,186 @@ func NewManager(qstore Storage) *Manager {
 func (qm *Manager) Find(destination string) *Queue {
 	q, ok := qm.queues[destination]
 	if !ok {
-		q = newQueue(destination, qm.qstore)
+		q = newQueue(destination, qm.qstore, qm)
 		qm.queues[destination] = q
 	}
 	return q
 }
+
+// UnsubscribeAll removes all subscriptions from all queues, so that
+// frames sent to the queues are stored rather than sent to clients,
+// such as when the server is stopping.
+func (qm *Manager) UnsubscribeAll() {
+	for _, q := range qm.queues {
+		q.unsubscribeAll()
+	}
+}
+
+// Removes expired frames from the queue storage, if the queue storage
+// supports it. Expired frames are discarded, or moved to dead-letter
+// queues if DeadLetterExpired is set.
+func (qm *Manager) Sweep(now time.Time) error {
+	qstore, ok := qm.qstore.(ExpiringStorage)
+	if !ok {
+		return nil
+	}
+	expired, err := qstore.RemoveExpired(now)
+	for _, e := range expired {
+		if expireErr := qm.expire(e.Queue, e.Frame); err == nil {
+			err = expireErr
+		}
+	}
+	return err
+}
+
+// Acknowledges a frame that has been removed from a queue and is no
+// longer needed, if the queue storage implements AcknowledgingStorage.
+// Frames are acknowledged when a client acknowledges them, and when they
+// are discarded by the queue manager.
+func (qm *Manager) Ack(f *frame.Frame) error {
+	return Ack(qm.qstore, f)
+}
+
+// Returns the dead-letter queue destination for destination, or a
+// blank string if destination is itself a dead-letter queue.
+func (qm *Manager) deadLetterDestination(destination string) string {
+	prefix := qm.DeadLetterPrefix
+	if prefix == "" {
+		prefix = DefaultDeadLetterPrefix
+	}
+	if strings.HasPrefix(destination, prefix) {
+		// messages in a dead-letter queue stay there
+		return ""
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(destination, "/queue"), "/")
+	return prefix + name
+}
+
+// Moves f to the dead-letter queue for destination if it has been
+// delivered MaxDeliveries times. Returns true if the frame was moved.
+func (qm *Manager) deadLetter(destination string, f *frame.Frame) (bool, error) {
+	if qm.MaxDeliveries <= 0 {
+		return false, nil
+	}
+	dlq := qm.deadLetterDestination(destination)
+	if dlq == "" {
+		return false, nil
+	}
+	count, _ := strconv.Atoi(f.Header.Get(frame.DeliveryCount))
+	if count < qm.MaxDeliveries {
+		return false, nil
+	}
+	qm.Metrics.MessagesDeadLettered.With(destination).Inc()
+	qm.logger().Warn("moved message to dead-letter queue",
+		"destination", destination,
+		"dead-letter", dlq,
+		"deliveries", count)
+	return true, qm.moveToDeadLetter(destination, dlq, f)
+}
+
+// Handles a frame from destination that has expired, by discarding it
+// or moving it to the dead-letter queue.
+func (qm *Manager) expire(destination string, f *frame.Frame) error {
+	qm.Metrics.MessagesExpired.With(destination).Inc()
+	dlq := ""
+	if qm.DeadLetterExpired {
+		dlq = qm.deadLetterDestination(destination)
+	}
+	if dlq == "" {
+		qm.logger().Debug("discarded expired message",
+			"destination", destination)
+		return qm.Ack(f)
+	}
+	qm.logger().Debug("moved expired message to dead-letter queue",
+		"destination", destination,
+		"dead-letter", dlq)
+	return qm.moveToDeadLetter(destination, dlq, f)
+}
+
+// ErrNotSupported is returned by Browse when the queue storage
+// does not implement BrowsingStorage.
+var ErrNotSupported = errors.New("not supported by queue storage")
+
+// Purge removes all frames waiting in the queue for destination, and
+// returns the number of frames removed. Frames that have been sent to
+// subscriptions, but not acknowledged, are not affected.
+func (qm *Manager) Purge(destination string) (int, error) {
+	count := 0
+	for {
+		f, err := qm.qstore.Dequeue(destination)
+		if f == nil || err != nil {
+			return count, err
+		}
+		qm.Metrics.QueueDepth.With(destination).Dec()
+		count++
+		if err := qm.Ack(f); err != nil {
+			return count, err
+		}
+	}
+}
+
+// Move moves up to max frames waiting in the queue for destination
+// (all frames if max is zero) to the queue for target, as if they were
+// sent to target by a client, and returns the number of frames moved.
+// The delivery count of each frame is reset, so that frames can be
+// moved from a dead-letter queue to be delivered again.
+func (qm *Manager) Move(destination, target string, max int) (int, error) {
+	if destination == target {
+		return 0, errors.New("cannot move frames to the same queue")
+	}
+	to := qm.Find(target)
+	count := 0
+	for max <= 0 || count < max {
+		f, err := qm.qstore.Dequeue(destination)
+		if f == nil || err != nil {
+			return count, err
+		}
+		qm.Metrics.QueueDepth.With(destination).Dec()
+		f.Header.Set(frame.Destination, target)
+		f.Header.Del(frame.DeliveryCount)
+		f.Header.Del(frame.Redelivered)
+		f.Header.Del(frame.MessageId)
+		f.Header.Del(frame.Subscription)
+		f.Header.Del(frame.Ack)
+		if err := to.Enqueue(f); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Browse returns copies of up to max frames from the head of the queue
+// for destination, without removing them. Returns ErrNotSupported if the
+// queue storage does not implement BrowsingStorage.
+func (qm *Manager) Browse(destination string, max int) ([]*frame.Frame, error) {
+	qstore, ok := qm.qstore.(BrowsingStorage)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	frames, err := qstore.Browse(destination, max)
+	for i, f := range frames {
+		frames[i] = f.Clone()
+	}
+	return frames, err
+}
+
+func (qm *Manager) moveToDeadLetter(destination, dlq string, f *frame.Frame) error {
+	// the message starts afresh in the dead-letter queue
+	f.Header.Set(frame.OriginalDestination, destination)
+	f.Header.Set(frame.Destination, dlq)
+	f.Header.Del(frame.Expires)
+	f.Header.Del(frame.DeliveryCount)
+	f.Header.Del(frame.Redelivered)
+	f.Header.Del(frame.MessageId)
+	f.Header.Del(frame.Subscription)
+	f.Header.Del(frame.Ack)
+	return qm.Find(dlq).Enqueue(f)
+}
+
+func (qm *Manager) logger() *slog.Logger {
+	if qm.Logger == nil {
+		return slog.Default()
+	}
+	return qm.Logger
+}
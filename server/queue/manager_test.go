@@ -1,6 +1,10 @@
 package queue
 
 import (
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
 	. "gopkg.in/check.v1"
 )
 
@@ -19,3 +23,115 @@ func (s *ManagerSuite) TestManager(c *C) {
 
 	c.Assert(mgr.Find("/queue/1"), Equals, q1)
 }
+
+func (s *ManagerSuite) TestSweep(c *C) {
+	now := time.Now()
+	past := strconv.FormatInt(now.Add(-time.Second).UnixMilli(), 10)
+	future := strconv.FormatInt(now.Add(time.Hour).UnixMilli(), 10)
+
+	for _, deadLetter := range []bool{false, true} {
+		qstore := NewMemoryQueueStorage()
+		qstore.Start()
+		mgr := NewManager(qstore)
+		mgr.DeadLetterExpired = deadLetter
+
+		qstore.Enqueue("/queue/1", frame.New(frame.MESSAGE,
+			frame.Destination, "/queue/1",
+			frame.Expires, past))
+		qstore.Enqueue("/queue/1", frame.New(frame.MESSAGE,
+			frame.Destination, "/queue/1",
+			frame.Expires, future))
+		qstore.Enqueue("/queue/1", frame.New(frame.MESSAGE,
+			frame.Destination, "/queue/1",
+			frame.Expires, "0"))
+
+		// the frame is attributed to its queue, not its destination
+		qstore.Enqueue("/durable/client/sub", frame.New(frame.MESSAGE,
+			frame.Destination, "/topic/1",
+			frame.Expires, past))
+		c.Assert(mgr.Sweep(now), IsNil)
+		c.Check(mgr.Metrics.MessagesExpired.Values(), DeepEquals, map[string]int64{
+			"/queue/1":            1,
+			"/durable/client/sub": 1,
+		})
+
+		f, err := qstore.Dequeue("/queue/1")
+		c.Assert(err, IsNil)
+		c.Check(f.Header.Get(frame.Expires), Equals, future)
+		f, err = qstore.Dequeue("/queue/1")
+		c.Assert(err, IsNil)
+		c.Check(f.Header.Get(frame.Expires), Equals, "0")
+		f, err = qstore.Dequeue("/queue/1")
+		c.Assert(err, IsNil)
+		c.Check(f, IsNil)
+
+		f, err = qstore.Dequeue("/queue/DLQ.1")
+		c.Assert(err, IsNil)
+		if deadLetter {
+			c.Assert(f, NotNil)
+			c.Check(f.Header.Get(frame.Destination), Equals, "/queue/DLQ.1")
+			c.Check(f.Header.Get(frame.OriginalDestination), Equals, "/queue/1")
+			_, ok := f.Header.Contains(frame.Expires)
+			c.Check(ok, Equals, false)
+		} else {
+			c.Check(f, IsNil)
+		}
+
+		f, err = qstore.Dequeue("/queue/DLQ.durable/client/sub")
+		c.Assert(err, IsNil)
+		if deadLetter {
+			c.Assert(f, NotNil)
+			c.Check(f.Header.Get(frame.OriginalDestination), Equals, "/durable/client/sub")
+		} else {
+			c.Check(f, IsNil)
+		}
+	}
+}
+
+func (s *ManagerSuite) TestPurgeMoveBrowse(c *C) {
+	qstore := NewMemoryQueueStorage()
+	qstore.Start()
+	mgr := NewManager(qstore)
+
+	for i := 1; i <= 4; i++ {
+		c.Assert(mgr.Find("/queue/DLQ.1").Enqueue(frame.New(frame.MESSAGE,
+			frame.Destination, "/queue/DLQ.1",
+			frame.DeliveryCount, "5",
+			"n", strconv.Itoa(i))), IsNil)
+	}
+	c.Check(mgr.Metrics.QueueDepth.With("/queue/DLQ.1").Value(), Equals, int64(4))
+
+	frames, err := mgr.Browse("/queue/DLQ.1", 2)
+	c.Assert(err, IsNil)
+	c.Assert(frames, HasLen, 2)
+	c.Check(frames[0].Header.Get("n"), Equals, "1")
+	c.Check(frames[1].Header.Get("n"), Equals, "2")
+
+	// browsing returns copies
+	frames[0].Header.Set("n", "changed")
+	frames, err = mgr.Browse("/queue/DLQ.1", 10)
+	c.Assert(err, IsNil)
+	c.Assert(frames, HasLen, 4)
+	c.Check(frames[0].Header.Get("n"), Equals, "1")
+
+	moved, err := mgr.Move("/queue/DLQ.1", "/queue/1", 3)
+	c.Assert(err, IsNil)
+	c.Check(moved, Equals, 3)
+	f, err := qstore.Dequeue("/queue/1")
+	c.Assert(err, IsNil)
+	c.Check(f.Header.Get(frame.Destination), Equals, "/queue/1")
+	c.Check(f.Header.Get("n"), Equals, "1")
+	_, ok := f.Header.Contains(frame.DeliveryCount)
+	c.Check(ok, Equals, false)
+
+	_, err = mgr.Move("/queue/1", "/queue/1", 0)
+	c.Check(err, NotNil)
+
+	purged, err := mgr.Purge("/queue/DLQ.1")
+	c.Assert(err, IsNil)
+	c.Check(purged, Equals, 1)
+	c.Check(mgr.Metrics.QueueDepth.With("/queue/DLQ.1").Value(), Equals, int64(0))
+	frames, err = mgr.Browse("/queue/DLQ.1", 10)
+	c.Assert(err, IsNil)
+	c.Check(frames, HasLen, 0)
+}
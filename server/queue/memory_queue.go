@@ -2,6 +2,7 @@ package queue
 
 import (
 	"container/list"
+	"time"
 
 	"github.com/go-stomp/stomp/frame"
 )
@@ -57,6 +58,66 @@ func (m *MemoryQueueStorage) Dequeue(queue string) (*frame.Frame, error) {
 	return l.Remove(element).(*frame.Frame), nil
 }
 
+// Removes the first frame in the queue for which match returns true.
+// Returns nil if there is no such frame.
+func (m *MemoryQueueStorage) DequeueFunc(queue string, match func(f *frame.Frame) bool) (*frame.Frame, error) {
+	l, ok := m.lists[queue]
+	if !ok {
+		return nil, nil
+	}
+
+	for e := l.Front(); e != nil; e = e.Next() {
+		if f := e.Value.(*frame.Frame); match(f) {
+			l.Remove(e)
+			return f, nil
+		}
+	}
+	return nil, nil
+}
+
+// Removes all frames that have expired at time now,
+// and returns the removed frames.
+func (m *MemoryQueueStorage) RemoveExpired(now time.Time) ([]ExpiredFrame, error) {
+	var expired []ExpiredFrame
+	for queue, l := range m.lists {
+		for e := l.Front(); e != nil; {
+			next := e.Next()
+			if f := e.Value.(*frame.Frame); Expired(f, now) {
+				l.Remove(e)
+				expired = append(expired, ExpiredFrame{Queue: queue, Frame: f})
+			}
+			e = next
+		}
+	}
+	return expired, nil
+}
+
+// Returns up to max frames from the head of the queue,
+// without removing them.
+func (m *MemoryQueueStorage) Browse(queue string, max int) ([]*frame.Frame, error) {
+	l, ok := m.lists[queue]
+	if !ok {
+		return nil, nil
+	}
+
+	var frames []*frame.Frame
+	for e := l.Front(); e != nil && len(frames) < max; e = e.Next() {
+		frames = append(frames, e.Value.(*frame.Frame))
+	}
+	return frames, nil
+}
+
+// Returns the number of frames in each queue that is not empty.
+func (m *MemoryQueueStorage) Counts() map[string]int {
+	counts := make(map[string]int)
+	for queue, l := range m.lists {
+		if l.Len() > 0 {
+			counts[queue] = l.Len()
+		}
+	}
+	return counts
+}
+
 // Called at server startup. Allows the queue storage
 // to perform any initialization.
 func (m *MemoryQueueStorage) Start() {
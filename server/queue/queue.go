@@ -4,6 +4,8 @@ Package queue provides implementations of server-side queues.
 package queue
 
 import (
+	"time"
+
 	"github.com/go-stomp/stomp/frame"
 	"github.com/go-stomp/stomp/server/client"
 )
@@ -12,75 +14,195 @@ import (
 type Queue struct {
 	destination string
 	qstore      Storage
-	subs        *client.SubscriptionList
+	subs        *client.SubscriptionList     // subscriptions ready to receive a frame
+	inFlight    map[*client.Subscription]int // number of unacknowledged frames
+	qm          *Manager
 }
 
 // Create a new queue -- called from the queue manager only.
-func newQueue(destination string, qstore Storage) *Queue {
+func newQueue(destination string, qstore Storage, qm *Manager) *Queue {
 	return &Queue{
 		destination: destination,
 		qstore:      qstore,
 		subs:        client.NewSubscriptionList(),
+		inFlight:    make(map[*client.Subscription]int),
+		qm:          qm,
 	}
 }
 
-// Add a subscription to a queue. The subscription is removed
-// whenever a frame is sent to the subscription and needs to
-// be re-added when the subscription decides that the message
-// has been received by the client.
+// Add a subscription to a queue. Frames are sent to the subscription
+// until it has the number of unacknowledged frames specified by its
+// prefetch count. Once this limit is reached, the subscription does not
+// receive another frame until Ready is called.
 func (q *Queue) Subscribe(sub *client.Subscription) error {
-	// see if there is a frame available for this subscription
-	f, err := q.qstore.Dequeue(sub.Destination())
-	if err != nil {
-		return err
+	q.inFlight[sub] = 0
+	return q.dispatch(sub)
+}
+
+// Indicate that a frame sent to the subscription has been acknowledged
+// (or rejected) by the client, so that the subscription is ready to
+// receive another frame. Has no effect if the subscription has been
+// unsubscribed.
+func (q *Queue) Ready(sub *client.Subscription) error {
+	n, ok := q.inFlight[sub]
+	if !ok || n == 0 {
+		return nil
 	}
-	if f == nil {
-		// no frame available, so add to the subscription list
-		q.subs.Add(sub)
-	} else {
-		// a frame is available, so send straight away without
-		// adding the subscription to the list
-		sub.SendQueueFrame(f)
+	q.inFlight[sub] = n - 1
+	if n < sub.Prefetch() {
+		// the subscription is already in the list
+		return nil
 	}
-	return nil
+	return q.dispatch(sub)
 }
 
 // Unsubscribe a subscription.
 func (q *Queue) Unsubscribe(sub *client.Subscription) {
 	q.subs.Remove(sub)
+	delete(q.inFlight, sub)
 }
 
-// Send a message to the queue. If a subscription is available
-// to receive the message, it is sent to the subscription without
-// making it to the queue. Otherwise, the message is queued until
-// a message is available.
+// Send a message to the queue. The message is added to the queue
+// storage, and if a subscription is available to receive the message,
+// it is then sent to the subscription. Storing the message first means
+// that queue storage that keeps messages until they are acknowledged
+// does not lose a message sent to a client that has not acknowledged
+// it. Expired messages are discarded, or moved to the dead-letter queue
+// if the queue manager specifies it.
 func (q *Queue) Enqueue(f *frame.Frame) error {
-	// find a subscription ready to receive the frame
-	sub := q.subs.Get()
-	if sub == nil {
-		// no subscription available, add to the queue
-		return q.qstore.Enqueue(q.destination, f)
-	} else {
-		// subscription is available, send it now without adding to queue
-		sub.SendQueueFrame(f)
+	if Expired(f, time.Now()) {
+		return q.qm.expire(q.destination, f)
 	}
-	return nil
+	if err := q.store(f, false); err != nil {
+		return err
+	}
+	return q.dispatchTo(f)
 }
 
 // Send a message to the front of the queue, probably because it
-// failed to be sent to a client. If a subscription is available
-// to receive the message, it is sent to the subscription without
-// making it to the queue. Otherwise, the message is queued until
-// a message is available.
+// failed to be sent to a client. As for Enqueue, the message is
+// added to the queue storage, and then sent to a subscription if one
+// is available. If the message has been delivered the maximum number
+// of times allowed by the queue manager, it is moved to the
+// dead-letter queue instead.
 func (q *Queue) Requeue(f *frame.Frame) error {
-	// find a subscription ready to receive the frame
-	sub := q.subs.Get()
+	if moved, err := q.qm.deadLetter(q.destination, f); moved {
+		return err
+	}
+	if Expired(f, time.Now()) {
+		return q.qm.expire(q.destination, f)
+	}
+	if err := q.store(f, true); err != nil {
+		return err
+	}
+	return q.dispatchTo(f)
+}
+
+// Remove all subscriptions, so that frames sent to the queue are
+// stored until it is subscribed to again.
+func (q *Queue) unsubscribeAll() {
+	q.subs = client.NewSubscriptionList()
+	q.inFlight = make(map[*client.Subscription]int)
+}
+
+// Send queued frames to a subscription that is ready to receive f,
+// which has just been stored, if there is one.
+func (q *Queue) dispatchTo(f *frame.Frame) error {
+	sub := q.subs.GetSelecting(f)
 	if sub == nil {
-		// no subscription available, add to the queue
-		return q.qstore.Requeue(q.destination, f)
-	} else {
-		// subscription is available, send it now without adding to queue
+		// no subscription available, so f stays in the queue
+		return nil
+	}
+	// The subscription was only ready because no frame in the queue
+	// was selected by it, so it is sent f.
+	return q.dispatch(sub)
+}
+
+// Send queued frames to the subscription until it reaches its prefetch
+// count, or there are no more frames. In the latter case the
+// subscription is added to the list of subscriptions ready to receive.
+func (q *Queue) dispatch(sub *client.Subscription) error {
+	for q.inFlight[sub] < sub.Prefetch() {
+		f, err := q.dequeue(sub)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			// no frame available, so add to the subscription list
+			q.subs.Add(sub)
+			return nil
+		}
+		if Expired(f, time.Now()) {
+			if err := q.qm.expire(q.destination, f); err != nil {
+				return err
+			}
+			continue
+		}
+		q.inFlight[sub]++
+		q.delivered(f)
 		sub.SendQueueFrame(f)
 	}
 	return nil
 }
+
+// Adds a frame to the queue storage, at the head of the queue if
+// requeue is true, otherwise at the end.
+func (q *Queue) store(f *frame.Frame, requeue bool) error {
+	var err error
+	if requeue {
+		err = q.qstore.Requeue(q.destination, f)
+	} else {
+		err = q.qstore.Enqueue(q.destination, f)
+	}
+	if err == nil {
+		q.qm.Metrics.QueueDepth.With(q.destination).Inc()
+	}
+	return err
+}
+
+// Removes the first frame in the queue that is selected by the
+// subscription. Returns nil if there is no such frame.
+func (q *Queue) dequeue(sub *client.Subscription) (*frame.Frame, error) {
+	f, err := q.dequeueSelected(sub)
+	if f != nil {
+		q.qm.Metrics.QueueDepth.With(q.destination).Dec()
+	}
+	return f, err
+}
+
+func (q *Queue) dequeueSelected(sub *client.Subscription) (*frame.Frame, error) {
+	if sub.Selector() == nil {
+		return q.qstore.Dequeue(q.destination)
+	}
+	if qstore, ok := q.qstore.(SelectiveStorage); ok {
+		return qstore.DequeueFunc(q.destination, sub.Selects)
+	}
+
+	// Search the queue, then return the frames that
+	// were not selected to the front in the same order.
+	var skipped []*frame.Frame
+	defer func() {
+		for i := len(skipped) - 1; i >= 0; i-- {
+			q.qstore.Requeue(q.destination, skipped[i])
+		}
+	}()
+	for {
+		f, err := q.qstore.Dequeue(q.destination)
+		if f == nil || err != nil {
+			return nil, err
+		}
+		if sub.Selects(f) {
+			return f, nil
+		}
+		skipped = append(skipped, f)
+	}
+}
+
+// Counts a frame sent to a subscription. Frames that have been sent
+// before have a delivery-count header.
+func (q *Queue) delivered(f *frame.Frame) {
+	q.qm.Metrics.MessagesDelivered.With(q.destination).Inc()
+	if _, ok := f.Header.Contains(frame.DeliveryCount); ok {
+		q.qm.Metrics.MessagesRedelivered.With(q.destination).Inc()
+	}
+}
@@ -1,6 +1,9 @@
 package queue
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/go-stomp/stomp/frame"
 )
 
@@ -32,3 +35,101 @@ type Storage interface {
 	// to perform any cleanup.
 	Stop()
 }
+
+// Interface implemented by queue storage that keeps the frames removed
+// by Dequeue (or DequeueFunc) until they are acknowledged, so that frames
+// sent to clients are not lost if the server stops before the clients
+// acknowledge them. A frame that has been removed is acknowledged by
+// calling Ack, or by passing it to Enqueue or Requeue. Frames that have
+// not been acknowledged when the server stops are returned to their
+// queues when the storage is next used.
+type AcknowledgingStorage interface {
+	Storage
+
+	// Indicates that a frame returned by Dequeue is no longer needed,
+	// because it has been acknowledged by a client or discarded. Does
+	// nothing if the frame was not returned by Dequeue, or has already
+	// been acknowledged.
+	Ack(frame *frame.Frame) error
+}
+
+// Ack acknowledges a frame returned by the Dequeue method of qstore, if
+// qstore implements AcknowledgingStorage. Otherwise it does nothing.
+func Ack(qstore Storage, f *frame.Frame) error {
+	if f == nil {
+		return nil
+	}
+	if qstore, ok := qstore.(AcknowledgingStorage); ok {
+		return qstore.Ack(f)
+	}
+	return nil
+}
+
+// Interface implemented by queue storage that can remove expired
+// frames from anywhere in a queue. The server calls RemoveExpired
+// periodically, so that expired frames do not accumulate in queues
+// that are not being consumed. Frames are also checked for expiry
+// as they are dequeued, so implementing this interface is optional.
+type ExpiringStorage interface {
+	Storage
+
+	// Removes all frames that have expired at time now from all
+	// queues, and returns the removed frames.
+	RemoveExpired(now time.Time) ([]ExpiredFrame, error)
+}
+
+// A frame removed by ExpiringStorage.RemoveExpired, and the name of the
+// queue that it was removed from. The queue name can differ from the
+// destination of the frame, such as for durable subscription queues.
+type ExpiredFrame struct {
+	Queue string
+	Frame *frame.Frame
+}
+
+// Interface implemented by queue storage that can remove the first
+// frame in a queue that satisfies a condition. This is used to send
+// frames to subscriptions with a message selector. Queue storage that
+// does not implement this interface is searched by dequeuing frames
+// and requeuing the frames that are not selected.
+type SelectiveStorage interface {
+	Storage
+
+	// Removes the first frame in the queue for which match returns
+	// true. Returns nil if there is no such frame.
+	DequeueFunc(queue string, match func(f *frame.Frame) bool) (*frame.Frame, error)
+}
+
+// Interface implemented by queue storage that can count the frames in
+// each queue. The server uses it to report the depth of each queue when
+// it starts, and after removing expired frames.
+type CountingStorage interface {
+	Storage
+
+	// Returns the number of frames in each queue that is not empty.
+	Counts() map[string]int
+}
+
+// Interface implemented by queue storage that can return the frames in
+// a queue without removing them. This is used for inspecting queues.
+type BrowsingStorage interface {
+	Storage
+
+	// Returns up to max frames from the head of the queue, in order,
+	// without removing them. The frames must not be modified.
+	Browse(queue string, max int) ([]*frame.Frame, error)
+}
+
+// Expired returns true if the frame has an "expires" header, containing
+// the time in milliseconds since the UNIX epoch, that is before now.
+// A value of zero means that the frame never expires.
+func Expired(f *frame.Frame, now time.Time) bool {
+	value, ok := f.Header.Contains(frame.Expires)
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || expires <= 0 {
+		return false
+	}
+	return now.UnixMilli() >= expires
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package queue
+
+import (
+	"os"
+)
+
+// syncDir flushes a directory to stable storage, so that files created,
+// renamed or removed in it survive an operating system crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,241 @@
+package selector
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/go-stomp/stomp/frame"
+)
+
+type kind int
+
+const (
+	kindNull kind = iota // missing header, or unknown result
+	kindString
+	kindNumber
+	kindBool
+)
+
+// Value of an operand, or the result of evaluating an expression.
+type value struct {
+	kind kind
+	s    string
+	n    float64
+	b    bool
+}
+
+var null = value{}
+
+func stringValue(s string) value  { return value{kind: kindString, s: s} }
+func numberValue(n float64) value { return value{kind: kindNumber, n: n} }
+func boolValue(b bool) value      { return value{kind: kindBool, b: b} }
+
+// Converts v to a number. Header values are strings, so are parsed.
+func (v value) number() (float64, bool) {
+	switch v.kind {
+	case kindNumber:
+		return v.n, true
+	case kindString:
+		n, err := strconv.ParseFloat(v.s, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Converts v to a boolean, which is unknown (null) if v is not a
+// boolean, or a string "true" or "false".
+func (v value) boolean() value {
+	switch v.kind {
+	case kindBool:
+		return v
+	case kindString:
+		if b, err := strconv.ParseBool(v.s); err == nil {
+			return boolValue(b)
+		}
+	}
+	return null
+}
+
+// Node in the parsed expression tree.
+type node interface {
+	eval(h *frame.Header) value
+}
+
+type identNode string
+
+func (n identNode) eval(h *frame.Header) value {
+	if s, ok := h.Contains(string(n)); ok {
+		return stringValue(s)
+	}
+	return null
+}
+
+type literalNode struct {
+	v value
+}
+
+func (n literalNode) eval(h *frame.Header) value {
+	return n.v
+}
+
+type notNode struct {
+	n node
+}
+
+func (n notNode) eval(h *frame.Header) value {
+	v := n.n.eval(h).boolean()
+	if v.kind == kindNull {
+		return null
+	}
+	return boolValue(!v.b)
+}
+
+// Three-valued AND: false if either side is false.
+type andNode struct {
+	left, right node
+}
+
+func (n andNode) eval(h *frame.Header) value {
+	left := n.left.eval(h).boolean()
+	if left.kind == kindBool && !left.b {
+		return left
+	}
+	right := n.right.eval(h).boolean()
+	if right.kind == kindBool && !right.b {
+		return right
+	}
+	if left.kind == kindNull || right.kind == kindNull {
+		return null
+	}
+	return boolValue(true)
+}
+
+// Three-valued OR: true if either side is true.
+type orNode struct {
+	left, right node
+}
+
+func (n orNode) eval(h *frame.Header) value {
+	left := n.left.eval(h).boolean()
+	if left.kind == kindBool && left.b {
+		return left
+	}
+	right := n.right.eval(h).boolean()
+	if right.kind == kindBool && right.b {
+		return right
+	}
+	if left.kind == kindNull || right.kind == kindNull {
+		return null
+	}
+	return boolValue(false)
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(h *frame.Header) value {
+	left, right := n.left.eval(h), n.right.eval(h)
+	switch n.op {
+	case "=":
+		return equal(left, right)
+	case "<>":
+		v := equal(left, right)
+		if v.kind == kindBool {
+			v.b = !v.b
+		}
+		return v
+	}
+
+	// ordering comparisons are numeric
+	a, ok := left.number()
+	if !ok {
+		return null
+	}
+	b, ok := right.number()
+	if !ok {
+		return null
+	}
+	switch n.op {
+	case "<":
+		return boolValue(a < b)
+	case "<=":
+		return boolValue(a <= b)
+	case ">":
+		return boolValue(a > b)
+	case ">=":
+		return boolValue(a >= b)
+	}
+	return null
+}
+
+// Compares two values for equality. A string is converted to the type
+// of the other value when it is a number or a boolean.
+func equal(left, right value) value {
+	if left.kind == kindNull || right.kind == kindNull {
+		return null
+	}
+	if left.kind == kindNumber || right.kind == kindNumber {
+		a, ok := left.number()
+		if !ok {
+			return null
+		}
+		b, ok := right.number()
+		if !ok {
+			return null
+		}
+		return boolValue(a == b)
+	}
+	if left.kind == kindBool || right.kind == kindBool {
+		a, b := left.boolean(), right.boolean()
+		if a.kind == kindNull || b.kind == kindNull {
+			return null
+		}
+		return boolValue(a.b == b.b)
+	}
+	return boolValue(left.s == right.s)
+}
+
+type inNode struct {
+	n      node
+	values []value
+}
+
+func (n inNode) eval(h *frame.Header) value {
+	v := n.n.eval(h)
+	if v.kind == kindNull {
+		return null
+	}
+	result := boolValue(false)
+	for _, item := range n.values {
+		switch eq := equal(v, item); {
+		case eq.kind == kindBool && eq.b:
+			return eq
+		case eq.kind == kindNull:
+			result = null
+		}
+	}
+	return result
+}
+
+type likeNode struct {
+	n  node
+	re *regexp.Regexp
+}
+
+func (n likeNode) eval(h *frame.Header) value {
+	v := n.n.eval(h)
+	if v.kind != kindString {
+		return null
+	}
+	return boolValue(n.re.MatchString(v.s))
+}
+
+type isNullNode struct {
+	n node
+}
+
+func (n isNullNode) eval(h *frame.Header) value {
+	return boolValue(n.n.eval(h).kind == kindNull)
+}
@@ -0,0 +1,168 @@
+package selector
+
+import (
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator // =, <>, <, <=, >, >=
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+	tokenKeyword // AND, OR, NOT, IN, LIKE, ESCAPE, IS, NULL, BETWEEN, TRUE, FALSE
+)
+
+// Reserved words, which are not case-sensitive.
+var keywords = map[string]bool{
+	"AND":     true,
+	"OR":      true,
+	"NOT":     true,
+	"IN":      true,
+	"LIKE":    true,
+	"ESCAPE":  true,
+	"IS":      true,
+	"NULL":    true,
+	"BETWEEN": true,
+	"TRUE":    true,
+	"FALSE":   true,
+}
+
+type token struct {
+	typ    tokenType
+	text   string // upper case for keywords, unquoted for strings
+	offset int    // offset of the token in the expression
+}
+
+// Splits a selector expression into tokens.
+type lexer struct {
+	input  string
+	offset int
+}
+
+// Returns the next token in the input.
+func (l *lexer) next() (token, error) {
+	for l.offset < len(l.input) && isSpace(l.input[l.offset]) {
+		l.offset++
+	}
+	start := l.offset
+	if start == len(l.input) {
+		return token{typ: tokenEOF, offset: start}, nil
+	}
+
+	c := l.input[start]
+	switch {
+	case c == '(':
+		l.offset++
+		return token{typ: tokenLeftParen, text: "(", offset: start}, nil
+	case c == ')':
+		l.offset++
+		return token{typ: tokenRightParen, text: ")", offset: start}, nil
+	case c == ',':
+		l.offset++
+		return token{typ: tokenComma, text: ",", offset: start}, nil
+	case c == '=':
+		l.offset++
+		return token{typ: tokenOperator, text: "=", offset: start}, nil
+	case c == '<' || c == '>':
+		l.offset++
+		if l.offset < len(l.input) {
+			if d := l.input[l.offset]; d == '=' || (c == '<' && d == '>') {
+				l.offset++
+			}
+		}
+		return token{typ: tokenOperator, text: l.input[start:l.offset], offset: start}, nil
+	case c == '\'':
+		return l.quoted('\'', tokenString)
+	case c == '"':
+		// quoted identifier, for header names that are not
+		// valid identifiers, or are reserved words
+		return l.quoted('"', tokenIdent)
+	case isDigit(c) || c == '-' || c == '+' || c == '.':
+		return l.number()
+	case isIdentStart(c):
+		for l.offset < len(l.input) && isIdentPart(l.input[l.offset]) {
+			l.offset++
+		}
+		text := l.input[start:l.offset]
+		if upper := strings.ToUpper(text); keywords[upper] {
+			return token{typ: tokenKeyword, text: upper, offset: start}, nil
+		}
+		return token{typ: tokenIdent, text: text, offset: start}, nil
+	}
+	return token{}, syntaxError(start, "unexpected character %q", c)
+}
+
+// Reads a string or identifier delimited by quote. The quote character
+// is included in the value by doubling it, as in SQL.
+func (l *lexer) quoted(quote byte, typ tokenType) (token, error) {
+	start := l.offset
+	var sb strings.Builder
+	for i := start + 1; i < len(l.input); i++ {
+		c := l.input[i]
+		if c != quote {
+			sb.WriteByte(c)
+			continue
+		}
+		if i+1 < len(l.input) && l.input[i+1] == quote {
+			sb.WriteByte(quote)
+			i++
+			continue
+		}
+		l.offset = i + 1
+		return token{typ: typ, text: sb.String(), offset: start}, nil
+	}
+	return token{}, syntaxError(start, "unterminated quoted string")
+}
+
+// Reads a numeric literal, with an optional sign, fraction and exponent.
+func (l *lexer) number() (token, error) {
+	start := l.offset
+	i := start
+	if c := l.input[i]; c == '-' || c == '+' {
+		i++
+	}
+	digits := 0
+	for ; i < len(l.input) && (isDigit(l.input[i]) || l.input[i] == '.'); i++ {
+		if l.input[i] != '.' {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return token{}, syntaxError(start, "invalid number")
+	}
+	if i < len(l.input) && (l.input[i] == 'e' || l.input[i] == 'E') {
+		i++
+		if i < len(l.input) && (l.input[i] == '-' || l.input[i] == '+') {
+			i++
+		}
+		for ; i < len(l.input) && isDigit(l.input[i]); i++ {
+		}
+	}
+	l.offset = i
+	return token{typ: tokenNumber, text: l.input[start:i], offset: start}, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
+
+// Identifiers can contain '-', '.' and ':', which are common in STOMP
+// header names (eg "content-type"). There are no arithmetic operators,
+// so this is not ambiguous.
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '-' || c == '.' || c == ':'
+}
@@ -0,0 +1,359 @@
+package selector
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum nesting of parentheses and NOT operators, which limits the
+// recursion when parsing expressions supplied by clients.
+const maxDepth = 100
+
+// Recursive descent parser for selector expressions:
+//
+//	expr       = and { OR and }
+//	and        = not { AND not }
+//	not        = NOT not | predicate
+//	predicate  = operand [ comparison ]
+//	comparison = op operand
+//	           | [ NOT ] IN ( literal { , literal } )
+//	           | [ NOT ] LIKE string [ ESCAPE string ]
+//	           | [ NOT ] BETWEEN operand AND operand
+//	           | IS [ NOT ] NULL
+//	operand    = identifier | literal | ( expr )
+//	literal    = string | number | TRUE | FALSE
+type parser struct {
+	lexer lexer
+	tok   token // current token
+	depth int
+}
+
+func (p *parser) parse() (node, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.typ == tokenEOF {
+		return nil, syntaxError(p.tok.offset, "empty expression")
+	}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.typ != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return n, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// Returns true, and advances to the next token, if the current
+// token is the keyword.
+func (p *parser) keyword(keyword string) (bool, error) {
+	if p.tok.typ != tokenKeyword || p.tok.text != keyword {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(typ tokenType, what string) error {
+	if p.tok.typ != typ {
+		return syntaxError(p.tok.offset, "expected %s", what)
+	}
+	return p.advance()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.typ == tokenEOF {
+		return syntaxError(p.tok.offset, "unexpected end of expression")
+	}
+	return syntaxError(p.tok.offset, "unexpected %q", p.tok.text)
+}
+
+func (p *parser) expr() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.keyword("OR"); err != nil {
+			return nil, err
+		} else if !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := p.keyword("AND"); err != nil {
+			return nil, err
+		} else if !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) not() (node, error) {
+	if ok, err := p.keyword("NOT"); err != nil {
+		return nil, err
+	} else if ok {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		n, err := p.not()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.predicate()
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return syntaxError(p.tok.offset, "expression too deeply nested")
+	}
+	return nil
+}
+
+func (p *parser) predicate() (node, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.typ == tokenOperator {
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{op, left, right}, nil
+	}
+
+	if ok, err := p.keyword("IS"); err != nil {
+		return nil, err
+	} else if ok {
+		negate, err := p.keyword("NOT")
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := p.keyword("NULL"); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, syntaxError(p.tok.offset, "expected NULL")
+		}
+		return negated(isNullNode{left}, negate), nil
+	}
+
+	negate, err := p.keyword("NOT")
+	if err != nil {
+		return nil, err
+	}
+	var n node
+	switch {
+	case p.tok.typ == tokenKeyword && p.tok.text == "IN":
+		n, err = p.in(left)
+	case p.tok.typ == tokenKeyword && p.tok.text == "LIKE":
+		n, err = p.like(left)
+	case p.tok.typ == tokenKeyword && p.tok.text == "BETWEEN":
+		n, err = p.between(left)
+	case negate:
+		return nil, syntaxError(p.tok.offset, "expected IN, LIKE or BETWEEN")
+	default:
+		return left, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return negated(n, negate), nil
+}
+
+func (p *parser) in(left node) (node, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokenLeftParen, "'('"); err != nil {
+		return nil, err
+	}
+	var values []value
+	for {
+		v, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if p.tok.typ != tokenComma {
+			break
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(tokenRightParen, "')'"); err != nil {
+		return nil, err
+	}
+	return inNode{left, values}, nil
+}
+
+func (p *parser) like(left node) (node, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	patternOffset := p.tok.offset
+	pattern := p.tok.text
+	if err := p.expect(tokenString, "string pattern"); err != nil {
+		return nil, err
+	}
+	escape := ""
+	if ok, err := p.keyword("ESCAPE"); err != nil {
+		return nil, err
+	} else if ok {
+		escape = p.tok.text
+		offset := p.tok.offset
+		if err := p.expect(tokenString, "escape character"); err != nil {
+			return nil, err
+		}
+		if utf8.RuneCountInString(escape) != 1 {
+			return nil, syntaxError(offset, "escape must be a single character")
+		}
+	}
+	re, err := likeRegexp(pattern, escape)
+	if err != nil {
+		return nil, syntaxError(patternOffset, "%s", err.Error())
+	}
+	return likeNode{left, re}, nil
+}
+
+func (p *parser) between(left node) (node, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	low, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.keyword("AND"); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, syntaxError(p.tok.offset, "expected AND")
+	}
+	high, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return andNode{compareNode{">=", left, low}, compareNode{"<=", left, high}}, nil
+}
+
+func (p *parser) operand() (node, error) {
+	switch p.tok.typ {
+	case tokenIdent:
+		name := p.tok.text
+		return identNode(name), p.advance()
+	case tokenLeftParen:
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return n, p.expect(tokenRightParen, "')'")
+	}
+	v, err := p.literal()
+	if err != nil {
+		return nil, err
+	}
+	return literalNode{v}, nil
+}
+
+func (p *parser) literal() (value, error) {
+	tok := p.tok
+	var v value
+	switch {
+	case tok.typ == tokenString:
+		v = stringValue(tok.text)
+	case tok.typ == tokenNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return value{}, syntaxError(tok.offset, "invalid number %q", tok.text)
+		}
+		v = numberValue(n)
+	case tok.typ == tokenKeyword && tok.text == "TRUE":
+		v = boolValue(true)
+	case tok.typ == tokenKeyword && tok.text == "FALSE":
+		v = boolValue(false)
+	default:
+		return value{}, p.unexpected()
+	}
+	return v, p.advance()
+}
+
+func negated(n node, negate bool) node {
+	if negate {
+		return notNode{n}
+	}
+	return n
+}
+
+// Converts a LIKE pattern, where '%' matches any sequence of
+// characters and '_' matches any single character, to a regexp.
+func likeRegexp(pattern, escape string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case escape != "" && string(c) == escape:
+			escaped = true
+		case c == '%':
+			sb.WriteString(".*")
+		case c == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if escaped {
+		return nil, errors.New("escape at end of pattern")
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
@@ -0,0 +1,75 @@
+/*
+Package selector implements message selectors, which are expressions
+used by subscribers to choose the messages they receive based on the
+message headers.
+
+The syntax is a subset of SQL-92 conditional expressions, similar to
+JMS message selectors:
+
+	priority > 5 AND type IN ('order', 'invoice')
+	region LIKE 'eu-%' OR "content-type" = 'application/json'
+	NOT (retry IS NOT NULL) AND weight BETWEEN 1.5 AND 10
+
+Identifiers are header names. Header names that are not valid
+identifiers, or are reserved words, can be enclosed in double quotes.
+String literals are enclosed in single quotes, with a quote included by
+doubling it. The supported operators are =, <> (not equal), <, <=, >,
+>=, AND, OR, NOT, [NOT] IN, [NOT] LIKE with an optional ESCAPE, [NOT]
+BETWEEN and IS [NOT] NULL. Reserved words are not case-sensitive.
+
+Header values are strings, and are converted to numbers when compared
+with a numeric literal, or with the < and > operators, and to booleans
+when compared with TRUE or FALSE. As in SQL, a comparison involving a
+missing header, or a value that cannot be converted, is unknown, and a
+message is only selected when the expression is true.
+*/
+package selector
+
+import (
+	"fmt"
+
+	"github.com/go-stomp/stomp/frame"
+)
+
+// Selector is a compiled selector expression.
+type Selector struct {
+	expr string
+	root node
+}
+
+// Error is returned by Parse when a selector expression is invalid.
+type Error struct {
+	Offset int    // offset in the expression where the error was found
+	Msg    string // description of the error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+}
+
+func syntaxError(offset int, format string, args ...interface{}) *Error {
+	return &Error{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Parse compiles a selector expression. The error returned for an
+// invalid expression is of type *Error.
+func Parse(expr string) (*Selector, error) {
+	p := &parser{lexer: lexer{input: expr}}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{expr: expr, root: root}, nil
+}
+
+// Matches returns true if the expression is true for a frame
+// with header h.
+func (s *Selector) Matches(h *frame.Header) bool {
+	v := s.root.eval(h).boolean()
+	return v.kind == kindBool && v.b
+}
+
+// String returns the selector expression.
+func (s *Selector) String() string {
+	return s.expr
+}
@@ -0,0 +1,140 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/go-stomp/stomp/frame"
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type SelectorSuite struct{}
+
+var _ = Suite(&SelectorSuite{})
+
+func (s *SelectorSuite) TestMatches(c *C) {
+	h := frame.NewHeader(
+		"type", "order",
+		"priority", "7",
+		"weight", "2.5",
+		"region", "eu-west",
+		"urgent", "true",
+		"content-type", "application/json",
+		"note", "it's 100%")
+
+	testCases := []struct {
+		expr    string
+		matches bool
+	}{
+		{"type = 'order'", true},
+		{"type = 'invoice'", false},
+		{"type <> 'invoice'", true},
+		{"TYPE = 'order'", false}, // header names are case-sensitive
+		{"priority > 5", true},
+		{"priority >= 7", true},
+		{"priority < 7", false},
+		{"priority <= 7.0", true},
+		{"priority = 7", true},
+		{"weight > 2", true},
+		{"weight = 2.5e0", true},
+		{"-1 < priority", true},
+		{"type > 5", false},
+		{"urgent", true},
+		{"urgent = TRUE", true},
+		{"urgent = false", false},
+		{"NOT urgent", false},
+		{"type = 'order' AND priority > 5", true},
+		{"type = 'order' and priority > 10", false},
+		{"type = 'invoice' OR priority > 5", true},
+		{"NOT (type = 'invoice' OR priority > 10)", true},
+		{"type IN ('invoice', 'order')", true},
+		{"type NOT IN ('invoice', 'order')", false},
+		{"priority IN (1, 7)", true},
+		{"region LIKE 'eu-%'", true},
+		{"region LIKE 'eu_west'", true},
+		{"region LIKE 'eu'", false},
+		{"region NOT LIKE 'us-%'", true},
+		{"note LIKE '%!%' ESCAPE '!'", true},
+		{"note LIKE 'it''s%'", true},
+		{"\"content-type\" = 'application/json'", true},
+		{"content-type = 'application/json'", true},
+		{"priority BETWEEN 5 AND 10", true},
+		{"priority NOT BETWEEN 5 AND 10", false},
+		{"missing IS NULL", true},
+		{"type IS NOT NULL", true},
+		{"type IS NULL", false},
+
+		// comparisons with missing headers are unknown
+		{"missing = 'x'", false},
+		{"missing <> 'x'", false},
+		{"NOT (missing = 'x')", false},
+		{"missing IN ('x')", false},
+		{"missing NOT IN ('x')", false},
+		{"missing = 'x' OR type = 'order'", true},
+		{"missing = 'x' AND type = 'order'", false},
+		{"NOT (missing = 'x' AND type = 'invoice')", true},
+	}
+
+	for _, tc := range testCases {
+		sel, err := Parse(tc.expr)
+		if !c.Check(err, IsNil, Commentf("expr=%s", tc.expr)) {
+			continue
+		}
+		c.Check(sel.Matches(h), Equals, tc.matches, Commentf("expr=%s", tc.expr))
+		c.Check(sel.String(), Equals, tc.expr)
+	}
+}
+
+func (s *SelectorSuite) TestParseErrors(c *C) {
+	testCases := []struct {
+		expr   string
+		errMsg string
+	}{
+		{"", "empty expression at offset 0"},
+		{"type =", "unexpected end of expression at offset 6"},
+		{"type = 'order", "unterminated quoted string at offset 7"},
+		{"type = 'a' 'b'", "unexpected \"b\" at offset 11"},
+		{"(type = 'a'", "expected ')' at offset 11"},
+		{"type IN 'a'", "expected '(' at offset 8"},
+		{"type IN ()", "unexpected \")\" at offset 9"},
+		{"type LIKE 5", "expected string pattern at offset 10"},
+		{"type LIKE 'a' ESCAPE 'ab'", "escape must be a single character at offset 21"},
+		{"type LIKE 'a!' ESCAPE '!'", "escape at end of pattern at offset 10"},
+		{"type NOT = 'a'", "expected IN, LIKE or BETWEEN at offset 9"},
+		{"type IS 'a'", "expected NULL at offset 8"},
+		{"priority BETWEEN 1 OR 2", "expected AND at offset 19"},
+		{"type = 1.2.3", "invalid number \"1.2.3\" at offset 7"},
+		{"type = #", "unexpected character '#' at offset 7"},
+		{"AND", "unexpected \"AND\" at offset 0"},
+	}
+
+	for _, tc := range testCases {
+		sel, err := Parse(tc.expr)
+		c.Check(sel, IsNil)
+		if c.Check(err, NotNil, Commentf("expr=%s", tc.expr)) {
+			c.Check(err.Error(), Equals, tc.errMsg, Commentf("expr=%s", tc.expr))
+			c.Check(err, FitsTypeOf, &Error{})
+		}
+	}
+}
+
+func (s *SelectorSuite) TestMaxDepth(c *C) {
+	expr := ""
+	for i := 0; i < maxDepth; i++ {
+		expr += "("
+	}
+	expr += "a = 1"
+	for i := 0; i < maxDepth; i++ {
+		expr += ")"
+	}
+	_, err := Parse(expr)
+	c.Check(err, IsNil)
+
+	_, err = Parse("(" + expr + ")")
+	c.Check(err, ErrorMatches, "expression too deeply nested at offset .*")
+}
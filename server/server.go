@@ -1,19 +1,45 @@
 /*
 Package server contains a simple STOMP server implementation.
+
+The STOMP server has the concept of queues and topics. A message
+sent to a queue destination will be transmitted to the next available
+client that has subscribed. A message sent to a topic will be
+transmitted to all subscribers that are currently subscribed to the
+topic. A topic subscription can use wildcards in its destination, eg
+"/topic/orders.*" or "/topic/orders.>", to subscribe to all matching
+topics: see the topic package for details.
+
+A subscription to a queue or a topic can include a "selector" header,
+containing an expression that chooses the messages sent to the
+subscription based on their headers: see the selector package for
+the syntax.
+
+A client that sends a "client-id" header in its CONNECT frame can
+subscribe to a topic with a "durable-subscription-name" header. Messages
+sent to the topic are then stored for the durable subscription while the
+client is not subscribed. Sending the same header in an UNSUBSCRIBE frame
+removes the durable subscription. The client id and the durable
+subscription name cannot contain "/". A durable subscription belongs to
+the user that created it, and can only be subscribed by one client at a
+time: the server sends an ERROR frame to any other client that subscribes
+to it, or tries to remove it.
 */
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
 	"net"
+	"sync"
 	"time"
+
+	"github.com/go-stomp/stomp"
+	"github.com/go-stomp/stomp/server/metrics"
 )
 
-// The STOMP server has the concept of queues and topics. A message
-// sent to a queue destination will be transmitted to the next available
-// client that has subscribed. A message sent to a topic will be
-// transmitted to all subscribers that are currently subscribed to the
-// topic.
-//
 // Destinations that start with this prefix are considered to be queues.
 // Destinations that do not start with this prefix are considered to be topics.
 const QueuePrefix = "/queue"
@@ -23,11 +49,31 @@ const (
 	// Default address for listening for connections.
 	DefaultAddr = ":61613"
 
+	// Default address for listening for TLS connections.
+	DefaultTLSAddr = ":61614"
+
 	// Default read timeout for heart-beat.
 	// Override by setting Server.HeartBeat.
 	DefaultHeartBeat = time.Minute
+
+	// Default maximum number of unacknowledged messages sent to
+	// each queue subscription. Override by setting Server.Prefetch,
+	// or with the prefetch-count header in the SUBSCRIBE frame.
+	DefaultPrefetch = 1
+
+	// Default limits on the size of frames received from clients, which
+	// are the same as the limits that clients apply by default.
+	// Override by setting Server.MaxHeaders, Server.MaxHeaderLength
+	// and Server.MaxBodyLength.
+	DefaultMaxHeaders      = stomp.DefaultMaxHeaders
+	DefaultMaxHeaderLength = stomp.DefaultMaxHeaderLength
+	DefaultMaxBodyLength   = stomp.DefaultMaxBodyLength
 )
 
+// ErrServerClosed is returned by the Server's Serve, ListenAndServe and
+// ListenAndServeTLS methods after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("stomp: server closed")
+
 // Interface for authenticating STOMP clients.
 type Authenticator interface {
 	// Authenticate based on the given login and passcode, either of which might be nil.
@@ -35,12 +81,85 @@ type Authenticator interface {
 	Authenticate(login, passcode string) bool
 }
 
+// TLSAuthenticator is an Authenticator that can also authenticate STOMP
+// clients that connect using TLS and present a client certificate. If the
+// server's Authenticator implements this interface, AuthenticateTLS is
+// called instead of Authenticate.
+type TLSAuthenticator interface {
+	Authenticator
+
+	// AuthenticateTLS authenticates based on the given login and passcode,
+	// and the client certificate. The cert parameter is the client's leaf
+	// certificate, and is only provided if it has been verified as specified
+	// by the server's TLS configuration. Otherwise cert is nil. Returns true if
+	// authentication is successful, false otherwise.
+	AuthenticateTLS(login, passcode string, cert *x509.Certificate) bool
+}
+
 // A Server defines parameters for running a STOMP server.
 type Server struct {
 	Addr          string        // TCP address to listen on, DefaultAddr if empty
 	Authenticator Authenticator // Authenticates login/passcodes. If nil no authentication is performed
 	QueueStorage  QueueStorage  // Implementation of queue storage. If nil, in-memory queues are used.
 	HeartBeat     time.Duration // Preferred value for heart-beat read/write timeout, if zero, then DefaultHeartBeat.
+	TLSConfig     *tls.Config   // Optional TLS configuration for ListenAndServeTLS
+	Prefetch      int           // Default number of unacknowledged messages per queue subscription, if zero, then DefaultPrefetch.
+	Logger        *slog.Logger  // Logger for connection and server events. If nil, slog.Default() is used.
+
+	// Authorizer authorizes authenticated clients to send messages to,
+	// and subscribe to, destinations. If nil, all clients can send to
+	// and subscribe to all destinations.
+	Authorizer Authorizer
+
+	// MaxDeliveries is the number of times a queue message is delivered
+	// to clients before it is moved to a dead-letter queue, instead of
+	// being requeued after it is rejected with a NACK frame, or the
+	// client disconnects without acknowledging it. If zero, messages
+	// are requeued indefinitely. Queue MESSAGE frames have a
+	// delivery-count header, and a redelivered header if they have
+	// been delivered before.
+	MaxDeliveries int
+
+	// DeadLetterPrefix is the destination prefix for dead-letter queues.
+	// Messages moved from "/queue/name" go to the queue named by the
+	// prefix followed by "name", with an original-destination header.
+	// If blank, queue.DefaultDeadLetterPrefix ("/queue/DLQ.") is used.
+	DeadLetterPrefix string
+
+	// TTL specifies the default time-to-live of messages by destination.
+	// The first entry with a pattern that matches the destination applies.
+	// Clients can set the expiry time of a message with the "expires"
+	// header, containing the time in milliseconds since the UNIX epoch.
+	// Expired messages are not delivered to clients. The rules must not
+	// be changed after the server starts.
+	TTL []DestinationTTL
+
+	// DeadLetterExpired specifies that expired queue messages are moved
+	// to the dead-letter queue (see DeadLetterPrefix), rather than being
+	// discarded.
+	DeadLetterExpired bool
+
+	// ExpirySweepInterval is the interval between removing expired
+	// messages from queue storage. If zero, DefaultExpirySweepInterval
+	// is used. Expired messages are also removed as they reach the
+	// head of their queue.
+	ExpirySweepInterval time.Duration
+
+	// MaxHeaders, MaxHeaderLength and MaxBodyLength limit the number of
+	// header entries in a frame received from a client, the length in
+	// bytes of its command and each header line, and the length in bytes
+	// of its body. A client that sends a frame exceeding a limit receives
+	// an ERROR frame and is disconnected. If zero, DefaultMaxHeaders,
+	// DefaultMaxHeaderLength and DefaultMaxBodyLength are used. If
+	// negative, the limit is not enforced.
+	MaxHeaders      int
+	MaxHeaderLength int
+	MaxBodyLength   int
+
+	proc        *requestProcessor
+	procOnce    sync.Once
+	metrics     *metrics.Metrics
+	metricsOnce sync.Once
 }
 
 // ListenAndServe listens on the TCP network address addr and then calls Serve.
@@ -49,6 +168,14 @@ func ListenAndServe(addr string) error {
 	return s.ListenAndServe()
 }
 
+// ListenAndServeTLS listens on the TCP network address addr and then calls
+// Serve to handle TLS connections. The certFile and keyFile parameters
+// specify the server's certificate and matching private key.
+func ListenAndServeTLS(addr, certFile, keyFile string) error {
+	s := &Server{Addr: addr}
+	return s.ListenAndServeTLS(certFile, keyFile)
+}
+
 // Serve accepts incoming TCP connections on the listener l, creating a new
 // STOMP service thread for each connection.
 func Serve(l net.Listener) error {
@@ -72,10 +199,100 @@ func (s *Server) ListenAndServe() error {
 	return s.Serve(l)
 }
 
+// ListenAndServeTLS listens on the TCP network address s.Addr and then
+// calls Serve to handle requests on incoming TLS connections. If s.Addr
+// is blank, then DefaultTLSAddr is used.
+//
+// The certFile and keyFile parameters specify the server's certificate and
+// matching private key. They can be blank if s.TLSConfig already contains
+// a certificate. To require clients to present a certificate signed by a
+// trusted authority, set ClientAuth and ClientCAs in s.TLSConfig. The
+// verified client certificate is passed to the Authenticator if it
+// implements TLSAuthenticator.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultTLSAddr
+	}
+
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	hasCert := len(config.Certificates) > 0 || config.GetCertificate != nil
+	if !hasCert || certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(tls.NewListener(l, config))
+}
+
 // Serve accepts incoming connections on the Listener l, creating a new
 // service thread for each connection. The service threads read
-// requests and then process each request.
+// requests and then process each request. Serve returns when l.Accept
+// returns an error. After Shutdown or Close, the error is ErrServerClosed.
+//
+// Serve can be called concurrently with different listeners, for example
+// to accept both TCP and WebSocket connections. Clients connected via any
+// of the listeners share the same queues and topics.
 func (s *Server) Serve(l net.Listener) error {
-	proc := newRequestProcessor(s)
-	return proc.Serve(l)
+	return s.processor().Serve(l)
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting new
+// connections, and sends an ERROR frame to each connected client before
+// disconnecting it. Messages that have been sent to clients but not
+// acknowledged are requeued. Once all clients have disconnected, the queue
+// storage is stopped, which allows it to flush any pending changes.
+//
+// If ctx expires before all clients have disconnected, the remaining client
+// connections are closed as for Close, and Shutdown returns ctx.Err() once
+// the server has stopped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	proc := s.processor()
+	proc.Stop(false)
+	select {
+	case <-proc.done:
+		return nil
+	case <-ctx.Done():
+		proc.Stop(true)
+		<-proc.done
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes all listeners and client connections. Messages
+// that have been sent to clients but not acknowledged are requeued, and
+// the queue storage is stopped. Close returns once the server has stopped.
+func (s *Server) Close() error {
+	proc := s.processor()
+	proc.Stop(true)
+	<-proc.done
+	return nil
+}
+
+// Metrics returns the server's metrics, which count connections, frames,
+// subscriptions, and messages by destination. The metrics can be served
+// over HTTP in the Prometheus text format, and published with expvar.
+func (s *Server) Metrics() *metrics.Metrics {
+	s.metricsOnce.Do(func() {
+		s.metrics = &metrics.Metrics{}
+	})
+	return s.metrics
+}
+
+func (s *Server) processor() *requestProcessor {
+	s.procOnce.Do(func() {
+		s.proc = newRequestProcessor(s)
+	})
+	return s.proc
 }
@@ -1,12 +1,27 @@
 package server
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
 	"net"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-stomp/stomp"
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/queue"
+	"github.com/go-stomp/stomp/server/selector"
 	. "gopkg.in/check.v1"
 )
 
@@ -45,6 +60,876 @@ func (s *ServerSuite) TestConnectAndDisconnect(c *C) {
 	conn.Close()
 }
 
+func (s *ServerSuite) TestValidation(c *C) {
+	addr := "127.0.0.1:59103"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go Serve(l)
+
+	conn, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	reader := frame.NewReader(conn)
+	writer := frame.NewWriter(conn)
+
+	c.Assert(writer.Write(frame.New(frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.Host, "localhost")), IsNil)
+	f, err := reader.Read()
+	c.Assert(err, IsNil)
+	c.Assert(f.Command, Equals, frame.CONNECTED)
+
+	// only SEND frames can have a body in STOMP 1.2
+	f = frame.New(frame.BEGIN, frame.Transaction, "tx-1")
+	f.Body = []byte("body")
+	c.Assert(writer.Write(f), IsNil)
+	f, err = reader.Read()
+	c.Assert(err, IsNil)
+	c.Check(f.Command, Equals, frame.ERROR)
+	c.Check(f.Header.Get(frame.Message), Equals, "frame cannot have a body")
+}
+
+func (s *ServerSuite) TestFrameLimits(c *C) {
+	c.Check(newConfig(&Server{}).FrameLimits(), Equals, frame.Limits{
+		MaxHeaders:      DefaultMaxHeaders,
+		MaxHeaderLength: DefaultMaxHeaderLength,
+		MaxBodyLength:   DefaultMaxBodyLength,
+	})
+	c.Check(newConfig(&Server{MaxHeaders: -1, MaxHeaderLength: 10}).FrameLimits(), Equals, frame.Limits{
+		MaxHeaderLength: 10,
+		MaxBodyLength:   DefaultMaxBodyLength,
+	})
+
+	addr := "127.0.0.1:59104"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{MaxBodyLength: 10}
+	go server.Serve(l)
+	defer server.Close()
+
+	client, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	c.Assert(client.Send("/queue/a", "text/plain", []byte("0123456789")), IsNil)
+
+	// the oversized frame is not processed
+	err = client.Send("/queue/a", "text/plain", []byte("0123456789A"), stomp.SendOpt.Receipt)
+	c.Assert(err, FitsTypeOf, stomp.Error{})
+	c.Check(err, ErrorMatches, "frame body too large")
+}
+
+func (s *ServerSuite) TestShutdown(c *C) {
+	dir := c.MkDir()
+	qstore, err := queue.NewFileQueueStorage(dir, queue.FileQueueOptions{})
+	c.Assert(err, IsNil)
+
+	addr := "127.0.0.1:59094"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{QueueStorage: qstore}
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(l)
+	}()
+
+	receiver, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	sub, err := receiver.Subscribe("/queue/shutdown", stomp.AckClient, stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	sender, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	err = sender.Send("/queue/shutdown", "text/plain", []byte("unacknowledged"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Assert(string(msg.Body), Equals, "unacknowledged")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Assert(server.Shutdown(ctx), IsNil)
+	c.Assert(<-served, Equals, ErrServerClosed)
+
+	msg = <-sub.C
+	c.Assert(msg.Err, ErrorMatches, "server shutting down")
+
+	// the unacknowledged message has been requeued and stored
+	qstore, err = queue.NewFileQueueStorage(dir, queue.FileQueueOptions{})
+	c.Assert(err, IsNil)
+	defer qstore.Close()
+	f, err := qstore.Dequeue("/queue/shutdown")
+	c.Assert(err, IsNil)
+	c.Assert(f, NotNil)
+	c.Check(string(f.Body), Equals, "unacknowledged")
+	c.Check(f.Command, Equals, frame.MESSAGE)
+
+	c.Check(server.Serve(l), Equals, ErrServerClosed)
+	c.Check(server.Close(), IsNil)
+}
+
+func (s *ServerSuite) TestDeliveredMessageStored(c *C) {
+	dir := c.MkDir()
+	qstore, err := queue.NewFileQueueStorage(dir, queue.FileQueueOptions{Sync: queue.SyncAlways})
+	c.Assert(err, IsNil)
+
+	addr := "127.0.0.1:59107"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{QueueStorage: qstore}
+	go server.Serve(l)
+	defer server.Close()
+
+	receiver, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	sub, err := receiver.Subscribe("/queue/crash", stomp.AckClient, stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	sender, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	err = sender.Send("/queue/crash", "text/plain", []byte("delivered"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Assert(string(msg.Body), Equals, "delivered")
+
+	// the server crashes before the message is acknowledged:
+	// reopening the storage finds the message in the queue
+	reopened, err := queue.NewFileQueueStorage(dir, queue.FileQueueOptions{})
+	c.Assert(err, IsNil)
+	defer reopened.Close()
+	f, err := reopened.Dequeue("/queue/crash")
+	c.Assert(err, IsNil)
+	c.Assert(f, NotNil)
+	c.Check(string(f.Body), Equals, "delivered")
+}
+
+func (s *ServerSuite) TestPrefetch(c *C) {
+	addr := "127.0.0.1:59095"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{Prefetch: 2}
+	go server.Serve(l)
+	defer server.Close()
+
+	sender, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	for i := 1; i <= 5; i++ {
+		err = sender.Send("/queue/prefetch", "text/plain", []byte(fmt.Sprint(i)), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+
+	receiver, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+
+	// the prefetch-count header overrides the server default
+	sub, err := receiver.Subscribe("/queue/prefetch", stomp.AckClientIndividual,
+		stomp.SubscribeOpt.Header(frame.PrefetchCount, "3"))
+	c.Assert(err, IsNil)
+
+	var msgs []*stomp.Message
+	for i := 1; i <= 3; i++ {
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Assert(string(msg.Body), Equals, fmt.Sprint(i))
+		msgs = append(msgs, msg)
+	}
+
+	// no more messages until one has been acknowledged
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected message: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.Assert(receiver.Ack(msgs[1]), IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Assert(string(msg.Body), Equals, "4")
+
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected message: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// rejected messages are requeued and sent again
+	c.Assert(receiver.Nack(msgs[0]), IsNil)
+	msg = <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Assert(string(msg.Body), Equals, "1")
+
+	// invalid prefetch-count
+	_, err = receiver.Subscribe("/queue/prefetch", stomp.AckClient,
+		stomp.SubscribeOpt.Header(frame.PrefetchCount, "0"),
+		stomp.SubscribeOpt.Receipt)
+	c.Assert(err, ErrorMatches, "invalid prefetch-count")
+}
+
+func (s *ServerSuite) TestDeadLetterQueue(c *C) {
+	addr := "127.0.0.1:59096"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{MaxDeliveries: 2}
+	go server.Serve(l)
+	defer server.Close()
+
+	conn, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+
+	sub, err := conn.Subscribe("/queue/poison", stomp.AckClientIndividual)
+	c.Assert(err, IsNil)
+
+	// clients cannot set the delivery-count header
+	err = conn.Send("/queue/poison", "text/plain", []byte("poison"),
+		stomp.SendOpt.Header(frame.DeliveryCount, "99"),
+		stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(msg.Header.Get(frame.DeliveryCount), Equals, "1")
+	c.Check(msg.Header.Get(frame.Redelivered), Equals, "")
+	c.Assert(conn.Nack(msg), IsNil)
+
+	msg = <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(msg.Header.Get(frame.DeliveryCount), Equals, "2")
+	c.Check(msg.Header.Get(frame.Redelivered), Equals, "true")
+	c.Assert(conn.Nack(msg), IsNil)
+
+	// delivered twice, so moved to the dead-letter queue
+	dlq, err := conn.Subscribe("/queue/DLQ.poison", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg = <-dlq.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "poison")
+	c.Check(msg.Destination, Equals, "/queue/DLQ.poison")
+	c.Check(msg.Header.Get(frame.OriginalDestination), Equals, "/queue/poison")
+	c.Check(msg.Header.Get(frame.DeliveryCount), Equals, "1")
+
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected message: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *ServerSuite) TestExpiry(c *C) {
+	addr := "127.0.0.1:59097"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{
+		TTL: []DestinationTTL{
+			{Pattern: "/queue/ttl.*", TTL: 50 * time.Millisecond},
+		},
+		DeadLetterExpired: true,
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	conn, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+
+	// expires using the default TTL for the destination
+	err = conn.Send("/queue/ttl.a", "text/plain", []byte("ttl"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	// already expired
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	err = conn.Send("/queue/ttl.a", "text/plain", []byte("past"),
+		stomp.SendOpt.Header(frame.Expires, past),
+		stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	// never expires, overriding the default TTL
+	err = conn.Send("/queue/ttl.a", "text/plain", []byte("never"),
+		stomp.SendOpt.Header(frame.Expires, "0"),
+		stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	time.Sleep(100 * time.Millisecond)
+
+	sub, err := conn.Subscribe("/queue/ttl.a", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "never")
+
+	dlq, err := conn.Subscribe("/queue/DLQ.ttl.a", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	for _, body := range []string{"past", "ttl"} {
+		msg = <-dlq.C
+		c.Assert(msg.Err, IsNil)
+		c.Check(string(msg.Body), Equals, body)
+		c.Check(msg.Header.Get(frame.OriginalDestination), Equals, "/queue/ttl.a")
+	}
+}
+
+func (s *ServerSuite) TestDurableSubscription(c *C) {
+	addr := "127.0.0.1:59098"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{}
+	go server.Serve(l)
+	defer server.Close()
+
+	publisher, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	defer publisher.Disconnect()
+
+	subscribe := func() (*stomp.Conn, *stomp.Subscription) {
+		conn, err := stomp.Dial("tcp", addr, stomp.ConnOpt.Header(frame.ClientId, "client-1"))
+		c.Assert(err, IsNil)
+		sub, err := conn.Subscribe("/topic/news", stomp.AckClientIndividual,
+			stomp.SubscribeOpt.Header(frame.DurableSubscriptionName, "news"),
+			stomp.SubscribeOpt.Receipt)
+		c.Assert(err, IsNil)
+		return conn, sub
+	}
+
+	conn, sub := subscribe()
+	err = publisher.Send("/topic/news", "text/plain", []byte("one"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "one")
+	c.Check(msg.Destination, Equals, "/topic/news")
+	c.Assert(conn.Ack(msg), IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+
+	// stored while the client is disconnected
+	err = publisher.Send("/topic/news", "text/plain", []byte("two"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	conn, sub = subscribe()
+	msg = <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "two")
+	c.Assert(conn.Ack(msg), IsNil)
+
+	// remove the durable subscription
+	err = sub.Unsubscribe(stomp.SubscribeOpt.Header(frame.DurableSubscriptionName, "news"))
+	c.Assert(err, IsNil)
+	c.Assert(conn.Disconnect(), IsNil)
+
+	err = publisher.Send("/topic/news", "text/plain", []byte("three"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	conn, sub = subscribe()
+	defer conn.Disconnect()
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected message: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *ServerSuite) TestDurableSubscriptionRules(c *C) {
+	addr := "127.0.0.1:59105"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{Authenticator: testPasscodes{"alice": "a", "bob": "b"}}
+	go server.Serve(l)
+	defer server.Close()
+
+	dial := func(login, clientId string) *stomp.Conn {
+		conn, err := stomp.Dial("tcp", addr,
+			stomp.ConnOpt.Login(login, login[:1]),
+			stomp.ConnOpt.Header(frame.ClientId, clientId))
+		c.Assert(err, IsNil)
+		return conn
+	}
+	subscribe := func(conn *stomp.Conn, name string) (*stomp.Subscription, error) {
+		return conn.Subscribe("/topic/news", stomp.AckAuto,
+			stomp.SubscribeOpt.Header(frame.DurableSubscriptionName, name),
+			stomp.SubscribeOpt.Receipt)
+	}
+	rejected := func(sub *stomp.Subscription, message string) {
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, NotNil)
+			c.Check(msg.Err, ErrorMatches, message)
+		case <-time.After(time.Second):
+			c.Fatalf("timed out waiting for %s", message)
+		}
+	}
+
+	// the client id and name are separated by "/" in the queue name
+	_, err = subscribe(dial("alice", "app/1"), "news")
+	c.Check(err, ErrorMatches, "durable subscription requires client-id without '/'")
+	_, err = subscribe(dial("alice", "app"), "news/1")
+	c.Check(err, ErrorMatches, "durable subscription name cannot contain '/'")
+
+	alice := dial("alice", "app")
+	_, err = subscribe(alice, "news")
+	c.Assert(err, IsNil)
+
+	// only one client can be subscribed at a time
+	sub, err := subscribe(dial("alice", "app"), "news")
+	c.Assert(err, IsNil)
+	rejected(sub, "durable subscription already active")
+	c.Assert(alice.Disconnect(), IsNil)
+
+	// the durable subscription belongs to alice
+	bob := dial("bob", "app")
+	sub, err = subscribe(bob, "news")
+	c.Assert(err, IsNil)
+	rejected(sub, "durable subscription created by another user")
+
+	alice = dial("alice", "app")
+	defer alice.Disconnect()
+	_, err = subscribe(alice, "news")
+	c.Assert(err, IsNil)
+}
+
+// Authorizer that allows everything, until read permission is revoked.
+type revocableAuthorizer struct {
+	revoked atomic.Bool
+}
+
+func (a *revocableAuthorizer) Authorize(principal, destination string, perm Permission) bool {
+	return perm != PermissionRead || !a.revoked.Load()
+}
+
+func (s *ServerSuite) TestDurableUnsubscribeAuthorization(c *C) {
+	addr := "127.0.0.1:59106"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	auth := &revocableAuthorizer{}
+	server := &Server{Authorizer: auth}
+	go server.Serve(l)
+	defer server.Close()
+
+	subscribe := func() (*stomp.Conn, *stomp.Subscription) {
+		conn, err := stomp.Dial("tcp", addr, stomp.ConnOpt.Header(frame.ClientId, "client-1"))
+		c.Assert(err, IsNil)
+		sub, err := conn.Subscribe("/topic/news", stomp.AckAuto,
+			stomp.SubscribeOpt.Header(frame.DurableSubscriptionName, "news"),
+			stomp.SubscribeOpt.Receipt)
+		c.Assert(err, IsNil)
+		return conn, sub
+	}
+
+	// not allowed to remove the durable subscription without read permission
+	conn, sub := subscribe()
+	other, err := conn.Subscribe("/topic/other", stomp.AckAuto, stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+	auth.revoked.Store(true)
+	err = sub.Unsubscribe(stomp.SubscribeOpt.Header(frame.DurableSubscriptionName, "news"))
+	c.Assert(err, IsNil)
+	msg := <-other.C
+	c.Assert(msg.Err, NotNil)
+	c.Check(msg.Err, ErrorMatches, "not authorized: /topic/news")
+
+	publisher, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	defer publisher.Disconnect()
+	err = publisher.Send("/topic/news", "text/plain", []byte("kept"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	auth.revoked.Store(false)
+	conn, sub = subscribe()
+	defer conn.Disconnect()
+	msg = <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "kept")
+}
+
+func (s *ServerSuite) TestDurableRegistry(c *C) {
+	qstore := queue.NewMemoryQueueStorage()
+	r, err := newDurableRegistry(qstore)
+	c.Assert(err, IsNil)
+
+	added, err := r.Add("/topic/a", "/durable/c1/a", nil, "alice")
+	c.Assert(err, IsNil)
+	c.Check(added, Equals, true)
+	added, err = r.Add("/topic/a", "/durable/c1/a", nil, "alice")
+	c.Assert(err, IsNil)
+	c.Check(added, Equals, false)
+	_, err = r.Add("/topic/a", "/durable/c2/a", nil, "")
+	c.Assert(err, IsNil)
+	_, err = r.Add("/topic/b", "/durable/c1/b", nil, "")
+	c.Assert(err, IsNil)
+
+	// moved to a different topic, and selector added
+	sel, err := selector.Parse("priority > 5")
+	c.Assert(err, IsNil)
+	added, err = r.Add("/topic/c.*", "/durable/c1/b", sel, "")
+	c.Assert(err, IsNil)
+	c.Check(added, Equals, true)
+
+	removed, err := r.Remove("/durable/c2/a")
+	c.Assert(err, IsNil)
+	c.Check(removed, Equals, true)
+	removed, err = r.Remove("/durable/c2/a")
+	c.Assert(err, IsNil)
+	c.Check(removed, Equals, false)
+
+	// loaded from queue storage
+	r, err = newDurableRegistry(qstore)
+	c.Assert(err, IsNil)
+	message := func(destination string, headers ...string) *frame.Frame {
+		return frame.New(frame.MESSAGE, append([]string{frame.Destination, destination}, headers...)...)
+	}
+	c.Check(r.Queues(message("/topic/a")), DeepEquals, []string{"/durable/c1/a"})
+	c.Check(r.Queues(message("/topic/b")), IsNil)
+	c.Check(r.Queues(message("/topic/c.d", "priority", "7")), DeepEquals, []string{"/durable/c1/b"})
+	c.Check(r.Queues(message("/topic/c.d", "priority", "1")), IsNil)
+	c.Check(r.Contains("/durable/c1/b"), Equals, true)
+	c.Check(r.Contains("/durable/c2/a"), Equals, false)
+	principal, ok := r.Principal("/durable/c1/a")
+	c.Check(ok, Equals, true)
+	c.Check(principal, Equals, "alice")
+}
+
+func (s *ServerSuite) TestSelectors(c *C) {
+	addr := "127.0.0.1:59099"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{}
+	go server.Serve(l)
+	defer server.Close()
+
+	conn, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+
+	send := func(destination, color string) {
+		err := conn.Send(destination, "text/plain", []byte(color),
+			stomp.SendOpt.Header("color", color),
+			stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	receive := func(sub *stomp.Subscription, body string) {
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, IsNil)
+			c.Check(string(msg.Body), Equals, body)
+		case <-time.After(time.Second):
+			c.Fatalf("timed out waiting for %s", body)
+		}
+	}
+	nothing := func(sub *stomp.Subscription) {
+		select {
+		case msg := <-sub.C:
+			c.Fatalf("unexpected message: %s", msg.Body)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// queued until a subscription selects the message
+	send("/queue/colors", "blue")
+	red, err := conn.Subscribe("/queue/colors", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(frame.Selector, "color = 'red'"),
+		stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+	send("/queue/colors", "red")
+	receive(red, "red")
+	other, err := conn.Subscribe("/queue/colors", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(frame.Selector, "color IN ('blue', 'green')"))
+	c.Assert(err, IsNil)
+	receive(other, "blue")
+	send("/queue/colors", "green")
+	send("/queue/colors", "red")
+	receive(other, "green")
+	receive(red, "red")
+	nothing(red)
+	nothing(other)
+
+	topic, err := conn.Subscribe("/topic/colors", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(frame.Selector, "color LIKE 'gr%'"),
+		stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+	send("/topic/colors", "red")
+	send("/topic/colors", "green")
+	receive(topic, "green")
+	nothing(topic)
+	c.Assert(conn.Disconnect(), IsNil)
+
+	// an invalid selector is an error
+	conn, err = stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	sub, err := conn.Subscribe("/queue/colors", stomp.AckAuto,
+		stomp.SubscribeOpt.Header(frame.Selector, "color = "))
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, NotNil)
+	c.Check(msg.Err.Error(), Equals, "invalid selector: unexpected end of expression at offset 8")
+}
+
+func (s *ServerSuite) TestMetrics(c *C) {
+	addr := "127.0.0.1:59101"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{}
+	go server.Serve(l)
+	defer server.Close()
+
+	conn, err := stomp.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	defer conn.Disconnect()
+
+	receive := func(sub *stomp.Subscription, body string) *stomp.Message {
+		select {
+		case msg := <-sub.C:
+			c.Assert(msg.Err, IsNil)
+			c.Assert(string(msg.Body), Equals, body)
+			return msg
+		case <-time.After(time.Second):
+			c.Fatalf("timed out waiting for %s", body)
+		}
+		return nil
+	}
+
+	for _, body := range []string{"1", "2"} {
+		err = conn.Send("/queue/m", "text/plain", []byte(body), stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+	}
+	err = conn.Send("/topic/m", "text/plain", []byte("unheard"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	m := server.Metrics()
+	c.Check(m.QueueDepth.Values(), DeepEquals, map[string]int64{"/queue/m": 2})
+
+	// the first message is redelivered after it is rejected
+	queueSub, err := conn.Subscribe("/queue/m", stomp.AckClient)
+	c.Assert(err, IsNil)
+	c.Assert(conn.Nack(receive(queueSub, "1")), IsNil)
+	c.Assert(conn.Ack(receive(queueSub, "1")), IsNil)
+	c.Assert(conn.Ack(receive(queueSub, "2")), IsNil)
+
+	topicSub, err := conn.Subscribe("/topic/m", stomp.AckAuto, stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+	err = conn.Send("/topic/m", "text/plain", []byte("heard"), stomp.SendOpt.Receipt)
+	c.Assert(err, IsNil)
+	receive(topicSub, "heard")
+
+	c.Check(m.Connections.Value(), Equals, int64(1))
+	c.Check(m.ConnectionsTotal.Value(), Equals, int64(1))
+	c.Check(m.FramesReceived.With(frame.SEND).Value(), Equals, int64(4))
+	c.Check(m.Subscriptions.Values(), DeepEquals, map[string]int64{"/queue/m": 1, "/topic/m": 1})
+	c.Check(m.MessagesEnqueued.Values(), DeepEquals, map[string]int64{"/queue/m": 2, "/topic/m": 2})
+	c.Check(m.MessagesDelivered.Values(), DeepEquals, map[string]int64{"/queue/m": 3, "/topic/m": 1})
+	c.Check(m.MessagesRedelivered.Values(), DeepEquals, map[string]int64{"/queue/m": 1})
+	c.Check(m.QueueDepth.Values(), DeepEquals, map[string]int64{"/queue/m": 0})
+
+	var b strings.Builder
+	c.Assert(m.WritePrometheus(&b), IsNil)
+	c.Check(b.String(), Matches, `(?s).*\nstomp_messages_delivered_total\{destination="/queue/m"\} 3\n.*`)
+
+	// the labels for a topic are deleted when it has no subscriptions
+	c.Assert(topicSub.Unsubscribe(), IsNil)
+	for i := 0; i < 100; i++ {
+		if _, ok := m.MessagesEnqueued.Values()["/topic/m"]; !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(m.Subscriptions.Values(), DeepEquals, map[string]int64{"/queue/m": 1})
+	c.Check(m.MessagesEnqueued.Values(), DeepEquals, map[string]int64{"/queue/m": 2})
+	c.Check(m.MessagesDelivered.Values(), DeepEquals, map[string]int64{"/queue/m": 3})
+}
+
+func (s *ServerSuite) TestParseACL(c *C) {
+	acl, err := ParseACL(strings.NewReader(`
+# principal  permissions  pattern
+alice        read,write   /queue/orders.*
+*            read         /topic/public.>
+admin        admin        /#
+`))
+	c.Assert(err, IsNil)
+	c.Check(acl.Rules, DeepEquals, []ACLRule{
+		{Principal: "alice", Pattern: "/queue/orders.*", Permissions: PermissionRead | PermissionWrite},
+		{Principal: "*", Pattern: "/topic/public.>", Permissions: PermissionRead},
+		{Principal: "admin", Pattern: "/#", Permissions: PermissionAdmin},
+	})
+
+	testCases := []struct {
+		principal   string
+		destination string
+		perm        Permission
+		allowed     bool
+	}{
+		{"alice", "/queue/orders.eu", PermissionWrite, true},
+		{"alice", "/queue/orders.eu", PermissionRead, true},
+		{"alice", "/queue/orders.eu", PermissionAdmin, false},
+		{"alice", "/queue/invoices", PermissionRead, false},
+		{"bob", "/queue/orders.eu", PermissionRead, false},
+		{"bob", "/topic/public.news", PermissionRead, true},
+		{"", "/topic/public.news", PermissionRead, true},
+		{"bob", "/topic/public.news", PermissionWrite, false},
+		{"bob", "/topic/public.>", PermissionRead, true},
+		{"bob", "/topic/>", PermissionRead, false},
+		{"bob", "/topic/#", PermissionRead, false},
+		{"bob", "/topic/public.*", PermissionRead, true},
+		{"bob", "/topic/public.#", PermissionRead, false},
+		{"bob", "/topic/public/>", PermissionRead, false},
+		{"alice", "/queue/orders.*", PermissionRead, true},
+		{"alice", "/queue/orders.>", PermissionRead, false},
+		{"alice", "/queue/orders.#", PermissionRead, false},
+		{"alice", "/queue/*", PermissionRead, false},
+		{"admin", "/topic/#", PermissionRead, true},
+		{"admin", "/queue/anything", PermissionWrite, true},
+		{"admin", "/topic/a.b", PermissionAdmin, true},
+	}
+	for _, tc := range testCases {
+		c.Check(acl.Authorize(tc.principal, tc.destination, tc.perm), Equals, tc.allowed,
+			Commentf("principal=%s destination=%s perm=%s", tc.principal, tc.destination, tc.perm))
+	}
+
+	_, err = ParseACL(strings.NewReader("alice read\n"))
+	c.Check(err, ErrorMatches, "line 1: expected principal, permissions and pattern")
+	_, err = ParseACL(strings.NewReader("\nalice read,delete /queue/a\n"))
+	c.Check(err, ErrorMatches, `line 2: unknown permission "delete"`)
+}
+
+func (s *ServerSuite) TestAuthorization(c *C) {
+	addr := "127.0.0.1:59100"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	server := &Server{
+		Authorizer: &ACL{Rules: []ACLRule{
+			{Principal: "alice", Pattern: "/queue/orders", Permissions: PermissionRead | PermissionWrite},
+			{Principal: "bob", Pattern: "/queue/orders", Permissions: PermissionRead},
+			{Principal: "bob", Pattern: "/queue/log", Permissions: PermissionRead | PermissionWrite},
+		}},
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	// allowed
+	alice, err := stomp.Dial("tcp", addr, stomp.ConnOpt.Login("alice", ""))
+	c.Assert(err, IsNil)
+	c.Assert(alice.Send("/queue/orders", "text/plain", []byte("order"), stomp.SendOpt.Receipt), IsNil)
+	sub, err := alice.Subscribe("/queue/orders", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg := <-sub.C
+	c.Assert(msg.Err, IsNil)
+	c.Check(string(msg.Body), Equals, "order")
+	c.Assert(alice.Disconnect(), IsNil)
+
+	expectError := func(err error) {
+		c.Check(err, ErrorMatches, "not authorized: /queue/orders")
+	}
+
+	// not allowed to send
+	bob, err := stomp.Dial("tcp", addr, stomp.ConnOpt.Login("bob", ""))
+	c.Assert(err, IsNil)
+	expectError(bob.Send("/queue/orders", "text/plain", []byte("order"), stomp.SendOpt.Receipt))
+
+	// not allowed to commit a transaction, so nothing is sent
+	bob, err = stomp.Dial("tcp", addr, stomp.ConnOpt.Login("bob", ""))
+	c.Assert(err, IsNil)
+	tx, err := bob.BeginWithError()
+	c.Assert(err, IsNil)
+	c.Assert(tx.Send("/queue/log", "text/plain", []byte("log")), IsNil)
+	c.Assert(tx.Send("/queue/orders", "text/plain", []byte("order")), IsNil)
+	expectError(tx.Commit(stomp.FrameOpt.Receipt))
+
+	// not allowed to subscribe
+	eve, err := stomp.Dial("tcp", addr, stomp.ConnOpt.Login("eve", ""))
+	c.Assert(err, IsNil)
+	sub, err = eve.Subscribe("/queue/orders", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	msg = <-sub.C
+	c.Assert(msg.Err, NotNil)
+	c.Check(msg.Err, ErrorMatches, "not authorized: /queue/orders")
+
+	// the transaction was not committed
+	bob, err = stomp.Dial("tcp", addr, stomp.ConnOpt.Login("bob", ""))
+	c.Assert(err, IsNil)
+	sub, err = bob.Subscribe("/queue/log", stomp.AckAuto)
+	c.Assert(err, IsNil)
+	select {
+	case msg := <-sub.C:
+		c.Fatalf("unexpected message: %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+type testTLSAuthenticator struct {
+	login string
+	cert  *x509.Certificate
+}
+
+func (a *testTLSAuthenticator) Authenticate(login, passcode string) bool {
+	return false
+}
+
+func (a *testTLSAuthenticator) AuthenticateTLS(login, passcode string, cert *x509.Certificate) bool {
+	a.login, a.cert = login, cert
+	return cert != nil
+}
+
+func (s *ServerSuite) TestConnectMutualTLS(c *C) {
+	ca, caKey := newTestCertificate(c, "test-ca", nil, nil)
+	serverCert, serverKey := newTestCertificate(c, "127.0.0.1", ca, caKey)
+	clientCert, clientKey := newTestCertificate(c, "test-client", ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	auth := &testTLSAuthenticator{}
+	server := &Server{
+		Authenticator: auth,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		},
+	}
+
+	addr := "127.0.0.1:59093"
+	l, err := net.Listen("tcp", addr)
+	c.Assert(err, IsNil)
+	defer func() { l.Close() }()
+	go server.Serve(tls.NewListener(l, server.TLSConfig))
+
+	client, err := stomp.DialTLS("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}},
+		RootCAs:      pool,
+	}, stomp.ConnOpt.Login("guest", ""))
+	c.Assert(err, IsNil)
+
+	c.Check(auth.login, Equals, "guest")
+	c.Assert(auth.cert, NotNil)
+	c.Check(auth.cert.Subject.CommonName, Equals, "test-client")
+
+	err = client.Disconnect()
+	c.Assert(err, IsNil)
+}
+
+// Creates a certificate for testing, signed by parent. If parent is nil,
+// a self-signed CA certificate is created.
+func newTestCertificate(c *C, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return cert, key
+}
+
 func (s *ServerSuite) TestSendToQueuesAndTopics(c *C) {
 	ch := make(chan bool, 2)
 	println("number cpus:", runtime.NumCPU())
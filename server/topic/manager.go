@@ -1,15 +1,33 @@
 package topic
 
+import (
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server/metrics"
+)
+
 // Manager is a struct responsible for finding topics. Topics are
 // not created by the package user, rather they are created on demand
 // by the topic manager.
+//
+// A topic destination can contain wildcard segments (see WildcardOne,
+// WildcardOneOrMore and WildcardZeroOrMore), in which case subscribers
+// to the topic receive messages sent to all matching destinations.
 type Manager struct {
 	topics map[string]*Topic
+	root   node // topics indexed by destination segment
+
+	// Metrics records the messages delivered to subscriptions, by the
+	// destination of the message. NewManager sets it to new metrics,
+	// which can be replaced.
+	Metrics *metrics.Metrics
 }
 
 // NewManager creates a new topic manager.
 func NewManager() *Manager {
-	tm := &Manager{topics: make(map[string]*Topic)}
+	tm := &Manager{
+		topics:  make(map[string]*Topic),
+		Metrics: &metrics.Metrics{},
+	}
 	return tm
 }
 
@@ -19,6 +37,49 @@ func (tm *Manager) Find(destination string) *Topic {
 	if !ok {
 		t = newTopic(destination)
 		tm.topics[destination] = t
+		tm.root.add(t)
 	}
 	return t
 }
+
+// Remove removes the topic for the given destination if it has no
+// subscriptions, along with the metrics labelled with its destination.
+// The topic is created again if it is found later.
+func (tm *Manager) Remove(destination string) {
+	t, ok := tm.topics[destination]
+	if !ok || t.subs.Len() > 0 {
+		return
+	}
+	delete(tm.topics, destination)
+	tm.root.remove(split(destination))
+	tm.Metrics.Subscriptions.Delete(destination)
+	tm.Metrics.MessagesEnqueued.Delete(destination)
+	tm.Metrics.MessagesDelivered.Delete(destination)
+}
+
+// Enqueue sends a message to all subscriptions to topics that match the
+// destination, including topics with wildcard destinations, and that
+// select the message. Each subscription receives one copy of the message,
+// even if it is subscribed to more than one matching topic. Deliveries
+// are counted by the destination of the topic subscribed to.
+func (tm *Manager) Enqueue(destination string, f *frame.Frame) {
+	var subs []Subscription
+	seen := make(map[Subscription]bool)
+	tm.root.match(split(destination), func(t *Topic) {
+		delivered := 0
+		for e := t.subs.Front(); e != nil; e = e.Next() {
+			sub := e.Value.(Subscription)
+			if !seen[sub] {
+				seen[sub] = true
+				if selects(sub, f) {
+					subs = append(subs, sub)
+					delivered++
+				}
+			}
+		}
+		if delivered > 0 {
+			tm.Metrics.MessagesDelivered.With(t.destination).Add(int64(delivered))
+		}
+	})
+	send(subs, f)
+}
@@ -1,6 +1,7 @@
 package topic
 
 import (
+	"github.com/go-stomp/stomp/frame"
 	. "gopkg.in/check.v1"
 )
 
@@ -19,3 +20,171 @@ func (s *ManagerSuite) TestManager(c *C) {
 
 	c.Assert(mgr.Find("topic1"), Equals, t1)
 }
+
+func (s *ManagerSuite) TestWildcards(c *C) {
+	mgr := NewManager()
+
+	exact := &fakeSubscription{}
+	mgr.Find("/topic/orders.eu.created").Subscribe(exact)
+	one := &fakeSubscription{}
+	mgr.Find("/topic/orders.*.created").Subscribe(one)
+	oneOrMore := &fakeSubscription{}
+	mgr.Find("/topic/orders.>").Subscribe(oneOrMore)
+	zeroOrMore := &fakeSubscription{}
+	mgr.Find("/topic/#").Subscribe(zeroOrMore)
+	other := &fakeSubscription{}
+	mgr.Find("/topic/invoices.>").Subscribe(other)
+
+	// subscribed to two matching topics
+	both := &fakeSubscription{}
+	mgr.Find("/topic/orders.eu.*").Subscribe(both)
+	mgr.Find("/topic/orders.#").Subscribe(both)
+
+	f := frame.New(frame.MESSAGE, frame.Destination, "/topic/orders.eu.created")
+	mgr.Enqueue("/topic/orders.eu.created", f)
+
+	// only one subscription receives the frame without copying
+	uncopied := 0
+	for _, sub := range []*fakeSubscription{exact, one, oneOrMore, zeroOrMore, both} {
+		c.Assert(len(sub.Frames), Equals, 1)
+		c.Check(sub.Frames[0].Header.Get(frame.Destination), Equals, "/topic/orders.eu.created")
+		if sub.Frames[0] == f {
+			uncopied++
+		}
+	}
+	c.Check(uncopied, Equals, 1)
+	c.Check(len(other.Frames), Equals, 0)
+
+	mgr.Enqueue("/topic/orders", frame.New(frame.MESSAGE, frame.Destination, "/topic/orders"))
+	c.Check(len(oneOrMore.Frames), Equals, 1)
+	c.Check(len(zeroOrMore.Frames), Equals, 2)
+	c.Check(len(both.Frames), Equals, 2)
+
+	// wildcards only match segments with the same separator
+	slash := &fakeSubscription{}
+	mgr.Find("/topic/*").Subscribe(slash)
+	mgr.Enqueue("/topic.orders", frame.New(frame.MESSAGE, frame.Destination, "/topic.orders"))
+	c.Check(len(slash.Frames), Equals, 0)
+	c.Check(len(zeroOrMore.Frames), Equals, 2)
+}
+
+func (s *ManagerSuite) TestRemove(c *C) {
+	mgr := NewManager()
+	sub := &fakeSubscription{}
+	mgr.Find("/topic/a").Subscribe(sub)
+	mgr.Find("/topic/a.*").Subscribe(sub)
+	mgr.Enqueue("/topic/a.b", frame.New(frame.MESSAGE, frame.Destination, "/topic/a.b"))
+	c.Check(mgr.Metrics.MessagesDelivered.Values(), DeepEquals, map[string]int64{"/topic/a.*": 1})
+
+	// not removed while subscribed
+	mgr.Remove("/topic/a.*")
+	mgr.Enqueue("/topic/a.b", frame.New(frame.MESSAGE, frame.Destination, "/topic/a.b"))
+	c.Check(len(sub.Frames), Equals, 2)
+
+	mgr.Find("/topic/a.*").Unsubscribe(sub)
+	mgr.Remove("/topic/a.*")
+	c.Check(mgr.Metrics.MessagesDelivered.Values(), DeepEquals, map[string]int64{})
+	mgr.Enqueue("/topic/a.b", frame.New(frame.MESSAGE, frame.Destination, "/topic/a.b"))
+	c.Check(len(sub.Frames), Equals, 2)
+
+	mgr.Find("/topic/a").Unsubscribe(sub)
+	mgr.Remove("/topic/a")
+	c.Check(mgr.topics, HasLen, 0)
+	c.Check(mgr.root.children, HasLen, 0)
+}
+
+func (s *ManagerSuite) TestMatch(c *C) {
+	testCases := []struct {
+		pattern     string
+		destination string
+		match       bool
+	}{
+		{"/topic/a", "/topic/a", true},
+		{"/topic/a", "/topic/b", false},
+		{"/topic/a.b", "/topic/a/b", false},
+		{"/topic/*", "/topic/a", true},
+		{"/topic/*", "/topic/a.b", false},
+		{"/topic/*", "/topic.a", false},
+		{"/topic/a.*", "/topic/a/b", false},
+		{"/topic/a.>", "/topic/a/b", false},
+		{"/topic/a.>", "/topic/a.b/c", true},
+		{"/topic/a.#", "/topic/a/b", false},
+		{"/topic/*", "/topic/*", true},
+		{"/topic/a.*.c", "/topic/a.b.c", true},
+		{"/topic/a.*.c", "/topic/a.c", false},
+		{"/topic/a.>", "/topic/a.b", true},
+		{"/topic/a.>", "/topic/a.b.c", true},
+		{"/topic/a.>", "/topic/a", false},
+		{"/topic/a>", "/topic/a.b", false},
+		{"/topic/a.>.c", "/topic/a.>.c", true},
+		{"/topic/a.>.c", "/topic/a.b.c", false},
+		{"/topic/a.#", "/topic/a", true},
+		{"/topic/a.#", "/topic/a.b.c", true},
+		{"/topic/#.c", "/topic/a.b.c", true},
+		{"/topic/#.c", "/topic/a.b.d", false},
+		{"/topic/#", "/queue/a", false},
+	}
+
+	for _, tc := range testCases {
+		c.Check(Match(tc.pattern, tc.destination), Equals, tc.match,
+			Commentf("pattern=%s destination=%s", tc.pattern, tc.destination))
+		p := Compile(tc.pattern)
+		c.Check(p.String(), Equals, tc.pattern)
+		c.Check(p.Match(tc.destination), Equals, tc.match,
+			Commentf("pattern=%s destination=%s", tc.pattern, tc.destination))
+	}
+}
+
+func (s *ManagerSuite) TestValidate(c *C) {
+	c.Check(Validate("/topic/a"), IsNil)
+	c.Check(Validate("/queue/*"), IsNil)
+	c.Check(Validate("/topic/#.c"), IsNil)
+	c.Check(Validate("/topic/a.>"), IsNil)
+	c.Check(Validate(""), ErrorMatches, "empty pattern")
+	c.Check(Validate("/topic/>.c"), ErrorMatches, `">" must be the last segment`)
+	c.Check(Validate("/queue/ttl*"), ErrorMatches, `wildcard must be a whole segment: "ttl\*"`)
+	c.Check(Validate("/queue/[#]"), ErrorMatches, `wildcard must be a whole segment: "\[#\]"`)
+}
+
+func (s *ManagerSuite) TestCovers(c *C) {
+	testCases := []struct {
+		pattern     string
+		destination string
+		covers      bool
+	}{
+		{"/topic/a", "/topic/a", true},
+		{"/topic/a", "/topic/*", false},
+		{"/topic/*", "/topic/a", true},
+		{"/topic/*", "/topic/*", true},
+		{"/topic/*", "/topic/>", false},
+		{"/topic/*", "/topic/#", false},
+		{"/topic/*", "/topic/a.*", false},
+		{"/topic/*.b", "/topic/*.b", true},
+		{"/topic/*.b", "/topic/*.*", false},
+		{"/topic/>", "/topic/*", true},
+		{"/topic/>", "/topic/>", true},
+		{"/topic/>", "/topic/a.>", true},
+		{"/topic/>", "/topic/a.#", true},
+		{"/topic/>", "/topic/#", false},
+		{"/topic/>", "/topic.>", false},
+		{"/topic/a.>", "/topic/a/>", false},
+		{"/topic/a.>", "/topic/*.>", false},
+		{"/topic/#", "/topic/#", true},
+		{"/topic/#", "/topic/>", true},
+		{"/topic/#", "/topic/a.*.c", true},
+		{"/topic/#", "/topic/#.c", false},
+		{"/topic/#", "/topic.#", false},
+		{"/topic/a/#", "/topic/a/#.b", false},
+		{"/#", "/topic/#", true},
+		{"/#", "/#", true},
+		{"/#", "#", false},
+		{"/topic/#.c", "/topic/a.b.c", true},
+		{"/topic/#.c", "/topic/a.*.c", true},
+		{"/topic/#.c", "/topic/a.>", false},
+	}
+
+	for _, tc := range testCases {
+		c.Check(Compile(tc.pattern).Covers(Compile(tc.destination)), Equals, tc.covers,
+			Commentf("pattern=%s destination=%s", tc.pattern, tc.destination))
+	}
+}
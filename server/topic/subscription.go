@@ -9,3 +9,34 @@ type Subscription interface {
 	// Send a message frame to the topic subscriber.
 	SendTopicFrame(f *frame.Frame)
 }
+
+// SelectiveSubscription is implemented by subscriptions that only
+// receive some of the frames sent to the topic, for example
+// subscriptions with a message selector.
+type SelectiveSubscription interface {
+	Subscription
+
+	// Returns true if the frame should be sent to the subscriber.
+	Selects(f *frame.Frame) bool
+}
+
+// Returns true if the frame should be sent to the subscription.
+func selects(sub Subscription, f *frame.Frame) bool {
+	if s, ok := sub.(SelectiveSubscription); ok {
+		return s.Selects(f)
+	}
+	return true
+}
+
+// Sends a frame to each of the subscriptions. All subscriptions
+// receive a copy of the frame, except the last, which can have
+// the frame without copying.
+func send(subs []Subscription, f *frame.Frame) {
+	for i, sub := range subs {
+		if i == len(subs)-1 {
+			sub.SendTopicFrame(f)
+		} else {
+			sub.SendTopicFrame(f.Clone())
+		}
+	}
+}
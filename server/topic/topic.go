@@ -1,5 +1,13 @@
 /*
 Package topic provides implementations of server-side topics.
+
+Topic destinations are divided into segments by the '/' and '.'
+separators. A topic destination can contain wildcard segments, which
+match segments preceded by the same separator as the wildcard: "*"
+matches one segment, ">" at the end of a destination matches one or
+more segments, and "#" matches zero or more segments. So
+"/topic/orders.*" matches "/topic/orders.eu" but not "/topic/orders/eu"
+or "/topic/orders.eu.created".
 */
 package topic
 
@@ -42,32 +50,14 @@ func (t *Topic) Unsubscribe(sub Subscription) {
 	}
 }
 
-// Enqueue send a message to the topic. All subscriptions receive a copy
-// of the message.
+// Enqueue send a message to the topic. All subscriptions that select
+// the message receive a copy of it.
 func (t *Topic) Enqueue(f *frame.Frame) {
-	switch t.subs.Len() {
-	case 0:
-	// no subscription, so do nothing
-
-	case 1:
-		// only one subscription, so can send the frame
-		// without copying
-		sub := t.subs.Front().Value.(Subscription)
-		sub.SendTopicFrame(f)
-
-	default:
-		// more than one subscription, send clone for
-		// all subscriptions except the last, which can
-		// have the frame without copying
-		for e := t.subs.Front(); e != nil; e = e.Next() {
-			sub := e.Value.(Subscription)
-			if e.Next() == nil {
-				// the last in the list, send the frame
-				// without copying
-				sub.SendTopicFrame(f)
-			} else {
-				sub.SendTopicFrame(f.Clone())
-			}
+	var subs []Subscription
+	for e := t.subs.Front(); e != nil; e = e.Next() {
+		if sub := e.Value.(Subscription); selects(sub, f) {
+			subs = append(subs, sub)
 		}
 	}
+	send(subs, f)
 }
@@ -61,3 +61,36 @@ type fakeSubscription struct {
 func (s *fakeSubscription) SendTopicFrame(f *frame.Frame) {
 	s.Frames = append(s.Frames, f)
 }
+
+func (s *TopicSuite) TestTopicWithSelectiveSubscription(c *C) {
+	sub1 := &fakeSubscription{}
+	sub2 := &fakeSelectiveSubscription{header: "color", value: "red"}
+
+	topic := newTopic("destination")
+	topic.Subscribe(sub1)
+	topic.Subscribe(sub2)
+
+	red := frame.New(frame.MESSAGE,
+		frame.Destination, "destination",
+		"color", "red")
+	blue := frame.New(frame.MESSAGE,
+		frame.Destination, "destination",
+		"color", "blue")
+
+	topic.Enqueue(red)
+	topic.Enqueue(blue)
+
+	c.Assert(len(sub1.Frames), Equals, 2)
+	c.Assert(sub1.Frames[1], Equals, blue)
+	c.Assert(len(sub2.Frames), Equals, 1)
+	c.Assert(sub2.Frames[0], Equals, red)
+}
+
+type fakeSelectiveSubscription struct {
+	fakeSubscription
+	header, value string
+}
+
+func (s *fakeSelectiveSubscription) Selects(f *frame.Frame) bool {
+	return f.Header.Get(s.header) == s.value
+}
@@ -0,0 +1,333 @@
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Wildcard segments in topic destinations. Destinations are divided
+// into segments by the '/' and '.' characters, so the destination
+// "/topic/orders.eu.created" has the segments "topic", "orders", "eu"
+// and "created". Each segment is preceded by one separator, and a
+// wildcard only matches segments preceded by the same separator as the
+// wildcard, so "/topic/*" matches "/topic/orders" but not "/topic.orders".
+const (
+	// Matches exactly one segment.
+	WildcardOne = "*"
+
+	// Matches one or more segments. Only a wildcard when it is
+	// the last segment of the destination. The segments after the
+	// first matched segment can be preceded by either separator.
+	WildcardOneOrMore = ">"
+
+	// Matches zero or more segments. The segments after the first
+	// matched segment can be preceded by either separator.
+	WildcardZeroOrMore = "#"
+)
+
+// A segment of a destination, including the separator that precedes it,
+// so that "/topic/a.b" and "/topic/a/b" are different destinations.
+type segment struct {
+	sep  byte // '/', '.' or zero for the first segment
+	text string
+}
+
+// Splits a destination into segments.
+func split(destination string) []segment {
+	var segments []segment
+	var sep byte
+	start := 0
+	for i := 0; i < len(destination); i++ {
+		if c := destination[i]; c == '/' || c == '.' {
+			segments = append(segments, segment{sep, destination[start:i]})
+			sep, start = c, i+1
+		}
+	}
+	return append(segments, segment{sep, destination[start:]})
+}
+
+// Returns true if destination contains a wildcard segment.
+func hasWildcard(destination string) bool {
+	segments := split(destination)
+	for i, seg := range segments {
+		switch seg.text {
+		case WildcardOne, WildcardZeroOrMore:
+			return true
+		case WildcardOneOrMore:
+			if i == len(segments)-1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Node in a trie of topics, indexed by destination segment. Wildcard
+// segments are indexed by the separator that precedes them, and are
+// kept apart from the literal segments so that a destination containing
+// a literal "*" segment is not matched twice.
+type node struct {
+	children   map[segment]*node
+	one        map[byte]*node  // children for WildcardOne
+	oneOrMore  map[byte]*Topic // topics ending in WildcardOneOrMore
+	zeroOrMore map[byte]*node  // children for WildcardZeroOrMore
+	topic      *Topic          // topic ending at this node
+}
+
+// Returns the child of n for seg, creating it if necessary.
+func (n *node) child(seg segment) *node {
+	var children *map[byte]*node
+	switch seg.text {
+	case WildcardOne:
+		children = &n.one
+	case WildcardZeroOrMore:
+		children = &n.zeroOrMore
+	default:
+		if n.children == nil {
+			n.children = make(map[segment]*node)
+		}
+		child, ok := n.children[seg]
+		if !ok {
+			child = &node{}
+			n.children[seg] = child
+		}
+		return child
+	}
+	if *children == nil {
+		*children = make(map[byte]*node)
+	}
+	child, ok := (*children)[seg.sep]
+	if !ok {
+		child = &node{}
+		(*children)[seg.sep] = child
+	}
+	return child
+}
+
+// Adds a topic to the trie, at the position given by its destination.
+func (n *node) add(t *Topic) {
+	segments := split(t.destination)
+	for i, seg := range segments {
+		if seg.text == WildcardOneOrMore && i == len(segments)-1 {
+			if n.oneOrMore == nil {
+				n.oneOrMore = make(map[byte]*Topic)
+			}
+			n.oneOrMore[seg.sep] = t
+			return
+		}
+		n = n.child(seg)
+	}
+	n.topic = t
+}
+
+// Removes the topic at the position given by segments, and any nodes
+// left empty. Returns true if n is left empty.
+func (n *node) remove(segments []segment) bool {
+	switch {
+	case len(segments) == 0:
+		n.topic = nil
+	case segments[0].text == WildcardOneOrMore && len(segments) == 1:
+		delete(n.oneOrMore, segments[0].sep)
+	case segments[0].text == WildcardOne:
+		if child, ok := n.one[segments[0].sep]; ok && child.remove(segments[1:]) {
+			delete(n.one, segments[0].sep)
+		}
+	case segments[0].text == WildcardZeroOrMore:
+		if child, ok := n.zeroOrMore[segments[0].sep]; ok && child.remove(segments[1:]) {
+			delete(n.zeroOrMore, segments[0].sep)
+		}
+	default:
+		if child, ok := n.children[segments[0]]; ok && child.remove(segments[1:]) {
+			delete(n.children, segments[0])
+		}
+	}
+	return n.topic == nil && len(n.children) == 0 && len(n.one) == 0 &&
+		len(n.oneOrMore) == 0 && len(n.zeroOrMore) == 0
+}
+
+// Calls fn for each topic whose destination matches segments. A topic
+// can be matched more than once when its destination contains
+// WildcardZeroOrMore.
+func (n *node) match(segments []segment, fn func(t *Topic)) {
+	for sep, child := range n.zeroOrMore {
+		// zero or more segments, so try all remaining suffixes
+		child.match(segments, fn)
+		if len(segments) > 0 && segments[0].sep == sep {
+			for i := 1; i <= len(segments); i++ {
+				child.match(segments[i:], fn)
+			}
+		}
+	}
+	if len(segments) == 0 {
+		if n.topic != nil {
+			fn(n.topic)
+		}
+		return
+	}
+	if t, ok := n.oneOrMore[segments[0].sep]; ok {
+		fn(t)
+	}
+	if child, ok := n.one[segments[0].sep]; ok {
+		child.match(segments[1:], fn)
+	}
+	if child, ok := n.children[segments[0]]; ok {
+		child.match(segments[1:], fn)
+	}
+}
+
+// A Pattern is a compiled destination, which can contain wildcard
+// segments. Compile a pattern once to match it against many destinations.
+type Pattern struct {
+	destination string
+	root        *node // nil if the destination has no wildcard segments
+}
+
+// Compile returns the pattern for a destination.
+func Compile(destination string) *Pattern {
+	p := &Pattern{destination: destination}
+	if hasWildcard(destination) {
+		p.root = &node{}
+		p.root.add(&Topic{destination: destination})
+	}
+	return p
+}
+
+// String returns the destination that the pattern was compiled from.
+func (p *Pattern) String() string {
+	return p.destination
+}
+
+// Match returns true if destination matches the pattern.
+func (p *Pattern) Match(destination string) bool {
+	if destination == p.destination {
+		return true
+	}
+	if p.root == nil {
+		return false
+	}
+	matched := false
+	p.root.match(split(destination), func(*Topic) { matched = true })
+	return matched
+}
+
+// Match returns true if destination matches pattern, which can contain
+// wildcard segments. Use Compile to match the same pattern more than once.
+func Match(pattern, destination string) bool {
+	return Compile(pattern).Match(destination)
+}
+
+// Validate returns an error if pattern is not suitable for matching
+// destinations: if it is empty, or if it contains a wildcard character
+// that is not a wildcard segment, such as "/queue/orders*", which would
+// only match the destination with that literal name.
+func Validate(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty pattern")
+	}
+	segments := split(pattern)
+	for i, seg := range segments {
+		switch seg.text {
+		case WildcardOne, WildcardZeroOrMore:
+			continue
+		case WildcardOneOrMore:
+			if i == len(segments)-1 {
+				continue
+			}
+			return fmt.Errorf("%q must be the last segment", WildcardOneOrMore)
+		}
+		if strings.ContainsAny(seg.text, WildcardOne+WildcardOneOrMore+WildcardZeroOrMore) {
+			return fmt.Errorf("wildcard must be a whole segment: %q", seg.text)
+		}
+	}
+	return nil
+}
+
+// Covers returns true if p matches every destination that q matches,
+// so that a subscription to q can only receive messages sent to
+// destinations that match p.
+func (p *Pattern) Covers(q *Pattern) bool {
+	if q.root == nil {
+		return p.Match(q.destination)
+	}
+	return covers(wildcards(split(p.destination)), wildcards(split(q.destination)))
+}
+
+// Separator of a WildcardZeroOrMore segment that has already matched
+// a segment, after which it matches segments with either separator.
+const anySeparator = 0xff
+
+// A segment of a pattern, and the wildcard that it is, if any.
+type patternSegment struct {
+	segment
+	wildcard string
+}
+
+// Returns the pattern segments for the segments of a destination.
+func wildcards(segments []segment) []patternSegment {
+	ps := make([]patternSegment, len(segments))
+	for i, seg := range segments {
+		ps[i].segment = seg
+		switch {
+		case seg.text == WildcardOne, seg.text == WildcardZeroOrMore:
+			ps[i].wildcard = seg.text
+		case seg.text == WildcardOneOrMore && i == len(segments)-1:
+			ps[i].wildcard = seg.text
+		}
+	}
+	return ps
+}
+
+// Returns true if every destination matched by q is matched by p. It can
+// return false for some patterns that do cover q, but never returns true
+// for patterns that do not.
+func covers(p, q []patternSegment) bool {
+	if len(q) > 0 && q[0].wildcard == WildcardZeroOrMore {
+		// q[0] matches zero segments, or one segment followed by
+		// zero or more segments with either separator
+		more := append([]patternSegment{
+			{segment{q[0].sep, WildcardOne}, WildcardOne},
+			{segment{anySeparator, WildcardZeroOrMore}, WildcardZeroOrMore},
+		}, q[1:]...)
+		return covers(p, q[1:]) && covers(p, more)
+	}
+	if len(p) == 0 {
+		return len(q) == 0
+	}
+	head := p[0]
+	sepMatches := len(q) > 0 &&
+		(head.sep == anySeparator || head.sep == q[0].sep)
+	switch head.wildcard {
+	case WildcardZeroOrMore:
+		if len(p) == 1 {
+			// matches the remaining segments, if they start with head.sep
+			return len(q) == 0 || sepMatches
+		}
+		if covers(p[1:], q) {
+			return true
+		}
+		// WildcardOne is not matched when it can have either separator,
+		// which it only has when expanded from WildcardZeroOrMore above,
+		// so that the expansion cannot recurse forever
+		if !sepMatches || q[0].wildcard == WildcardOneOrMore ||
+			(q[0].wildcard == WildcardOne && q[0].sep == anySeparator) {
+			return false
+		}
+		// head matches q[0], and can match more segments
+		rest := append([]patternSegment{{segment{anySeparator, WildcardZeroOrMore}, WildcardZeroOrMore}}, p[1:]...)
+		return covers(rest, q[1:])
+	case WildcardOneOrMore:
+		// matches the remaining segments, if there are any
+		return sepMatches
+	case WildcardOne:
+		if !sepMatches || (q[0].wildcard != "" && q[0].wildcard != WildcardOne) {
+			return false
+		}
+		return covers(p[1:], q[1:])
+	default:
+		if len(q) == 0 || q[0].wildcard != "" || q[0].segment != head.segment {
+			return false
+		}
+		return covers(p[1:], q[1:])
+	}
+}
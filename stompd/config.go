@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/server"
+	"github.com/go-stomp/stomp/server/auth"
+	"github.com/go-stomp/stomp/server/queue"
+	"github.com/go-stomp/stomp/server/topic"
+)
+
+// config is the stompd configuration. It is read from the JSON file
+// specified with -config, if any, and command-line flags override the
+// settings in the file. For example:
+//
+//	{
+//		"listeners": [
+//			{"addr": ":61613"},
+//			{"addr": ":61614", "tls": {"cert": "server.pem", "key": "server.key"}},
+//			{"type": "websocket", "addr": ":8080", "path": "/stomp"}
+//		],
+//		"heart-beat": "1m",
+//		"auth": {"users": "users.txt", "acl": "acl.txt"},
+//		"storage": {"type": "file", "dir": "/var/lib/stompd", "sync": "interval"},
+//		"destinations": {
+//			"prefetch": 10,
+//			"max-deliveries": 5,
+//			"ttl": [{"pattern": "/queue/>", "ttl": "24h"}]
+//		},
+//		"log": {"level": "info", "format": "json"},
+//		"metrics": {"addr": "localhost:9100"},
+//		"admin": {"addr": "localhost:8161"}
+//	}
+type config struct {
+	Listeners       []listenerConfig   `json:"listeners"`
+	HeartBeat       duration           `json:"heart-beat"`
+	ShutdownTimeout duration           `json:"shutdown-timeout"`
+	Auth            authConfig         `json:"auth"`
+	Storage         storageConfig      `json:"storage"`
+	Destinations    destinationsConfig `json:"destinations"`
+	Log             logConfig          `json:"log"`
+	Metrics         metricsConfig      `json:"metrics"`
+	Admin           adminConfig        `json:"admin"`
+}
+
+type listenerConfig struct {
+	Type string     `json:"type"` // "tcp" (the default) or "websocket"
+	Addr string     `json:"addr"`
+	Path string     `json:"path"` // URL path, for websocket listeners
+	TLS  *tlsConfig `json:"tls"`
+}
+
+type tlsConfig struct {
+	Cert     string `json:"cert"`
+	Key      string `json:"key"`
+	ClientCA string `json:"client-ca"`
+}
+
+type authConfig struct {
+	Users    string `json:"users"`
+	Htpasswd string `json:"htpasswd"`
+	ACL      string `json:"acl"`
+}
+
+type storageConfig struct {
+	Type string `json:"type"` // "memory" (the default) or "file"
+	Dir  string `json:"dir"`
+	Sync string `json:"sync"`
+}
+
+type destinationsConfig struct {
+	Prefetch            int         `json:"prefetch"`
+	MaxDeliveries       int         `json:"max-deliveries"`
+	DeadLetterPrefix    string      `json:"dlq-prefix"`
+	DeadLetterExpired   bool        `json:"dlq-expired"`
+	ExpirySweepInterval duration    `json:"expiry-sweep-interval"`
+	TTL                 []ttlConfig `json:"ttl"`
+}
+
+type ttlConfig struct {
+	Pattern string   `json:"pattern"`
+	TTL     duration `json:"ttl"`
+}
+
+// The HTTP server for metrics is disabled if Addr is blank.
+type metricsConfig struct {
+	Addr string `json:"addr"`
+	Path string `json:"path"` // URL path for Prometheus, "/metrics" by default
+}
+
+// The HTTP server for the admin API is disabled if Addr is blank.
+type adminConfig struct {
+	Addr string `json:"addr"`
+}
+
+type logConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// duration is a time.Duration that is a string in JSON, eg "30s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("expected a duration string, eg \"30s\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// Flags for the listeners. If any of these are set on the command
+// line, they replace the listeners in the configuration file.
+var listenerFlags = map[string]bool{
+	"addr":          true,
+	"tls-cert":      true,
+	"tls-key":       true,
+	"tls-client-ca": true,
+	"ws-addr":       true,
+	"ws-path":       true,
+}
+
+// loadConfig returns the configuration read from filename, if it is not
+// blank, with the command-line flags applied. If filename is blank, the
+// configuration is taken from the flags.
+func loadConfig(filename string) (*config, error) {
+	// without a file, the flag values (set or default) are used,
+	// otherwise the file overrides the defaults and is overridden by
+	// the flags that are set
+	cfg := &config{}
+	allFlags := make(map[string]bool)
+	flag.VisitAll(func(f *flag.Flag) { allFlags[f.Name] = true })
+	cfg.applyFlags(allFlags)
+	if filename != "" {
+		if err := cfg.readFile(filename); err != nil {
+			return nil, err
+		}
+		setFlags := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+		cfg.applyFlags(setFlags)
+	}
+	cfg.setDefaults()
+	return cfg, nil
+}
+
+// Reads a JSON configuration file, overriding the current settings.
+func (cfg *config) readFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return fmt.Errorf("%s:%d: %s", filename, lineNumber(data, syntaxErr.Offset), err.Error())
+		case errors.As(err, &typeErr):
+			return fmt.Errorf("%s:%d: %s", filename, lineNumber(data, typeErr.Offset), err.Error())
+		}
+		return fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	return nil
+}
+
+// Returns the line number of an offset in data.
+func lineNumber(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Applies the values of the named flags to the configuration.
+func (cfg *config) applyFlags(names map[string]bool) {
+	for name := range names {
+		if listenerFlags[name] {
+			cfg.Listeners = listenersFromFlags()
+			break
+		}
+	}
+	if names["heart-beat"] {
+		cfg.HeartBeat = duration(*heartBeat)
+	}
+	if names["shutdown-timeout"] {
+		cfg.ShutdownTimeout = duration(*shutdownTimeout)
+	}
+	if names["users"] {
+		cfg.Auth.Users = *usersFile
+	}
+	if names["htpasswd"] {
+		cfg.Auth.Htpasswd = *htpasswdFile
+	}
+	if names["acl"] {
+		cfg.Auth.ACL = *aclFile
+	}
+	if names["queue-dir"] {
+		cfg.Storage.Dir = *queueDir
+		if *queueDir != "" {
+			cfg.Storage.Type = "file"
+		} else {
+			cfg.Storage.Type = "memory"
+		}
+	}
+	if names["queue-sync"] {
+		cfg.Storage.Sync = *queueSync
+	}
+	if names["prefetch"] {
+		cfg.Destinations.Prefetch = *prefetch
+	}
+	if names["max-deliveries"] {
+		cfg.Destinations.MaxDeliveries = *maxDeliveries
+	}
+	if names["dlq-prefix"] {
+		cfg.Destinations.DeadLetterPrefix = *deadLetterPrefix
+	}
+	if names["dlq-expired"] {
+		cfg.Destinations.DeadLetterExpired = *deadLetterExpired
+	}
+	if names["ttl"] {
+		cfg.Destinations.TTL = nil
+		for _, rule := range ttlRules {
+			cfg.Destinations.TTL = append(cfg.Destinations.TTL,
+				ttlConfig{Pattern: rule.Pattern, TTL: duration(rule.TTL)})
+		}
+	}
+	if names["log-level"] {
+		cfg.Log.Level = *logLevel
+	}
+	if names["log-format"] {
+		cfg.Log.Format = *logFormat
+	}
+	if names["metrics-addr"] {
+		cfg.Metrics.Addr = *metricsAddr
+	}
+	if names["admin-addr"] {
+		cfg.Admin.Addr = *adminAddr
+	}
+}
+
+// Returns the listeners specified by the command-line flags.
+func listenersFromFlags() []listenerConfig {
+	var tls *tlsConfig
+	if *tlsCertFile != "" {
+		tls = &tlsConfig{
+			Cert:     *tlsCertFile,
+			Key:      *tlsKeyFile,
+			ClientCA: *tlsClientCAFile,
+		}
+	}
+	listeners := []listenerConfig{{Type: "tcp", Addr: *listenAddr, TLS: tls}}
+	if *wsAddr != "" {
+		listeners = append(listeners, listenerConfig{
+			Type: "websocket",
+			Addr: *wsAddr,
+			Path: *wsPath,
+			TLS:  tls,
+		})
+	}
+	return listeners
+}
+
+// Sets the defaults for settings that are not in the file.
+func (cfg *config) setDefaults() {
+	for i := range cfg.Listeners {
+		l := &cfg.Listeners[i]
+		if l.Type == "" {
+			l.Type = "tcp"
+		}
+		if l.Type == "websocket" && l.Path == "" {
+			l.Path = "/stomp"
+		}
+	}
+	if cfg.Storage.Type == "" {
+		cfg.Storage.Type = "memory"
+	}
+	if cfg.Storage.Sync == "" {
+		cfg.Storage.Sync = "always"
+	}
+	if cfg.Log.Level == "" {
+		cfg.Log.Level = "info"
+	}
+	if cfg.Log.Format == "" {
+		cfg.Log.Format = "text"
+	}
+	if cfg.Metrics.Path == "" {
+		cfg.Metrics.Path = "/metrics"
+	}
+}
+
+// validate returns an error describing every invalid setting, or nil
+// if the configuration is valid. Files referred to by the configuration
+// are checked by load.
+func (cfg *config) validate() error {
+	var errs []error
+	invalid := func(name, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(name+": "+format, args...))
+	}
+
+	if len(cfg.Listeners) == 0 {
+		invalid("listeners", "at least one listener is required")
+	}
+	for i, l := range cfg.Listeners {
+		name := fmt.Sprintf("listeners[%d]", i)
+		switch l.Type {
+		case "tcp":
+		case "websocket":
+			if !strings.HasPrefix(l.Path, "/") {
+				invalid(name+".path", "must start with '/': %q", l.Path)
+			}
+		default:
+			invalid(name+".type", "must be tcp or websocket: %q", l.Type)
+		}
+		if l.Addr == "" {
+			invalid(name+".addr", "required")
+		}
+		if l.TLS != nil && (l.TLS.Cert == "" || l.TLS.Key == "") {
+			invalid(name+".tls", "cert and key are required")
+		}
+	}
+
+	if cfg.HeartBeat < 0 {
+		invalid("heart-beat", "must not be negative")
+	}
+	if cfg.ShutdownTimeout < 0 {
+		invalid("shutdown-timeout", "must not be negative")
+	}
+
+	switch cfg.Storage.Type {
+	case "memory":
+	case "file":
+		if cfg.Storage.Dir == "" {
+			invalid("storage.dir", "required for file storage")
+		}
+	default:
+		invalid("storage.type", "must be memory or file: %q", cfg.Storage.Type)
+	}
+	if _, err := syncPolicy(cfg.Storage.Sync); err != nil {
+		invalid("storage.sync", "%s", err.Error())
+	}
+
+	d := cfg.Destinations
+	if d.Prefetch < 0 {
+		invalid("destinations.prefetch", "must not be negative")
+	}
+	if d.MaxDeliveries < 0 {
+		invalid("destinations.max-deliveries", "must not be negative")
+	}
+	if d.ExpirySweepInterval < 0 {
+		invalid("destinations.expiry-sweep-interval", "must not be negative")
+	}
+	for i, rule := range d.TTL {
+		name := fmt.Sprintf("destinations.ttl[%d]", i)
+		if err := topic.Validate(rule.Pattern); err != nil {
+			invalid(name+".pattern", "invalid pattern: %q: %s", rule.Pattern, err.Error())
+		}
+		if rule.TTL < 0 {
+			invalid(name+".ttl", "must not be negative")
+		}
+	}
+
+	if !strings.HasPrefix(cfg.Metrics.Path, "/") {
+		invalid("metrics.path", "must start with '/': %q", cfg.Metrics.Path)
+	} else if cfg.Metrics.Path == expvarPath {
+		invalid("metrics.path", "%s is used by expvar", expvarPath)
+	}
+
+	if _, err := newLogger(cfg.Log.Level, cfg.Log.Format); err != nil {
+		invalid("log", "%s", err.Error())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns the queue sync policy with the given name.
+func syncPolicy(name string) (queue.SyncPolicy, error) {
+	switch name {
+	case "always":
+		return queue.SyncAlways, nil
+	case "interval":
+		return queue.SyncInterval, nil
+	case "never":
+		return queue.SyncNever, nil
+	}
+	return 0, fmt.Errorf("must be always, interval or never: %q", name)
+}
+
+// resources are loaded from the files referred to by the configuration.
+type resources struct {
+	tlsConfigs []*tls.Config // for each listener, nil if not using TLS
+	userFiles  []*auth.File
+	acl        *server.ACL
+}
+
+// load reads the files referred to by a valid configuration.
+func (cfg *config) load() (*resources, error) {
+	res := &resources{}
+	var errs []error
+	for i, l := range cfg.Listeners {
+		var tlsConfig *tls.Config
+		if l.TLS != nil {
+			var err error
+			tlsConfig, err = newTLSConfig(l.TLS.Cert, l.TLS.Key, l.TLS.ClientCA)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("listeners[%d].tls: %s", i, err.Error()))
+			}
+		}
+		res.tlsConfigs = append(res.tlsConfigs, tlsConfig)
+	}
+
+	userFiles, err := loadUserFiles(cfg.Auth.Users, cfg.Auth.Htpasswd)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("auth: %s", err.Error()))
+	}
+	res.userFiles = userFiles
+
+	if cfg.Auth.ACL != "" {
+		acl, err := server.LoadACL(cfg.Auth.ACL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("auth.acl: %s", err.Error()))
+		}
+		res.acl = acl
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Returns the server parameters for the configuration. The queue
+// storage, authentication and authorization are set by the caller.
+func (cfg *config) server() *server.Server {
+	d := cfg.Destinations
+	srv := &server.Server{
+		HeartBeat:           time.Duration(cfg.HeartBeat),
+		Prefetch:            d.Prefetch,
+		MaxDeliveries:       d.MaxDeliveries,
+		DeadLetterPrefix:    d.DeadLetterPrefix,
+		DeadLetterExpired:   d.DeadLetterExpired,
+		ExpirySweepInterval: time.Duration(d.ExpirySweepInterval),
+	}
+	for _, rule := range d.TTL {
+		srv.TTL = append(srv.TTL, server.DestinationTTL{
+			Pattern: rule.Pattern,
+			TTL:     time.Duration(rule.TTL),
+		})
+	}
+	return srv
+}
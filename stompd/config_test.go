@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// Runs all gocheck tests in this package.
+// See other *_test.go files for gocheck tests.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ConfigSuite struct{}
+
+var _ = Suite(&ConfigSuite{})
+
+func writeConfig(c *C, content string) string {
+	filename := filepath.Join(c.MkDir(), "stompd.json")
+	c.Assert(os.WriteFile(filename, []byte(content), 0600), IsNil)
+	return filename
+}
+
+func (s *ConfigSuite) TestLoadConfig(c *C) {
+	filename := writeConfig(c, `{
+	"listeners": [
+		{"addr": ":61613"},
+		{"type": "websocket", "addr": ":8080"}
+	],
+	"heart-beat": "30s",
+	"storage": {"type": "file", "dir": "/tmp/queues"},
+	"destinations": {
+		"max-deliveries": 5,
+		"ttl": [{"pattern": "/queue/*", "ttl": "1h"}]
+	}
+}`)
+
+	cfg, err := loadConfig(filename)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.validate(), IsNil)
+	c.Assert(cfg.Listeners, HasLen, 2)
+	c.Check(cfg.Listeners[0], DeepEquals, listenerConfig{Type: "tcp", Addr: ":61613"})
+	c.Check(cfg.Listeners[1], DeepEquals, listenerConfig{Type: "websocket", Addr: ":8080", Path: "/stomp"})
+	c.Check(cfg.Storage, DeepEquals, storageConfig{Type: "file", Dir: "/tmp/queues", Sync: "always"})
+	c.Check(cfg.Log, DeepEquals, logConfig{Level: "info", Format: "text"})
+
+	srv := cfg.server()
+	c.Check(srv.HeartBeat, Equals, 30*time.Second)
+	c.Check(srv.MaxDeliveries, Equals, 5)
+	c.Check(srv.Prefetch, Equals, *prefetch) // flag default
+	c.Assert(srv.TTL, HasLen, 1)
+	c.Check(srv.TTL[0].Pattern, Equals, "/queue/*")
+	c.Check(srv.TTL[0].TTL, Equals, time.Hour)
+}
+
+func (s *ConfigSuite) TestReadFileErrors(c *C) {
+	testCases := []struct {
+		content string
+		err     string
+	}{
+		{"{\n\"listeners\": [,]}", `.*stompd.json:2: invalid character ',' .*`},
+		{"{\n\n\"heart-beat\": 30}", `.*stompd.json: .*expected a duration string.*`},
+		{`{"heart-beat": "30 seconds"}`, `.*stompd.json: .*time: unknown unit.*`},
+		{`{"storage": {"kind": "file"}}`, `.*stompd.json: json: unknown field "kind"`},
+	}
+	for _, tc := range testCases {
+		cfg := &config{}
+		err := cfg.readFile(writeConfig(c, tc.content))
+		c.Check(err, ErrorMatches, tc.err, Commentf("%s", tc.content))
+	}
+}
+
+func (s *ConfigSuite) TestValidate(c *C) {
+	cfg := &config{
+		Listeners: []listenerConfig{
+			{Type: "udp", Addr: ":61613"},
+			{Type: "websocket", Path: "stomp"},
+			{Addr: ":61614", TLS: &tlsConfig{Cert: "server.pem"}},
+		},
+		HeartBeat: duration(-time.Second),
+		Storage:   storageConfig{Type: "file", Sync: "sometimes"},
+		Destinations: destinationsConfig{
+			Prefetch: -1,
+			TTL:      []ttlConfig{{Pattern: "/queue/ttl*", TTL: duration(time.Hour)}},
+		},
+		Log: logConfig{Level: "loud", Format: "text"},
+	}
+	cfg.setDefaults()
+	err := cfg.validate()
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, `listeners[0].type: must be tcp or websocket: "udp"
+listeners[1].path: must start with '/': "stomp"
+listeners[1].addr: required
+listeners[2].tls: cert and key are required
+heart-beat: must not be negative
+storage.dir: required for file storage
+storage.sync: must be always, interval or never: "sometimes"
+destinations.prefetch: must not be negative
+destinations.ttl[0].pattern: invalid pattern: "/queue/ttl*": wildcard must be a whole segment: "ttl*"
+log: slog: level string "loud": unknown name`)
+
+	cfg = &config{}
+	cfg.setDefaults()
+	c.Check(cfg.validate(), ErrorMatches, "listeners: at least one listener is required")
+}
+
+func (s *ConfigSuite) TestLoad(c *C) {
+	cfg := &config{
+		Listeners: []listenerConfig{{Addr: ":61614", TLS: &tlsConfig{Cert: "missing.pem", Key: "missing.key"}}},
+		Auth:      authConfig{ACL: writeConfig(c, "alice rw\n")},
+	}
+	cfg.setDefaults()
+	c.Assert(cfg.validate(), IsNil)
+	_, err := cfg.load()
+	c.Check(err, ErrorMatches, "(?s)listeners\\[0\\].tls: open missing.pem: .*\nauth.acl: .*line 1: .*")
+}
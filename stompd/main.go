@@ -1,7 +1,35 @@
 /*
 A simple, stand-alone STOMP server.
 
-TODO: graceful shutdown
+The server shuts down gracefully when it receives SIGINT or SIGTERM:
+connected clients are sent an ERROR frame and disconnected, and
+unacknowledged messages are requeued before queue storage is closed.
+
+Clients are authenticated if a users file (-users) or an htpasswd file
+(-htpasswd) is specified, otherwise all clients are accepted. A users
+file has one "login hash" entry per line, where hash is the bcrypt hash
+of the passcode, as printed by "stompd -hash-passcode" when the passcode
+is typed on standard input. The files are reloaded when the server
+receives SIGHUP.
+
+The server can be configured with a JSON file (-config) instead of, or
+as well as, command-line flags. The file can specify several listeners,
+each with its own TLS settings, and flags that are set on the command
+line override the settings in the file. "stompd -check-config" checks
+the configuration and the files it refers to, reports any errors, and
+exits without starting the server. The format of the file is described
+in config.go.
+
+Metrics describing connections, subscriptions and messages by
+destination are served over HTTP if -metrics-addr is specified, at
+/metrics in the Prometheus text format, and at /debug/vars with expvar.
+
+The admin API (-admin-addr) lists connections, subscriptions and
+destinations, and can browse, purge and move queued messages, and close
+connections (see server.Server.AdminHandler). When clients are
+authenticated, admin requests use HTTP basic authentication with the
+same users, and when an access control list is specified, a user needs
+admin permission on /admin, or on the queue for queue requests.
 
 TODO: UNIX daemon functionality
 
@@ -12,52 +40,340 @@ TODO: Logging options (syslog, windows event log)
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-stomp/stomp/server"
+	"github.com/go-stomp/stomp/server/auth"
+	"github.com/go-stomp/stomp/server/queue"
+	"github.com/go-stomp/stomp/server/topic"
+	"github.com/go-stomp/stomp/websocket"
 )
 
-// TODO: experimenting with ways to gracefully shutdown the server,
-// at the moment it just dies ungracefully on SIGINT.
+var listenAddr = flag.String("addr", ":61613", "Listen address")
+var helpFlag = flag.Bool("help", false, "Show this help text")
+var tlsCertFile = flag.String("tls-cert", "", "TLS certificate file, enables TLS")
+var tlsKeyFile = flag.String("tls-key", "", "TLS private key file")
+var tlsClientCAFile = flag.String("tls-client-ca", "", "CA certificates for verifying client certificates, enables mutual TLS")
+var wsAddr = flag.String("ws-addr", "", "Listen address for STOMP over WebSocket, disabled if blank")
+var wsPath = flag.String("ws-path", "/stomp", "URL path for STOMP over WebSocket")
+var queueDir = flag.String("queue-dir", "", "Directory for persistent queue storage, queues are kept in memory if blank")
+var queueSync = flag.String("queue-sync", "always", "When to flush persistent queue storage to disk: always, interval or never")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "Time to wait for clients to disconnect when shutting down")
+var prefetch = flag.Int("prefetch", server.DefaultPrefetch, "Default number of unacknowledged messages sent to each queue subscription")
+var maxDeliveries = flag.Int("max-deliveries", 0, "Number of times a queue message is delivered before it is moved to a dead-letter queue, unlimited if zero")
+var deadLetterPrefix = flag.String("dlq-prefix", queue.DefaultDeadLetterPrefix, "Destination prefix for dead-letter queues")
+var deadLetterExpired = flag.Bool("dlq-expired", false, "Move expired queue messages to dead-letter queues instead of discarding them")
+var ttlRules ttlFlag
+var usersFile = flag.String("users", "", "Users file with bcrypt-hashed passcodes for authenticating clients")
+var htpasswdFile = flag.String("htpasswd", "", "Apache htpasswd file for authenticating clients")
+var hashPasscode = flag.Bool("hash-passcode", false, "Print the hash of a passcode read from standard input for a users file, and exit")
+var aclFile = flag.String("acl", "", "Access control list file authorizing clients to send to and subscribe to destinations, all clients are authorized if blank")
+var logLevel = flag.String("log-level", "info", "Minimum level of log messages: debug, info, warn or error")
+var logFormat = flag.String("log-format", "text", "Format of log messages: text or json")
+var heartBeat = flag.Duration("heart-beat", server.DefaultHeartBeat, "Preferred heart-beat interval for client connections")
+var configFile = flag.String("config", "", "JSON configuration file, flags that are set override its settings")
+var metricsAddr = flag.String("metrics-addr", "", "Listen address for the HTTP server providing metrics at /metrics (Prometheus) and /debug/vars (expvar), disabled if blank")
+var adminAddr = flag.String("admin-addr", "", "Listen address for the HTTP server providing the admin API, disabled if blank")
+var checkConfig = flag.Bool("check-config", false, "Check the configuration, including the files it refers to, and exit")
 
-/*
+func init() {
+	flag.Var(&ttlRules, "ttl", "Default message time-to-live for destinations matching a pattern, as pattern=duration (eg /queue/>=1h), can be repeated")
+}
 
 func main() {
+	flag.Parse()
+	if *helpFlag {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *hashPasscode {
+		passcode, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Fatalf("failed to read passcode: %s", err.Error())
+		}
+		hash, err := auth.HashPasscode(strings.TrimRight(passcode, "\r\n"))
+		if err != nil {
+			log.Fatalf("failed to hash passcode: %s", err.Error())
+		}
+		fmt.Println(hash)
+		return
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %s", err.Error())
+	}
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("invalid configuration:\n%s", err.Error())
+	}
+	res, err := cfg.load()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%s", err.Error())
+	}
+	if *checkConfig {
+		fmt.Println("configuration is valid")
+		return
+	}
+
+	logger, err := newLogger(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		log.Fatalf("failed to configure logging: %s", err.Error())
+	}
+	// the standard log package is also used for messages from stompd
+	slog.SetDefault(logger)
+
 	// create a channel for listening for termination signals
 	stopChannel := newStopChannel()
 
-	for {
-		select {
-		case sig := <-stopChannel:
-			log.Println("received signal:", sig)
-			break
+	// clients connecting via all listeners share the same server
+	srv := cfg.server()
+	srv.Logger = logger
+
+	switch len(res.userFiles) {
+	case 1:
+		srv.Authenticator = res.userFiles[0]
+	case 2:
+		srv.Authenticator = auth.Chain{res.userFiles[0], res.userFiles[1]}
+	}
+	reloadChannel := newReloadChannel()
+	go func() {
+		for range reloadChannel {
+			for _, f := range res.userFiles {
+				if err := f.Reload(); err != nil {
+					logger.Error("failed to reload users", "error", err)
+				} else {
+					logger.Info("reloaded users", "file", f.Filename())
+				}
+			}
+		}
+	}()
+
+	if res.acl != nil {
+		srv.Authorizer = res.acl
+	}
+
+	if cfg.Storage.Type == "file" {
+		options := queue.FileQueueOptions{Logger: logger}
+		options.Sync, _ = syncPolicy(cfg.Storage.Sync)
+		qstore, err := queue.NewFileQueueStorage(cfg.Storage.Dir, options)
+		if err != nil {
+			log.Fatalf("failed to open queue storage: %s", err.Error())
+		}
+		srv.QueueStorage = qstore
+	}
+
+	var httpServers []*http.Server
+	for i, lc := range cfg.Listeners {
+		tlsConfig := res.tlsConfigs[i]
+		if lc.Type == "websocket" {
+			httpServers = append(httpServers, serveWebSocket(srv, lc, tlsConfig))
+			continue
+		}
+
+		l, err := net.Listen("tcp", lc.Addr)
+		if err != nil {
+			log.Fatalf("failed to listen: %s", err.Error())
+		}
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		go func() {
+			log.Println("listening on", l.Addr().Network(), l.Addr().String())
+			if err := srv.Serve(l); err != server.ErrServerClosed {
+				log.Fatalf("failed to serve: %s", err.Error())
+			}
+		}()
+	}
+
+	if cfg.Metrics.Addr != "" {
+		httpServers = append(httpServers, serveMetrics(srv, cfg.Metrics))
+	}
+	if cfg.Admin.Addr != "" {
+		httpServers = append(httpServers, serveAdmin(srv, cfg.Admin))
+	}
+
+	sig := <-stopChannel
+	log.Println("received signal:", sig, "shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	for _, httpServer := range httpServers {
+		httpServer.Shutdown(ctx)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("shutdown:", err)
+	}
+	log.Println("shutdown complete")
+}
+
+// serveWebSocket starts an HTTP server accepting STOMP over WebSocket
+// connections for srv, and returns it so that it can be shut down.
+func serveWebSocket(srv *server.Server, lc listenerConfig, tlsConfig *tls.Config) *http.Server {
+	wsl := websocket.NewListener()
+	mux := http.NewServeMux()
+	mux.Handle(lc.Path, wsl)
+	httpServer := &http.Server{
+		Addr:      lc.Addr,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	go func() {
+		log.Println("listening for websocket on", lc.Addr, lc.Path)
+		var err error
+		if tlsConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatalf("failed to listen for websocket: %s", err.Error())
 		}
+	}()
+	go srv.Serve(wsl)
+	return httpServer
+}
+
+// URL path of the expvar variables, including the server metrics.
+const expvarPath = "/debug/vars"
+
+// serveMetrics starts an HTTP server providing the server metrics, in the
+// Prometheus text format and as expvar variables, and returns it so that
+// it can be shut down.
+func serveMetrics(srv *server.Server, mc metricsConfig) *http.Server {
+	expvar.Publish("stomp", srv.Metrics())
+	mux := http.NewServeMux()
+	mux.Handle(mc.Path, srv.Metrics())
+	mux.Handle(expvarPath, expvar.Handler())
+	httpServer := &http.Server{Addr: mc.Addr, Handler: mux}
+
+	go func() {
+		log.Println("serving metrics on", mc.Addr, mc.Path)
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("failed to serve metrics: %s", err.Error())
+		}
+	}()
+	return httpServer
+}
+
+// serveAdmin starts an HTTP server providing the admin API, and returns
+// it so that it can be shut down.
+func serveAdmin(srv *server.Server, ac adminConfig) *http.Server {
+	httpServer := &http.Server{Addr: ac.Addr, Handler: srv.AdminHandler()}
+
+	go func() {
+		log.Println("serving admin API on", ac.Addr)
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("failed to serve admin API: %s", err.Error())
+		}
+	}()
+	return httpServer
+}
+
+// ttlFlag is a flag that can be repeated to specify
+// default time-to-live values by destination pattern.
+type ttlFlag []server.DestinationTTL
+
+func (f *ttlFlag) String() string {
+	var rules []string
+	for _, rule := range *f {
+		rules = append(rules, rule.Pattern+"="+rule.TTL.String())
 	}
+	return strings.Join(rules, ",")
+}
 
+func (f *ttlFlag) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return fmt.Errorf("expected pattern=duration: %s", value)
+	}
+	ttl, err := time.ParseDuration(value[i+1:])
+	if err != nil {
+		return err
+	}
+	if err := topic.Validate(value[:i]); err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", value[:i], err.Error())
+	}
+	*f = append(*f, server.DestinationTTL{Pattern: value[:i], TTL: ttl})
+	return nil
 }
-*/
 
-var listenAddr = flag.String("addr", ":61613", "Listen address")
-var helpFlag = flag.Bool("help", false, "Show this help text")
+// newLogger creates a logger that writes to standard error in the
+// specified format, discarding messages below the specified level.
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format: %s", format)
+}
 
-func main() {
-	flag.Parse()
-	if *helpFlag {
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-		flag.PrintDefaults()
-		os.Exit(1)
+// loadUserFiles loads the users file and the htpasswd file, if their
+// names are not blank.
+func loadUserFiles(usersFile, htpasswdFile string) ([]*auth.File, error) {
+	var files []*auth.File
+	if usersFile != "" {
+		f, err := auth.LoadUsers(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if htpasswdFile != "" {
+		f, err := auth.LoadHtpasswd(htpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
 	}
+	return files, nil
+}
 
-	l, err := net.Listen("tcp", *listenAddr)
+// newTLSConfig creates the TLS configuration for the server. If
+// clientCAFile is not blank, clients must present a certificate signed
+// by one of the certificate authorities in the file.
+func newTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
-		log.Fatalf("failed to listen: %s", err.Error())
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
 	}
-	defer func() { l.Close() }()
 
-	log.Println("listening on", l.Addr().Network(), l.Addr().String())
-	server.Serve(l)
+	return config, nil
 }
@@ -1,3 +1,5 @@
+//go:build !windows
+
 package main
 
 import (
@@ -9,9 +11,10 @@ import (
 // setupStopSignals sets up UNIX-specific signals for terminating
 // the program
 func setupStopSignals(signalChannel chan os.Signal) {
-	// TODO: not sure whether SIGHUP should be used here, only if not in
-	// daemon mode
-	signal.Notify(signalChannel, syscall.SIGHUP)
-
 	signal.Notify(signalChannel, syscall.SIGTERM)
 }
+
+// setupReloadSignals sets up SIGHUP for reloading the users files
+func setupReloadSignals(signalChannel chan os.Signal) {
+	signal.Notify(signalChannel, syscall.SIGHUP)
+}
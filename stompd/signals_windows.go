@@ -4,10 +4,15 @@ import (
 	"os"
 )
 
-func signals(signalChannel chan os.Signal) {
+func setupStopSignals(signalChannel chan os.Signal) {
 	// Windows has no other signals other than os.Interrupt
 
 	// TODO: What might be good here is to simulate a signal
 	// if running as a Windows service and the stop request is
 	// received. Not sure how to do this though.
 }
+
+func setupReloadSignals(signalChannel chan os.Signal) {
+	// Windows has no equivalent of SIGHUP, so the users
+	// files cannot be reloaded
+}
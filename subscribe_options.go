@@ -1,6 +1,8 @@
 package stomp
 
 import (
+	"sync"
+
 	"github.com/go-stomp/stomp/frame"
 )
 
@@ -16,6 +18,52 @@ var SubscribeOpt struct {
 	// Header provides the opportunity to include custom header entries
 	// in the SUBSCRIBE frame that the client sends to the server.
 	Header func(key, value string) func(*frame.Frame) error
+
+	// Receipt specifies that the client should request acknowledgement
+	// from the server before the subscribe (or unsubscribe) operation
+	// successfully completes.
+	Receipt func(*frame.Frame) error
+
+	// Buffer specifies the number of received messages buffered for the
+	// subscription until they are read from its C channel, and the action
+	// taken when a message arrives and the buffer is full. If not
+	// specified, the subscription uses the values specified by the
+	// ConnOpt.SubscriptionBuffer connect option. Has no effect unless
+	// passed to Conn.Subscribe.
+	Buffer func(capacity int, overflow OverflowPolicy) func(*frame.Frame) error
+}
+
+// subscribeOptions contains the options for a subscription that
+// are not sent to the server in the SUBSCRIBE frame.
+type subscribeOptions struct {
+	Capacity int
+	Overflow OverflowPolicy
+}
+
+// Options of the subscriptions being created by Conn.Subscribe, keyed
+// by SUBSCRIBE frame. The option functions only have access to the
+// frame, so the options are attached to the frame long enough to run
+// the option functions, as for connect options.
+var pendingSubscribeOptions sync.Map // map[*frame.Frame]*subscribeOptions
+
+func newSubscribeOptions(f *frame.Frame, co *connOptions, opts []func(*frame.Frame) error) (*subscribeOptions, error) {
+	so := &subscribeOptions{
+		Capacity: co.SubscriptionBuffer,
+		Overflow: co.Overflow,
+	}
+
+	pendingSubscribeOptions.Store(f, so)
+	defer pendingSubscribeOptions.Delete(f)
+
+	for _, opt := range opts {
+		if opt == nil {
+			return nil, ErrNilOption
+		}
+		if err := opt(f); err != nil {
+			return nil, err
+		}
+	}
+	return so, nil
 }
 
 func init() {
@@ -32,11 +80,37 @@ func init() {
 	SubscribeOpt.Header = func(key, value string) func(*frame.Frame) error {
 		return func(f *frame.Frame) error {
 			if f.Command != frame.SUBSCRIBE &&
-			   f.Command != frame.UNSUBSCRIBE {
+				f.Command != frame.UNSUBSCRIBE {
 				return ErrInvalidCommand
 			}
 			f.Header.Add(key, value)
 			return nil
 		}
 	}
+
+	SubscribeOpt.Receipt = func(f *frame.Frame) error {
+		if f.Command != frame.SUBSCRIBE &&
+			f.Command != frame.UNSUBSCRIBE {
+			return ErrInvalidCommand
+		}
+		f.Header.Set(frame.Receipt, allocateId())
+		return nil
+	}
+
+	SubscribeOpt.Buffer = func(capacity int, overflow OverflowPolicy) func(*frame.Frame) error {
+		return func(f *frame.Frame) error {
+			if f.Command != frame.SUBSCRIBE {
+				return ErrInvalidCommand
+			}
+			if err := checkBuffer(capacity, overflow); err != nil {
+				return err
+			}
+			if so, ok := pendingSubscribeOptions.Load(f); ok {
+				so := so.(*subscribeOptions)
+				so.Capacity = capacity
+				so.Overflow = overflow
+			}
+			return nil
+		}
+	}
 }
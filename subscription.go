@@ -1,9 +1,10 @@
 package stomp
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-stomp/stomp/frame"
 )
@@ -12,6 +13,15 @@ import (
 // a destination. The subscription is created by calling Conn.Subscribe.
 //
 // Once a client has subscribed, it can receive messages from the C channel.
+//
+// Messages received from the STOMP server are buffered until they are read
+// from the C channel. If the client does not read messages from the C
+// channel quickly enough, the buffer fills and the subscription's overflow
+// policy applies. By default the client stops reading messages from the
+// server until there is room in the buffer, and the subscription fails if
+// there is no room before the block timeout expires. See
+// ConnOpt.SubscriptionBuffer and SubscribeOpt.Buffer for alternative
+// policies.
 type Subscription struct {
 	C              chan *Message
 	id             string
@@ -20,11 +30,19 @@ type Subscription struct {
 	ackMode        AckMode
 	completed      bool
 	completedMutex *sync.Mutex
+	done           chan struct{}  // closed when the client unsubscribes
+	capacity       int            // maximum number of buffered messages
+	overflow       OverflowPolicy // action taken when the buffer is full
+	blockTimeout   time.Duration  // maximum time blocked by OverflowBlock
+	dropped        uint64         // number of messages discarded, atomic
 }
 
-// BUG(jpj): If the client does not read messages from the Subscription.C
-// channel quickly enough, the client will stop reading messages from the
-// server.
+// BUG(jpj): With the default OverflowBlock policy, if the client does not
+// read messages from the Subscription.C channel quickly enough, the client
+// will stop reading messages from the server once the subscription's
+// buffer is full, until the block timeout expires and the subscription
+// fails. Use one of the other overflow policies to prevent a slow
+// subscription from blocking the connection.
 
 // Identification for this subscription. Unique among
 // all subscriptions for the same Client.
@@ -46,12 +64,19 @@ func (s *Subscription) AckMode() AckMode {
 // Active returns whether the subscription is still active.
 // Returns false if the subscription has been unsubscribed.
 func (s *Subscription) Active() bool {
-	return !s.completed
+	return !s.isCompleted()
+}
+
+// Dropped returns the number of messages received from the server
+// that have been discarded because the subscription's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
 }
 
-// Unsubscribes and closes the channel C.
+// Unsubscribes and closes the channel C. Any messages that have been
+// received from the server but not yet read from C are discarded.
 func (s *Subscription) Unsubscribe(opts ...func(*frame.Frame) error) error {
-	if s.completed {
+	if s.isCompleted() {
 		return ErrCompletedSubscription
 	}
 	f := frame.New(frame.UNSUBSCRIBE, frame.Id, s.id)
@@ -66,87 +91,213 @@ func (s *Subscription) Unsubscribe(opts ...func(*frame.Frame) error) error {
 		}
 	}
 
-	s.conn.sendFrame(f)
+	if !s.complete() {
+		return ErrCompletedSubscription
+	}
+	close(s.done)
+	return s.conn.sendFrame(f)
+}
+
+// Mark the subscription as completed. Returns false if it
+// was already completed.
+func (s *Subscription) complete() bool {
 	s.completedMutex.Lock()
-	if !s.completed {
-		s.completed = true
-		close(s.C)
+	defer s.completedMutex.Unlock()
+	if s.completed {
+		return false
 	}
-	s.completedMutex.Unlock()
-	return nil
+	s.completed = true
+	return true
+}
+
+// Returns true if the subscription has been completed. The readLoop
+// go-routine completes the subscription if it fails, so completed is
+// only read while holding the mutex.
+func (s *Subscription) isCompleted() bool {
+	s.completedMutex.Lock()
+	defer s.completedMutex.Unlock()
+	return s.completed
 }
 
 // Read a message from the subscription. This is a convenience
 // method: many callers will prefer to read from the channel C
 // directly.
 func (s *Subscription) Read() (*Message, error) {
-	if s.completed {
-		return nil, ErrCompletedSubscription
-	}
-	msg, ok := <-s.C
-	if !ok {
+	return s.ReadContext(context.Background())
+}
+
+// ReadContext is like Read, but returns ctx.Err() if ctx is cancelled
+// or its deadline expires before a message is available. The subscription
+// remains active and subsequent messages can still be read.
+func (s *Subscription) ReadContext(ctx context.Context) (*Message, error) {
+	if s.isCompleted() {
 		return nil, ErrCompletedSubscription
 	}
-	if msg.Err != nil {
-		return nil, msg.Err
+	select {
+	case msg, ok := <-s.C:
+		if !ok {
+			return nil, ErrCompletedSubscription
+		}
+		if msg.Err != nil {
+			return nil, msg.Err
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	return msg, nil
 }
 
+// readLoop is a go-routine that receives frames for the subscription
+// from the processLoop go-routine, buffers them, and delivers them to
+// the C channel. The processLoop closes ch when the server has
+// acknowledged an UNSUBSCRIBE frame, or when the connection fails.
 func (s *Subscription) readLoop(ch chan *frame.Frame) {
+	var buffer []*Message
+
+	// When closing is set, no more messages are accepted and C is closed
+	// once the buffer is empty. If draining is also set, frames continue
+	// to be read from ch until it is closed, because the processLoop may
+	// still have frames for this subscription.
+	closing, draining := false, false
+	done := s.done
+	closed := false
+
+	// Fails the subscription because the buffer has overflowed. The
+	// client program receives ErrSubscriptionOverflow after any
+	// buffered messages.
+	overflowed := func() {
+		buffer = append(buffer, &Message{
+			Err:          ErrSubscriptionOverflow,
+			Conn:         s.conn,
+			Subscription: s,
+		})
+		closing, draining = true, true
+		if s.complete() {
+			// Cannot wait here, the processLoop might be
+			// blocked sending to this go-routine.
+			go s.conn.sendFrame(frame.New(frame.UNSUBSCRIBE, frame.Id, s.id))
+		}
+	}
+
+	// Running while reading from the server is blocked by OverflowBlock.
+	var blocked *time.Timer
+	defer func() {
+		if blocked != nil {
+			blocked.Stop()
+		}
+	}()
+
 	for {
-		if s.completed {
-			return
+		// Unsubscribing takes priority over delivering buffered messages.
+		select {
+		case <-done:
+			done = nil
+			buffer = nil
+			closing, draining = true, true
+		default:
 		}
 
-		f, ok := <-ch
-		if !ok {
+		if closing && len(buffer) == 0 && !closed {
+			close(s.C)
+			closed = true
+		}
+		if closed && (ch == nil || !draining) {
 			return
 		}
 
-		if f.Command == frame.MESSAGE {
-			destination := f.Header.Get(frame.Destination)
-			contentType := f.Header.Get(frame.ContentType)
-			msg := &Message{
-				Destination:  destination,
-				ContentType:  contentType,
-				Conn:         s.conn,
-				Subscription: s,
-				Header:       f.Header,
-				Body:         f.Body,
+		var out chan *Message
+		var next *Message
+		if len(buffer) > 0 {
+			out = s.C
+			next = buffer[0]
+		}
+
+		in := ch
+		var timeout <-chan time.Time
+		if !closing && s.overflow == OverflowBlock && len(buffer) >= s.capacity {
+			// stop reading from the server until there is room,
+			// or until the block timeout expires
+			in = nil
+			if blocked == nil && s.blockTimeout > 0 {
+				blocked = time.NewTimer(s.blockTimeout)
 			}
-			s.completedMutex.Lock()
-			if !s.completed {
-				s.C <- msg
+			if blocked != nil {
+				timeout = blocked.C
 			}
-			s.completedMutex.Unlock()
-		} else if f.Command == frame.ERROR {
-			message, _ := f.Header.Contains(frame.Message)
-			text := fmt.Sprintf("Subscription %s: %s: ERROR message:%s",
-				s.id,
-				s.destination,
-				message)
-			log.Println(text)
-			contentType := f.Header.Get(frame.ContentType)
-			msg := &Message{
-				Err: &Error{
-					Message: f.Header.Get(frame.Message),
-					Frame:   f,
-				},
-				ContentType:  contentType,
-				Conn:         s.conn,
-				Subscription: s,
-				Header:       f.Header,
-				Body:         f.Body,
+		} else if blocked != nil {
+			blocked.Stop()
+			blocked = nil
+		}
+
+		select {
+		case f, ok := <-in:
+			if !ok {
+				ch = nil
+				closing = true
+				continue
 			}
-			s.completedMutex.Lock()
-			if !s.completed {
-				s.completed = true
-				s.C <- msg
-				close(s.C)
+			if closing {
+				// discard anything received after the subscription has finished
+				continue
 			}
-			s.completedMutex.Unlock()
-			return
+
+			switch f.Command {
+			case frame.MESSAGE:
+				if len(buffer) >= s.capacity {
+					switch s.overflow {
+					case OverflowDropOldest:
+						buffer = buffer[1:]
+						atomic.AddUint64(&s.dropped, 1)
+					case OverflowDropNewest:
+						atomic.AddUint64(&s.dropped, 1)
+						continue
+					case OverflowError:
+						atomic.AddUint64(&s.dropped, 1)
+						overflowed()
+						continue
+					}
+				}
+				buffer = append(buffer, &Message{
+					Destination:  f.Header.Get(frame.Destination),
+					ContentType:  f.Header.Get(frame.ContentType),
+					Conn:         s.conn,
+					Subscription: s,
+					Header:       f.Header,
+					Body:         f.Body,
+				})
+
+			case frame.ERROR:
+				s.conn.options.Logger.Error("stomp: subscription received ERROR",
+					"subscription", s.id,
+					"destination", s.destination,
+					"message", f.Header.Get(frame.Message))
+				buffer = append(buffer, &Message{
+					Err: &Error{
+						Message: f.Header.Get(frame.Message),
+						Frame:   f,
+					},
+					ContentType:  f.Header.Get(frame.ContentType),
+					Conn:         s.conn,
+					Subscription: s,
+					Header:       f.Header,
+					Body:         f.Body,
+				})
+				s.complete()
+				closing = true
+			}
+
+		case out <- next:
+			buffer[0] = nil
+			buffer = buffer[1:]
+
+		case <-timeout:
+			blocked = nil
+			overflowed()
+
+		case <-done:
+			done = nil
+			buffer = nil
+			closing, draining = true, true
 		}
 	}
 }
@@ -0,0 +1,177 @@
+package stomp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/testutil"
+	. "gopkg.in/check.v1"
+)
+
+// Connects with the specified subscription buffer options and subscribes.
+// The server sends count messages in response to a SEND frame, followed
+// by the RECEIPT for the SEND frame, and then calls then, if not nil.
+// Returns once the messages have been passed to the subscription.
+func overflowHelper(c *C, capacity int, overflow OverflowPolicy, count int, then func(rw *fakeReaderWriter)) (*Conn, *Subscription, *fakeReaderWriter) {
+	return bufferHelper(c, ConnOpt.SubscriptionBuffer(capacity, overflow), nil, count, then)
+}
+
+// Like overflowHelper, but connects with connOpt and subscribes with subOpt,
+// either of which can be nil.
+func bufferHelper(c *C, connOpt func(*Conn) error, subOpt func(*frame.Frame) error, count int, then func(rw *fakeReaderWriter)) (*Conn, *Subscription, *fakeReaderWriter) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	rw := &fakeReaderWriter{
+		reader: frame.NewReader(fc2),
+		writer: frame.NewWriter(fc2),
+		conn:   fc2,
+	}
+
+	go func() {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.CONNECT)
+		rw.Write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SUBSCRIBE)
+		c.Check(f.Header.Len(), Equals, 3) // destination, ack and id
+		id := f.Header.Get(frame.Id)
+
+		f, err = rw.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		for i := 1; i <= count; i++ {
+			msg := frame.New(frame.MESSAGE,
+				frame.Subscription, id,
+				frame.MessageId, fmt.Sprint(i),
+				frame.Destination, "/queue/test-1")
+			msg.Body = []byte(fmt.Sprint(i))
+			rw.Write(msg)
+		}
+		rw.Write(frame.New(frame.RECEIPT, frame.ReceiptId, f.Header.Get(frame.Receipt)))
+
+		if then != nil {
+			then(rw)
+		}
+	}()
+
+	conn, err := Connect(fc1, connOpt)
+	c.Assert(err, IsNil)
+
+	var subOpts []func(*frame.Frame) error
+	if subOpt != nil {
+		subOpts = append(subOpts, subOpt)
+	}
+	sub, err := conn.Subscribe("/queue/test-1", AckAuto, subOpts...)
+	c.Assert(err, IsNil)
+
+	err = conn.Send("/queue/test-2", "text/plain", nil, SendOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	return conn, sub, rw
+}
+
+func (s *StompSuite) Test_subscription_buffer_invalid(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	defer fc2.Close()
+
+	_, err := Connect(fc1, ConnOpt.SubscriptionBuffer(0, OverflowBlock))
+	c.Assert(err, Equals, ErrInvalidBufferCapacity)
+
+	_, err = Connect(fc1, ConnOpt.SubscriptionBuffer(1, OverflowPolicy(99)))
+	c.Assert(err, Equals, ErrInvalidOverflowPolicy)
+}
+
+func (s *StompSuite) Test_subscribe_buffer_invalid(c *C) {
+	f := frame.New(frame.SUBSCRIBE)
+	c.Assert(SubscribeOpt.Buffer(0, OverflowBlock)(f), Equals, ErrInvalidBufferCapacity)
+	c.Assert(SubscribeOpt.Buffer(1, OverflowPolicy(99))(f), Equals, ErrInvalidOverflowPolicy)
+
+	f = frame.New(frame.UNSUBSCRIBE)
+	c.Assert(SubscribeOpt.Buffer(1, OverflowBlock)(f), Equals, ErrInvalidCommand)
+}
+
+func (s *StompSuite) Test_subscribe_buffer_overrides_connection(c *C) {
+	// The connection option would block, but the subscription drops.
+	_, sub, rw := bufferHelper(c, ConnOpt.SubscriptionBuffer(1, OverflowBlock),
+		SubscribeOpt.Buffer(2, OverflowDropNewest), 5, nil)
+	defer rw.Close()
+
+	c.Assert(string((<-sub.C).Body), Equals, "1")
+	c.Assert(string((<-sub.C).Body), Equals, "2")
+	c.Assert(sub.Dropped(), Equals, uint64(3))
+}
+
+func (s *StompSuite) Test_subscription_overflow_drop_oldest(c *C) {
+	_, sub, rw := overflowHelper(c, 2, OverflowDropOldest, 5, nil)
+	defer rw.Close()
+
+	c.Assert(string((<-sub.C).Body), Equals, "4")
+	c.Assert(string((<-sub.C).Body), Equals, "5")
+	c.Assert(sub.Dropped(), Equals, uint64(3))
+}
+
+func (s *StompSuite) Test_subscription_overflow_drop_newest(c *C) {
+	_, sub, rw := overflowHelper(c, 2, OverflowDropNewest, 5, nil)
+	defer rw.Close()
+
+	c.Assert(string((<-sub.C).Body), Equals, "1")
+	c.Assert(string((<-sub.C).Body), Equals, "2")
+	c.Assert(sub.Dropped(), Equals, uint64(3))
+}
+
+func (s *StompSuite) Test_subscription_overflow_block_timeout(c *C) {
+	// The subscription blocks the connection until the block timeout
+	// expires, and then fails as for OverflowError.
+	unsubscribed := make(chan *frame.Frame, 1)
+	connOpt := func(conn *Conn) error {
+		if err := ConnOpt.SubscriptionBuffer(2, OverflowBlock)(conn); err != nil {
+			return err
+		}
+		return ConnOpt.OverflowBlockTimeout(50 * time.Millisecond)(conn)
+	}
+	_, sub, rw := bufferHelper(c, connOpt, nil, 5, func(rw *fakeReaderWriter) {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		unsubscribed <- f
+	})
+	defer rw.Close()
+
+	f := <-unsubscribed
+	c.Assert(f.Command, Equals, frame.UNSUBSCRIBE)
+	c.Assert(f.Header.Get(frame.Id), Equals, sub.Id())
+	c.Assert(sub.Active(), Equals, false)
+
+	c.Assert(string((<-sub.C).Body), Equals, "1")
+	c.Assert(string((<-sub.C).Body), Equals, "2")
+	msg := <-sub.C
+	c.Assert(msg.Err, Equals, ErrSubscriptionOverflow)
+	_, ok := <-sub.C
+	c.Assert(ok, Equals, false)
+}
+
+func (s *StompSuite) Test_subscription_overflow_error(c *C) {
+	// The UNSUBSCRIBE frame is sent as soon as the buffer overflows,
+	// which is before the RECEIPT for the SEND frame is processed.
+	unsubscribed := make(chan *frame.Frame, 1)
+	_, sub, rw := overflowHelper(c, 2, OverflowError, 5, func(rw *fakeReaderWriter) {
+		f, err := rw.Read()
+		c.Check(err, IsNil)
+		unsubscribed <- f
+	})
+	defer rw.Close()
+
+	f := <-unsubscribed
+	c.Assert(f.Command, Equals, frame.UNSUBSCRIBE)
+	c.Assert(f.Header.Get(frame.Id), Equals, sub.Id())
+	c.Assert(sub.Active(), Equals, false)
+
+	c.Assert(string((<-sub.C).Body), Equals, "1")
+	c.Assert(string((<-sub.C).Body), Equals, "2")
+	msg := <-sub.C
+	c.Assert(msg.Err, Equals, ErrSubscriptionOverflow)
+	_, ok := <-sub.C
+	c.Assert(ok, Equals, false)
+}
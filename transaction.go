@@ -1,6 +1,8 @@
 package stomp
 
 import (
+	"context"
+
 	"github.com/go-stomp/stomp/frame"
 )
 
@@ -31,14 +33,28 @@ func (tx *Transaction) Conn() *Conn {
 }
 
 // Abort will abort the transaction. Any calls to Send, SendWithReceipt,
-// Ack and Nack on this transaction will be discarded.
-func (tx *Transaction) Abort() error {
+// Ack and Nack on this transaction will be discarded. Options in opts
+// can be used to request a receipt for the ABORT frame (see FrameOpt).
+func (tx *Transaction) Abort(opts ...func(*frame.Frame) error) error {
+	return tx.AbortContext(context.Background(), opts...)
+}
+
+// AbortContext is like Abort, but returns ctx.Err() if ctx is cancelled
+// or its deadline expires before the ABORT frame has been passed to the
+// connection or, if a receipt was requested, before the RECEIPT frame has
+// been received. In this case the transaction is not completed.
+func (tx *Transaction) AbortContext(ctx context.Context, opts ...func(*frame.Frame) error) error {
 	if tx.completed {
 		return ErrCompletedTransaction
 	}
 
 	f := frame.New(frame.ABORT, frame.Transaction, tx.id)
-	tx.conn.sendFrame(f)
+	if err := applyFrameOptions(f, opts); err != nil {
+		return err
+	}
+	if err := tx.conn.sendFrameContext(ctx, f); err != nil {
+		return err
+	}
 	tx.completed = true
 
 	return nil
@@ -46,13 +62,28 @@ func (tx *Transaction) Abort() error {
 
 // Commit will commit the transaction. All messages and acknowledgements
 // sent to the STOMP server on this transaction will be processed atomically.
-func (tx *Transaction) Commit() error {
+// Options in opts can be used to request a receipt for the COMMIT frame
+// (see FrameOpt).
+func (tx *Transaction) Commit(opts ...func(*frame.Frame) error) error {
+	return tx.CommitContext(context.Background(), opts...)
+}
+
+// CommitContext is like Commit, but returns ctx.Err() if ctx is cancelled
+// or its deadline expires before the COMMIT frame has been passed to the
+// connection or, if a receipt was requested, before the RECEIPT frame has
+// been received. In this case the transaction is not completed.
+func (tx *Transaction) CommitContext(ctx context.Context, opts ...func(*frame.Frame) error) error {
 	if tx.completed {
 		return ErrCompletedTransaction
 	}
 
 	f := frame.New(frame.COMMIT, frame.Transaction, tx.id)
-	tx.conn.sendFrame(f)
+	if err := applyFrameOptions(f, opts); err != nil {
+		return err
+	}
+	if err := tx.conn.sendFrameContext(ctx, f); err != nil {
+		return err
+	}
 	tx.completed = true
 
 	return nil
@@ -79,15 +110,14 @@ func (tx *Transaction) Send(destination, contentType string, body []byte, opts .
 	}
 
 	f.Header.Set(frame.Transaction, tx.id)
-	tx.conn.sendFrame(f)
-	return nil
+	return tx.conn.sendFrame(f)
 }
 
 // Ack sends an acknowledgement for the message to the server. The STOMP
 // server will not process the acknowledgement until the transaction
 // has been committed. If the subscription has an AckMode of AckAuto, calling
 // this function has no effect.
-func (tx *Transaction) Ack(msg *Message) error {
+func (tx *Transaction) Ack(msg *Message, opts ...func(*frame.Frame) error) error {
 	if tx.completed {
 		return ErrCompletedTransaction
 	}
@@ -98,8 +128,11 @@ func (tx *Transaction) Ack(msg *Message) error {
 	}
 
 	if f != nil {
+		if err = applyFrameOptions(f, opts); err != nil {
+			return err
+		}
 		f.Header.Set(frame.Transaction, tx.id)
-		tx.conn.sendFrame(f)
+		return tx.conn.sendFrame(f)
 	}
 
 	return nil
@@ -112,7 +145,7 @@ func (tx *Transaction) Ack(msg *Message) error {
 // It is an error to call this method if the subscription has an AckMode
 // of AckAuto, because the STOMP server will not be expecting any kind
 // of acknowledgement (positive or negative) for this message.
-func (tx *Transaction) Nack(msg *Message) error {
+func (tx *Transaction) Nack(msg *Message, opts ...func(*frame.Frame) error) error {
 	if tx.completed {
 		return ErrCompletedTransaction
 	}
@@ -123,8 +156,11 @@ func (tx *Transaction) Nack(msg *Message) error {
 	}
 
 	if f != nil {
+		if err = applyFrameOptions(f, opts); err != nil {
+			return err
+		}
 		f.Header.Set(frame.Transaction, tx.id)
-		tx.conn.sendFrame(f)
+		return tx.conn.sendFrame(f)
 	}
 
 	return nil
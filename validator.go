@@ -1,6 +1,9 @@
 package stomp
 
 import (
+	"strings"
+	"unicode/utf8"
+
 	"github.com/go-stomp/stomp/frame"
 )
 
@@ -10,12 +13,171 @@ type Validator interface {
 	Validate(f *frame.Frame) error
 }
 
+// NewValidator returns a Validator that checks frames against the rules
+// of the specified version of the STOMP protocol. The validator checks
+// that the command is defined by the protocol version, that the headers
+// required for the command are present and those prohibited are absent,
+// that header names and values can be encoded, and that any content-length
+// header matches the length of the body. If the version is not supported,
+// the validator rejects every frame with ErrUnsupportedVersion.
 func NewValidator(version Version) Validator {
-	return validatorNull{}
+	if err := version.CheckSupported(); err != nil {
+		return validatorError{err}
+	}
+	return &validator{version: version, rules: commandRules[version]}
+}
+
+// commandRule describes the headers required and prohibited by
+// a STOMP command.
+type commandRule struct {
+	required   []string
+	prohibited []string
+	body       bool // command can have a body
 }
 
-type validatorNull struct{}
+// commandRules contains the command rules for each STOMP version. A
+// command without a rule is not defined by the version.
+var commandRules = map[Version]map[string]commandRule{
+	V10: {
+		frame.CONNECT:     {prohibited: []string{frame.Receipt}},
+		frame.CONNECTED:   {},
+		frame.SEND:        {required: []string{frame.Destination}, body: true},
+		frame.SUBSCRIBE:   {required: []string{frame.Destination}},
+		frame.UNSUBSCRIBE: {},
+		frame.ACK:         {required: []string{frame.MessageId}},
+		frame.BEGIN:       {required: []string{frame.Transaction}},
+		frame.COMMIT:      {required: []string{frame.Transaction}},
+		frame.ABORT:       {required: []string{frame.Transaction}},
+		frame.DISCONNECT:  {},
+		frame.MESSAGE:     {required: []string{frame.Destination, frame.MessageId}, body: true},
+		frame.RECEIPT:     {required: []string{frame.ReceiptId}},
+		frame.ERROR:       {body: true},
+	},
+	V11: {
+		frame.CONNECT:     {required: []string{frame.AcceptVersion, frame.Host}, prohibited: []string{frame.Receipt}},
+		frame.STOMP:       {required: []string{frame.AcceptVersion, frame.Host}, prohibited: []string{frame.Receipt}},
+		frame.CONNECTED:   {required: []string{frame.Version}},
+		frame.SEND:        {required: []string{frame.Destination}, body: true},
+		frame.SUBSCRIBE:   {required: []string{frame.Destination, frame.Id}},
+		frame.UNSUBSCRIBE: {required: []string{frame.Id}},
+		frame.ACK:         {required: []string{frame.MessageId, frame.Subscription}},
+		frame.NACK:        {required: []string{frame.MessageId, frame.Subscription}},
+		frame.BEGIN:       {required: []string{frame.Transaction}},
+		frame.COMMIT:      {required: []string{frame.Transaction}},
+		frame.ABORT:       {required: []string{frame.Transaction}},
+		frame.DISCONNECT:  {},
+		frame.MESSAGE:     {required: []string{frame.Destination, frame.MessageId, frame.Subscription}, body: true},
+		frame.RECEIPT:     {required: []string{frame.ReceiptId}},
+		frame.ERROR:       {body: true},
+	},
+	V12: {
+		frame.CONNECT:     {required: []string{frame.AcceptVersion, frame.Host}, prohibited: []string{frame.Receipt}},
+		frame.STOMP:       {required: []string{frame.AcceptVersion, frame.Host}, prohibited: []string{frame.Receipt}},
+		frame.CONNECTED:   {required: []string{frame.Version}},
+		frame.SEND:        {required: []string{frame.Destination}, body: true},
+		frame.SUBSCRIBE:   {required: []string{frame.Destination, frame.Id}},
+		frame.UNSUBSCRIBE: {required: []string{frame.Id}},
+		frame.ACK:         {required: []string{frame.Id}},
+		frame.NACK:        {required: []string{frame.Id}},
+		frame.BEGIN:       {required: []string{frame.Transaction}},
+		frame.COMMIT:      {required: []string{frame.Transaction}},
+		frame.ABORT:       {required: []string{frame.Transaction}},
+		frame.DISCONNECT:  {},
+		frame.MESSAGE:     {required: []string{frame.Destination, frame.MessageId, frame.Subscription}, body: true},
+		frame.RECEIPT:     {required: []string{frame.ReceiptId}},
+		frame.ERROR:       {body: true},
+	},
+}
+
+type validator struct {
+	version Version
+	rules   map[string]commandRule
+}
+
+func (v *validator) Validate(f *frame.Frame) error {
+	rule, ok := v.rules[f.Command]
+	if !ok {
+		return ErrInvalidCommand
+	}
+
+	for _, name := range rule.required {
+		if _, ok := f.Header.Contains(name); !ok {
+			return missingHeader(name)
+		}
+	}
+	for _, name := range rule.prohibited {
+		if _, ok := f.Header.Contains(name); ok {
+			return prohibitedHeader(name)
+		}
+	}
+
+	// STOMP 1.0 identifies the subscription by either id or destination.
+	if v.version == V10 && f.Command == frame.UNSUBSCRIBE {
+		_, hasId := f.Header.Contains(frame.Id)
+		_, hasDest := f.Header.Contains(frame.Destination)
+		if !hasId && !hasDest {
+			return missingHeader(frame.Id)
+		}
+	}
+
+	if err := v.validateEncoding(f); err != nil {
+		return err
+	}
+
+	contentLength, ok, err := f.Header.ContentLength()
+	if err != nil {
+		return ErrInvalidContentLength
+	}
+	if ok && contentLength != len(f.Body) {
+		return ErrContentLengthMismatch
+	}
 
-func (v validatorNull) Validate(f *frame.Frame) error {
+	// The body restriction was introduced in STOMP 1.1.
+	if len(f.Body) > 0 && !rule.body && v.version != V10 {
+		return ErrBodyNotAllowed
+	}
+
+	return nil
+}
+
+// validateEncoding checks that each header entry can be encoded in
+// a frame. STOMP 1.1 and later escape header entries, except in the
+// CONNECT and CONNECTED frames, and require them to be valid UTF-8.
+// Header entries that are not escaped cannot contain an end of line,
+// and their names cannot contain a colon.
+func (v *validator) validateEncoding(f *frame.Frame) error {
+	escaped := v.version != V10 &&
+		f.Command != frame.CONNECT &&
+		f.Command != frame.STOMP &&
+		f.Command != frame.CONNECTED
+
+	// STOMP 1.2 allows an end of line to be a carriage return
+	// followed by a line feed.
+	eol := "\n"
+	if v.version == V12 {
+		eol = "\r\n"
+	}
+
+	for i := 0; i < f.Header.Len(); i++ {
+		key, value := f.Header.GetAt(i)
+		if key == "" {
+			return invalidHeader(key)
+		}
+		if v.version != V10 && (!utf8.ValidString(key) || !utf8.ValidString(value)) {
+			return invalidHeader(key)
+		}
+		if !escaped && (strings.ContainsAny(key, ":"+eol) || strings.ContainsAny(value, eol)) {
+			return invalidHeader(key)
+		}
+	}
 	return nil
 }
+
+// validatorError rejects every frame with the same error.
+type validatorError struct {
+	err error
+}
+
+func (v validatorError) Validate(f *frame.Frame) error {
+	return v.err
+}
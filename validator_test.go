@@ -0,0 +1,111 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/testutil"
+	. "gopkg.in/check.v1"
+)
+
+func (s *StompSuite) TestValidator(c *C) {
+	testcases := []struct {
+		Version Version
+		Frame   *frame.Frame
+		Err     string // empty if valid
+	}{
+		{V12, frame.New(frame.SEND, frame.Destination, "/queue/a"), ""},
+		{V12, frame.New(frame.SEND), "missing header: destination"},
+		{V12, frame.New("UNKNOWN"), "invalid command"},
+		{V10, frame.New(frame.STOMP, frame.AcceptVersion, "1.0", frame.Host, "h"), "invalid command"},
+		{V10, frame.New(frame.NACK, frame.MessageId, "1"), "invalid command"},
+		{V11, frame.New(frame.NACK, frame.MessageId, "1", frame.Subscription, "1"), ""},
+
+		// required and prohibited headers
+		{V10, frame.New(frame.CONNECT), ""},
+		{V11, frame.New(frame.CONNECT, frame.AcceptVersion, "1.1"), "missing header: host"},
+		{V12, frame.New(frame.STOMP, frame.AcceptVersion, "1.2", frame.Host, "h", frame.Receipt, "1"), "prohibited header: receipt"},
+		{V10, frame.New(frame.SUBSCRIBE, frame.Destination, "/queue/a"), ""},
+		{V11, frame.New(frame.SUBSCRIBE, frame.Destination, "/queue/a"), "missing header: id"},
+		{V10, frame.New(frame.UNSUBSCRIBE, frame.Destination, "/queue/a"), ""},
+		{V10, frame.New(frame.UNSUBSCRIBE), "missing header: id"},
+		{V11, frame.New(frame.ACK, frame.MessageId, "1"), "missing header: subscription"},
+		{V12, frame.New(frame.ACK, frame.MessageId, "1"), "missing header: id"},
+		{V12, frame.New(frame.ACK, frame.Id, "1"), ""},
+		{V12, frame.New(frame.BEGIN), "missing header: transaction"},
+		{V12, frame.New(frame.MESSAGE, frame.Destination, "/queue/a", frame.MessageId, "1"), "missing header: subscription"},
+		{V12, frame.New(frame.RECEIPT), "missing header: receipt-id"},
+		{V10, frame.New(frame.CONNECTED), ""},
+		{V11, frame.New(frame.CONNECTED), "missing header: version"},
+
+		// header encoding
+		{V10, frame.New(frame.SEND, frame.Destination, "/queue/a", "x", "a:b"), ""},
+		{V10, frame.New(frame.SEND, frame.Destination, "/queue/a", "x", "a\nb"), "invalid header: x"},
+		{V10, frame.New(frame.SEND, frame.Destination, "/queue/a", "x:y", "a"), "invalid header: x:y"},
+		{V10, frame.New(frame.SEND, frame.Destination, "/queue/a", "", "a"), "invalid header: "},
+		{V11, frame.New(frame.SEND, frame.Destination, "/queue/a", "x:y", "a\nb"), ""},
+		{V11, frame.New(frame.SEND, frame.Destination, "/queue/a", "x", "\xff"), "invalid header: x"},
+		{V11, frame.New(frame.CONNECT, frame.AcceptVersion, "1.1", frame.Host, "h", "x", "a\rb"), ""},
+		{V12, frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Host, "h", "x", "a\rb"), "invalid header: x"},
+		{V12, frame.New(frame.CONNECTED, frame.Version, "1.2", "x", "a\nb"), "invalid header: x"},
+
+		// content-length and body
+		{V12, frame.New(frame.SEND, frame.Destination, "/queue/a", frame.ContentLength, "-1"), "invalid content-length"},
+		{V12, frame.New(frame.SEND, frame.Destination, "/queue/a", frame.ContentLength, "1"), "content-length does not match body length"},
+		{V12, frame.New(frame.SEND, frame.Destination, "/queue/a", frame.ContentLength, "0"), ""},
+	}
+
+	for i, tc := range testcases {
+		err := NewValidator(tc.Version).Validate(tc.Frame)
+		if tc.Err == "" {
+			c.Check(err, IsNil, Commentf("test case %d", i))
+		} else {
+			c.Check(err, ErrorMatches, tc.Err, Commentf("test case %d", i))
+		}
+	}
+
+	f := frame.New(frame.SEND, frame.Destination, "/queue/a", frame.ContentLength, "5")
+	f.Body = []byte("hello")
+	c.Check(NewValidator(V12).Validate(f), IsNil)
+
+	f = frame.New(frame.BEGIN, frame.Transaction, "tx-1")
+	f.Body = []byte("hello")
+	c.Check(NewValidator(V10).Validate(f), IsNil)
+	c.Check(NewValidator(V12).Validate(f), Equals, ErrBodyNotAllowed)
+
+	c.Check(NewValidator("2.0").Validate(f), Equals, ErrUnsupportedVersion)
+}
+
+func (s *StompSuite) Test_conn_validate(c *C) {
+	fc1, fc2 := testutil.NewFakeConn(c)
+	reader := frame.NewReader(fc2)
+	writer := frame.NewWriter(fc2)
+	defer fc2.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f, err := reader.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.CONNECT)
+		writer.Write(frame.New(frame.CONNECTED, frame.Version, "1.0"))
+
+		// only the valid frame is sent
+		f, err = reader.Read()
+		c.Check(err, IsNil)
+		c.Check(f.Command, Equals, frame.SEND)
+		c.Check(f.Header.Get("x"), Equals, "valid")
+	}()
+
+	conn, err := Connect(fc1, ConnOpt.Validate)
+	c.Assert(err, IsNil)
+
+	err = conn.Send("/queue/a", "text/plain", []byte("hello"),
+		SendOpt.Header("x", "not\nvalid"))
+	c.Check(err, ErrorMatches, "invalid header: x")
+	_, err = conn.Subscribe("/queue/a", AckAuto,
+		SubscribeOpt.Header(frame.Receipt, "a\nb"))
+	c.Check(err, ErrorMatches, "invalid header: receipt")
+	err = conn.Send("/queue/a", "text/plain", []byte("hello"),
+		SendOpt.Header("x", "valid"))
+	c.Check(err, IsNil)
+	<-done
+}
@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Dialer contains options for connecting to a STOMP server
+// using WebSocket. The zero value is a valid Dialer.
+type Dialer struct {
+	// TLSConfig specifies the TLS configuration for "wss" URLs.
+	// If nil, the default configuration is used.
+	TLSConfig *tls.Config
+
+	// Header specifies additional HTTP header entries to send
+	// in the opening handshake request, such as "Origin".
+	Header http.Header
+
+	// NetDialer is used to create the network connection.
+	// If nil, a zero net.Dialer is used.
+	NetDialer *net.Dialer
+}
+
+// Dial connects to the WebSocket URL urlStr using the default Dialer,
+// and performs the opening handshake. The header parameter specifies
+// additional HTTP header entries for the handshake request, and can be nil.
+func Dial(urlStr string, header http.Header) (*Conn, error) {
+	d := &Dialer{Header: header}
+	return d.DialContext(context.Background(), urlStr)
+}
+
+// Dial connects to the WebSocket URL urlStr and performs the opening
+// handshake. The URL scheme must be "ws" or "wss".
+func (d *Dialer) Dial(urlStr string) (*Conn, error) {
+	return d.DialContext(context.Background(), urlStr)
+}
+
+// DialContext is like Dial, but uses ctx to bound the time taken to
+// create the network connection and perform the opening handshake.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string) (*Conn, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var secure bool
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		secure = true
+	default:
+		return nil, ErrBadHandshake
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	netDialer := d.NetDialer
+	if netDialer == nil {
+		netDialer = &net.Dialer{}
+	}
+
+	var conn net.Conn
+	if secure {
+		tlsDialer := &tls.Dialer{NetDialer: netDialer, Config: d.TLSConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = netDialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Abandon the handshake if ctx is done before it completes.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	c, err := d.handshake(conn, u)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake performs the client side of the opening handshake,
+// RFC 6455 section 4.1.
+func (d *Dialer) handshake(conn net.Conn, u *url.URL) (*Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", strings.Join(Subprotocols, ", "))
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		!headerContains(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != computeAccept(key) {
+		return nil, ErrBadHandshake
+	}
+
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !containsToken(Subprotocols, subprotocol) {
+		return nil, ErrBadHandshake
+	}
+
+	return newConn(conn, reader, true, subprotocol), nil
+}
+
+// headerContains returns true if the comma-separated list of tokens
+// in header entry name contains token, ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
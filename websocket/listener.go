@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A Listener accepts STOMP connections over WebSocket. It is both an
+// http.Handler, which upgrades HTTP requests to WebSocket connections,
+// and a net.Listener, which returns the WebSocket connections from its
+// Accept method. Pass a Listener to the server.Serve function to accept
+// STOMP clients that connect using WebSocket.
+type Listener struct {
+	// CheckOrigin, if not nil, is called to check the Origin header of
+	// the HTTP request. If it returns false, the request is rejected.
+	// If nil, requests are accepted from any origin.
+	CheckOrigin func(r *http.Request) bool
+
+	ch        chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener creates a Listener. Connections are accepted when the
+// Listener's ServeHTTP method is called by an HTTP server.
+func NewListener() *Listener {
+	return &Listener{
+		ch:   make(chan net.Conn),
+		done: make(chan struct{}),
+	}
+}
+
+// ServeHTTP performs the server side of the WebSocket opening handshake,
+// RFC 6455 section 4.2, and passes the connection to Accept.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerContains(r.Header, "Connection", "upgrade") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+	if l.CheckOrigin != nil && !l.CheckOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Choose the first of our subprotocols that the client offers. If the
+	// client does not offer any subprotocols, proceed without one, as not
+	// all clients request a subprotocol.
+	subprotocol := ""
+	for _, p := range Subprotocols {
+		if headerContains(r.Header, "Sec-WebSocket-Protocol", p) {
+			subprotocol = p
+			break
+		}
+	}
+	if subprotocol == "" && r.Header.Get("Sec-WebSocket-Protocol") != "" {
+		http.Error(w, "unsupported websocket subprotocol", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+
+	select {
+	case <-l.done:
+		http.Error(w, "server closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + computeAccept(key) + "\r\n"
+	if subprotocol != "" {
+		response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	response += "\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return
+	}
+
+	c := newConn(conn, rw.Reader, false, subprotocol)
+	select {
+	case l.ch <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// Accept waits for and returns the next WebSocket connection.
+// Returns ErrListenerClosed after the Listener has been closed.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ch:
+		return c, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close closes the Listener. Any blocked Accept operations are unblocked
+// and return ErrListenerClosed. Subsequent WebSocket upgrade requests are
+// rejected. Connections that have already been accepted are not closed.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr returns a placeholder address, as the network address is
+// determined by the HTTP server that calls ServeHTTP.
+func (l *Listener) Addr() net.Addr {
+	return addr{}
+}
+
+type addr struct{}
+
+func (addr) Network() string { return "websocket" }
+func (addr) String() string  { return "websocket" }
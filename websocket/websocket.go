@@ -0,0 +1,358 @@
+/*
+Package websocket provides a transport for STOMP over WebSocket, as used by
+web browsers and many gateways.
+
+A client creates a WebSocket connection to a STOMP server using a Dialer,
+and then passes the connection to the stomp.Connect function.
+
+	conn, err := websocket.Dial("ws://localhost:8080/stomp", nil)
+	if err != nil {
+		return err
+	}
+	client, err := stomp.Connect(conn)
+
+A server creates a Listener, which is an http.Handler that upgrades HTTP
+requests to WebSocket connections, and passes it to the server.Serve
+function, which accepts the WebSocket connections from the Listener.
+
+	l := websocket.NewListener()
+	http.Handle("/stomp", l)
+	go http.ListenAndServe(":8080", nil)
+	server.Serve(l)
+
+Each STOMP frame (or heart-beat) is sent as a single WebSocket message. This
+package implements the parts of RFC 6455 needed for STOMP: it does not
+support WebSocket extensions.
+*/
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Subprotocols are the WebSocket subprotocol names for the versions of the
+// STOMP protocol, in order of preference.
+var Subprotocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+// Error values
+var (
+	ErrBadHandshake   = errors.New("websocket: bad handshake")
+	ErrInvalidFrame   = errors.New("websocket: invalid frame")
+	ErrListenerClosed = errors.New("websocket: listener closed")
+)
+
+// WebSocket opcodes, RFC 6455 section 5.2
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Used to calculate the Sec-WebSocket-Accept header, RFC 6455 section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Maximum payload length of a control frame.
+const maxControlPayload = 125
+
+// A Conn is a WebSocket connection that carries STOMP frames. It
+// implements the net.Conn interface, so that it can be used by the
+// stomp.Connect function, and by the STOMP server.
+//
+// Reading from a Conn returns the contents of the WebSocket messages
+// received, in the order they are received. Data written to a Conn is
+// buffered until Flush is called, and then sent as a single WebSocket
+// message. Conn implements the frame.Flusher interface, so a frame.Writer
+// calls Flush after writing each STOMP frame (or heart-beat).
+type Conn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	client      bool   // client connections mask frames they send
+	subprotocol string // negotiated subprotocol
+
+	// read state, only accessed by the reading go-routine
+	remaining uint64  // unread payload bytes in the current frame
+	masked    bool    // is the current frame masked
+	mask      [4]byte // masking key for the current frame
+	maskPos   int     // position in the masking key
+	closed    bool    // has a close frame been received
+
+	writeMutex sync.Mutex
+	writeBuf   []byte // buffers data until the next flush
+	closeOnce  sync.Once
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool, subprotocol string) *Conn {
+	if reader == nil {
+		reader = bufio.NewReader(conn)
+	}
+	return &Conn{
+		conn:        conn,
+		reader:      reader,
+		client:      client,
+		subprotocol: subprotocol,
+	}
+}
+
+// Subprotocol returns the WebSocket subprotocol negotiated during the
+// opening handshake, or a blank string if no subprotocol was negotiated.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Read reads data received in WebSocket messages. Control frames are
+// processed as they are received. Returns io.EOF when the other end has
+// closed the WebSocket connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.readFrameHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos]
+			c.maskPos = (c.maskPos + 1) % 4
+		}
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// readFrameHeader reads the next frame header. Data frames set up the
+// read state so that the payload can be read. Control frames are read
+// and processed in full.
+func (c *Conn) readFrameHeader() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	opcode := header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		// reserved bits are only used by extensions
+		return ErrInvalidFrame
+	}
+
+	// Frames sent by the client must be masked, and frames
+	// sent by the server must not.
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return ErrInvalidFrame
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	c.masked = masked
+	c.maskPos = 0
+	if masked {
+		if _, err := io.ReadFull(c.reader, c.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		c.remaining = length
+		return nil
+	case opClose, opPing, opPong:
+		if length > maxControlPayload || header[0]&0x80 == 0 {
+			return ErrInvalidFrame
+		}
+	default:
+		return ErrInvalidFrame
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= c.mask[i%4]
+		}
+	}
+
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opClose:
+		c.closed = true
+		// echo the status code back, as required by RFC 6455
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.writeFrame(opClose, payload)
+	}
+	return nil
+}
+
+// Write buffers p until Flush is called.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.writeBuf = append(c.writeBuf, p...)
+	return len(p), nil
+}
+
+// Flush sends the data buffered by Write as a single WebSocket message.
+// It does nothing if no data has been buffered.
+func (c *Conn) Flush() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if len(c.writeBuf) == 0 {
+		return nil
+	}
+
+	opcode := byte(opText)
+	if !utf8.Valid(c.writeBuf) {
+		opcode = opBinary
+	}
+	err := c.writeFrameLocked(opcode, c.writeBuf)
+	c.writeBuf = c.writeBuf[:0]
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked sends payload in a single WebSocket frame.
+// The caller must hold writeMutex.
+func (c *Conn) writeFrameLocked(opcode byte, payload []byte) error {
+	buf := make([]byte, 0, len(payload)+14)
+	buf = append(buf, 0x80|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(length))
+	default:
+		buf = append(buf, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(length))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range buf[start:] {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Close sends a WebSocket close frame, and closes the underlying
+// network connection.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		// normal closure status code 1000
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(opClose, []byte{0x03, 0xe8})
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the
+// underlying network connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying
+// network connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying
+// network connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ConnectionState returns the TLS connection state if the WebSocket
+// connection uses TLS. Otherwise the zero value is returned. The STOMP
+// server uses this to authenticate clients using TLS client certificates.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	if tlsConn, ok := c.conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+// computeAccept returns the value of the Sec-WebSocket-Accept header
+// for the Sec-WebSocket-Key header value key.
+func computeAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+acceptGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
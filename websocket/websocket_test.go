@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-stomp/stomp"
+	"github.com/go-stomp/stomp/frame"
+	"github.com/go-stomp/stomp/server"
+	. "gopkg.in/check.v1"
+)
+
+func TestWebSocket(t *testing.T) {
+	TestingT(t)
+}
+
+type WebSocketSuite struct{}
+
+var _ = Suite(&WebSocketSuite{})
+
+func (s *WebSocketSuite) TestComputeAccept(c *C) {
+	// example from RFC 6455 section 1.3
+	c.Check(computeAccept("dGhlIHNhbXBsZSBub25jZQ=="), Equals, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}
+
+func (s *WebSocketSuite) TestOneMessagePerFrame(c *C) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	clientConn := newConn(client, nil, true, "")
+	serverConn := newConn(server, nil, false, "")
+
+	// The body consists of NUL bytes, so the pieces written to the
+	// connection as the frame.Writer's small buffer fills end in NUL.
+	body := make([]byte, 33)
+	f := frame.New(frame.SEND, frame.Destination, "/queue/test-1",
+		frame.ContentLength, strconv.Itoa(len(body)))
+	f.Body = body
+
+	go func() {
+		writer := frame.NewWriterSize(clientConn, 16)
+		c.Check(writer.Write(f), IsNil)
+		c.Check(writer.Write(nil), IsNil)
+	}()
+
+	expected := "SEND\ndestination:/queue/test-1\ncontent-length:33\n\n" + string(body) + "\x00"
+	c.Assert(serverConn.readFrameHeader(), IsNil)
+	c.Assert(serverConn.remaining, Equals, uint64(len(expected)))
+	b := make([]byte, len(expected))
+	_, err := io.ReadFull(serverConn, b)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, expected)
+
+	c.Assert(serverConn.readFrameHeader(), IsNil)
+	c.Assert(serverConn.remaining, Equals, uint64(1))
+}
+
+func (s *WebSocketSuite) TestSendAndReceive(c *C) {
+	l := NewListener()
+	defer l.Close()
+	httpServer := httptest.NewServer(l)
+	defer httpServer.Close()
+	go server.Serve(l)
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	receiverConn, err := Dial(url, nil)
+	c.Assert(err, IsNil)
+	c.Check(receiverConn.Subprotocol(), Equals, "v12.stomp")
+	receiver, err := stomp.Connect(receiverConn)
+	c.Assert(err, IsNil)
+	sub, err := receiver.Subscribe("/topic/test-1", stomp.AckAuto, stomp.SubscribeOpt.Receipt)
+	c.Assert(err, IsNil)
+
+	senderConn, err := Dial(url, nil)
+	c.Assert(err, IsNil)
+	sender, err := stomp.Connect(senderConn)
+	c.Assert(err, IsNil)
+
+	// large enough to need a 64-bit payload length, and to be
+	// written to the connection in more than one piece
+	large := bytes.Repeat([]byte("0123456789"), 10000)
+
+	for _, body := range [][]byte{[]byte("hello"), large} {
+		err = sender.Send("/topic/test-1", "text/plain", body, stomp.SendOpt.Receipt)
+		c.Assert(err, IsNil)
+
+		msg := <-sub.C
+		c.Assert(msg.Err, IsNil)
+		c.Assert(msg.Body, DeepEquals, body)
+	}
+
+	c.Assert(sender.Disconnect(), IsNil)
+	c.Assert(receiver.Disconnect(), IsNil)
+}
+
+func (s *WebSocketSuite) TestUpgradeRequired(c *C) {
+	l := NewListener()
+	defer l.Close()
+	httpServer := httptest.NewServer(l)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusUpgradeRequired)
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	_, err = Dial("ws"+strings.TrimPrefix(notFound.URL, "http"), nil)
+	c.Check(err, Equals, ErrBadHandshake)
+}
+
+func (s *WebSocketSuite) TestListenerClosed(c *C) {
+	l := NewListener()
+	l.Close()
+	_, err := l.Accept()
+	c.Check(err, Equals, ErrListenerClosed)
+}